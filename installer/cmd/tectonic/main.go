@@ -1,7 +1,15 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"fmt"
 	"os"
+	"os/signal"
+	"regexp"
+	"strings"
+	"syscall"
+	"time"
 
 	log "github.com/Sirupsen/logrus"
 	"gopkg.in/alecthomas/kingpin.v2"
@@ -20,21 +28,125 @@ var (
 	clusterInstallBootstrapCommand = clusterInstallCommand.Command("bootstrap", "Create a single bootstrap node Tectonic cluster.")
 	clusterInstallFullCommand      = clusterInstallCommand.Command("full", "Create a new Tectonic cluster").Default()
 	clusterInstallJoinCommand      = clusterInstallCommand.Command("join", "Create master and worker nodes to join an exisiting Tectonic cluster.")
+	clusterInstallPlanCommand      = clusterInstallCommand.Command("plan", "Show and save the changes a full install would make, without applying them.")
 	clusterInstallDirFlag          = clusterInstallCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	clusterInstallResumeFlag       = clusterInstallCommand.Flag("resume", "Skip steps already completed by a previous run with the same inputs").Bool()
+	clusterInstallFromFlag         = clusterInstallCommand.Flag("from", "Start at the named step").String()
+	clusterInstallToFlag           = clusterInstallCommand.Flag("to", "Stop after the named step").String()
+	clusterInstallOnlyFlag         = clusterInstallCommand.Flag("only", "Run only the named step (repeatable)").Strings()
+	clusterInstallSkipFlag         = clusterInstallCommand.Flag("skip", "Skip the named step (repeatable)").Strings()
+	clusterInstallListStepsFlag    = clusterInstallCommand.Flag("list-steps", "Print the steps that would run and exit").Bool()
+	clusterInstallSavedPlansFlag   = clusterInstallCommand.Flag("saved-plans", "Apply the plans saved by 'install plan' instead of computing new ones").Bool()
+	clusterInstallParallelismFlag  = clusterInstallCommand.Flag("parallelism", "Maximum number of independent steps to run at the same time").Default("4").Int()
+	clusterInstallTimeoutFlag      = clusterInstallCommand.Flag("timeout", "Maximum duration of each step (e.g. \"30m\")").Duration()
+	clusterInstallStepTimeoutFlag  = clusterInstallCommand.Flag("step-timeout", "Maximum duration of the named step (e.g. \"etcd=30m\", repeatable)").StringMap()
+	clusterInstallRetriesFlag      = clusterInstallCommand.Flag("retries", "Maximum number of attempts of a step failing with a transient error").Default("3").Int()
+	clusterInstallRetryBackoffFlag = clusterInstallCommand.Flag("retry-backoff", "Delay before the first retry, doubled after each attempt").Default("10s").Duration()
+	clusterInstallRetryableFlag    = clusterInstallCommand.Flag("retryable-error", "Additional regular expression matching transient Terraform errors (repeatable)").Strings()
+	clusterInstallRollbackFlag     = clusterInstallCommand.Flag("rollback-on-failure", "Destroy the steps created by this run if it fails, unless it is interrupted").Bool()
 
-	clusterDestroyCommand = kingpin.Command("destroy", "Destroy an existing Tectonic cluster")
-	clusterDestroyDirFlag = clusterDestroyCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	clusterDestroyCommand          = kingpin.Command("destroy", "Destroy an existing Tectonic cluster")
+	clusterDestroyFullCommand      = clusterDestroyCommand.Command("full", "Destroy an existing Tectonic cluster").Default()
+	clusterDestroyPlanCommand      = clusterDestroyCommand.Command("plan", "Show and save the changes a destroy would make, without applying them.")
+	clusterDestroyWorkersCommand   = clusterDestroyCommand.Command("workers", "Destroy the worker nodes of a Tectonic cluster")
+	clusterDestroyMastersCommand   = clusterDestroyCommand.Command("masters", "Destroy the master nodes of a Tectonic cluster")
+	clusterDestroyEtcdCommand      = clusterDestroyCommand.Command("etcd", "Destroy the etcd nodes of a Tectonic cluster")
+	clusterDestroyBootstrapCommand = clusterDestroyCommand.Command("bootstrap", "Destroy the bootstrap node and TNC records of a Tectonic cluster")
+	clusterDestroyTopologyCommand  = clusterDestroyCommand.Command("topology", "Destroy the network of a Tectonic cluster")
+	clusterDestroyDirFlag          = clusterDestroyCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	clusterDestroyFromFlag         = clusterDestroyCommand.Flag("from", "Start at the named step").String()
+	clusterDestroyToFlag           = clusterDestroyCommand.Flag("to", "Stop after the named step").String()
+	clusterDestroyOnlyFlag         = clusterDestroyCommand.Flag("only", "Run only the named step (repeatable)").Strings()
+	clusterDestroySkipFlag         = clusterDestroyCommand.Flag("skip", "Skip the named step (repeatable)").Strings()
+	clusterDestroyListStepsFlag    = clusterDestroyCommand.Flag("list-steps", "Print the steps that would run and exit").Bool()
+	clusterDestroySavedPlansFlag   = clusterDestroyCommand.Flag("saved-plans", "Apply the plans saved by 'destroy plan' instead of computing new ones").Bool()
+	clusterDestroyParallelismFlag  = clusterDestroyCommand.Flag("parallelism", "Maximum number of independent steps to run at the same time").Default("4").Int()
+	clusterDestroyTimeoutFlag      = clusterDestroyCommand.Flag("timeout", "Maximum duration of each step (e.g. \"30m\")").Duration()
+	clusterDestroyStepTimeoutFlag  = clusterDestroyCommand.Flag("step-timeout", "Maximum duration of the named step (e.g. \"etcd=30m\", repeatable)").StringMap()
+	clusterDestroyForceFlag        = clusterDestroyCommand.Flag("force", "Destroy the targeted nodes even though other steps still rely on them").Bool()
+	clusterDestroyContinueFlag     = clusterDestroyCommand.Flag("continue-on-error", "Try every step, even after a failure, retry the failed steps once and report the outcome of each step").Bool()
+
+	applyCommand = kingpin.Command("apply", "Apply the changes made to config.yaml since it was last applied, re-running only the affected steps")
+	applyDirFlag = applyCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+
+	scaleCommand   = kingpin.Command("scale", "Change the number of nodes of a node pool of an existing Tectonic cluster")
+	scaleDirFlag   = scaleCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	scalePoolFlag  = scaleCommand.Flag("pool", "Name of the node pool").Required().String()
+	scaleCountFlag = scaleCommand.Flag("count", "Number of nodes of the node pool").Required().Int()
+	scaleYesFlag   = scaleCommand.Flag("yes", "Apply the changes without asking for confirmation").Bool()
+
+	unlockCommand   = kingpin.Command("unlock", "Remove the lock of a cluster directory left by an interrupted run")
+	unlockDirFlag   = unlockCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	unlockForceFlag = unlockCommand.Flag("force", "Remove the lock even if its owner may still be running").Bool()
+
+	logsCommand = kingpin.Command("logs", "Show the latest Terraform log of a step")
+	logsDirFlag = logsCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	logsStepArg = logsCommand.Arg("step", "Name of the step").Required().String()
+
+	statusCommand  = kingpin.Command("status", "Show what is deployed in a cluster directory, according to the Terraform state of each step")
+	statusDirFlag  = statusCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	statusJSONFlag = statusCommand.Flag("json", "Print the status as JSON").Bool()
+
+	outputCommand    = kingpin.Command("output", "Show the endpoints, credentials and Terraform outputs of a cluster")
+	outputDirFlag    = outputCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	outputFormatFlag = outputCommand.Flag("format", "Output format").Default(workflow.OutputFormatText).Enum(workflow.OutputFormatText, workflow.OutputFormatJSON, workflow.OutputFormatExport)
+	outputNameArg    = outputCommand.Arg("name", "Name of the output to show").String()
+
+	validateCommand    = kingpin.Command("validate", "Check a cluster config without creating a cluster directory")
+	validateConfigFlag = validateCommand.Flag("config", "Cluster config file").Required().ExistingFile()
+	validateOutputFlag = validateCommand.Flag("output", "Output format").Default(workflow.OutputFormatText).Enum(workflow.OutputFormatText, workflow.OutputFormatJSON)
+
+	stateCommand          = kingpin.Command("state", "Manage the Terraform state of a cluster")
+	stateMigrateCommand   = stateCommand.Command("migrate", "Copy the local state of each step to the state backend configured in config.yaml")
+	stateMigrateDirFlag   = stateMigrateCommand.Flag("dir", "Cluster directory").Default(".").ExistingDir()
+	stateMigrateForceFlag = stateMigrateCommand.Flag("force", "Overwrite states that already exist in the backend with a different content").Bool()
 
 	convertCommand    = kingpin.Command("convert", "Convert a tfvars.json to a Tectonic config.yaml")
 	convertConfigFlag = convertCommand.Flag("config", "tfvars.json file").Required().ExistingFile()
 
-	logLevel = kingpin.Flag("log-level", "log level (e.g. \"debug\")").Default("info").Enum("debug", "info", "warn", "error", "fatal", "panic")
+	terraformBinaryFlag = kingpin.Flag("terraform-binary", "Terraform binary to run, instead of looking it up next to the installer, in the cwd and in the PATH").ExistingFile()
+	offlineFlag         = kingpin.Flag("offline", "Use the Terraform providers of the plugin directory, set with --plugin-dir or in the config, instead of downloading them").Bool()
+	pluginDirFlag       = kingpin.Flag("plugin-dir", "Directory of the Terraform providers used in offline mode; implies --offline").ExistingDir()
+	lenientFlag         = kingpin.Flag("lenient", "Accept config keys that match no field, only warning about them, instead of refusing the config").Bool()
+	allowRecreateFlag   = kingpin.Flag("allow-recreate", "Accept changes of the config fields that cannot change once the cluster exists, such as its name, and recreate the resources depending on them").Bool()
+	logLevel            = kingpin.Flag("log-level", "log level (e.g. \"debug\")").Default("info").Enum("debug", "info", "warn", "error", "fatal", "panic")
 )
 
 func main() {
 	var w workflow.Workflow
+	var selection workflow.StepSelection
+	var listSteps bool
+	parallelism := 1
+	var timeout time.Duration
+	var stepTimeouts map[string]string
+
+	command := kingpin.Parse()
+	if strings.HasPrefix(command, clusterInstallCommand.FullCommand()) {
+		selection = workflow.StepSelection{
+			From: *clusterInstallFromFlag,
+			To:   *clusterInstallToFlag,
+			Only: *clusterInstallOnlyFlag,
+			Skip: *clusterInstallSkipFlag,
+		}
+		listSteps = *clusterInstallListStepsFlag
+		parallelism = *clusterInstallParallelismFlag
+		timeout = *clusterInstallTimeoutFlag
+		stepTimeouts = *clusterInstallStepTimeoutFlag
+	}
+	if strings.HasPrefix(command, clusterDestroyCommand.FullCommand()) {
+		selection = workflow.StepSelection{
+			From: *clusterDestroyFromFlag,
+			To:   *clusterDestroyToFlag,
+			Only: *clusterDestroyOnlyFlag,
+			Skip: *clusterDestroySkipFlag,
+		}
+		listSteps = *clusterDestroyListStepsFlag
+		parallelism = *clusterDestroyParallelismFlag
+		timeout = *clusterDestroyTimeoutFlag
+		stepTimeouts = *clusterDestroyStepTimeoutFlag
+	}
 
-	switch kingpin.Parse() {
+	switch command {
 	case clusterInitCommand.FullCommand():
 		w = workflow.InitWorkflow(*clusterInitConfigFlag)
 	case clusterInstallFullCommand.FullCommand():
@@ -49,8 +161,31 @@ func main() {
 		w = workflow.InstallBootstrapWorkflow(*clusterInstallDirFlag)
 	case clusterInstallJoinCommand.FullCommand():
 		w = workflow.InstallJoinWorkflow(*clusterInstallDirFlag)
-	case clusterDestroyCommand.FullCommand():
+	case clusterInstallPlanCommand.FullCommand():
+		w = workflow.InstallFullWorkflow(*clusterInstallDirFlag)
+		w.Plan()
+	case clusterDestroyFullCommand.FullCommand():
+		w = workflow.DestroyWorkflow(*clusterDestroyDirFlag)
+	case clusterDestroyPlanCommand.FullCommand():
 		w = workflow.DestroyWorkflow(*clusterDestroyDirFlag)
+		w.Plan()
+	case clusterDestroyWorkersCommand.FullCommand():
+		w = workflow.DestroyWorkersWorkflow(*clusterDestroyDirFlag)
+	case clusterDestroyMastersCommand.FullCommand():
+		w = workflow.DestroyMastersWorkflow(*clusterDestroyDirFlag)
+	case clusterDestroyEtcdCommand.FullCommand():
+		w = workflow.DestroyEtcdWorkflow(*clusterDestroyDirFlag)
+	case clusterDestroyBootstrapCommand.FullCommand():
+		w = workflow.DestroyBootstrapWorkflow(*clusterDestroyDirFlag)
+	case clusterDestroyTopologyCommand.FullCommand():
+		w = workflow.DestroyTopologyWorkflow(*clusterDestroyDirFlag)
+	case applyCommand.FullCommand():
+		w = workflow.ApplyWorkflow(*applyDirFlag)
+	case scaleCommand.FullCommand():
+		w = workflow.ScaleWorkflow(*scaleDirFlag, *scalePoolFlag, *scaleCountFlag)
+		if !*scaleYesFlag {
+			w.SetConfirm(confirm)
+		}
 	case convertCommand.FullCommand():
 		w = workflow.ConvertWorkflow(*convertConfigFlag)
 	}
@@ -62,8 +197,151 @@ func main() {
 	}
 	log.SetLevel(l)
 
-	if err := w.Execute(); err != nil {
+	if command == logsCommand.FullCommand() {
+		if err := workflow.ShowStepLog(os.Stdout, *logsDirFlag, *logsStepArg); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if command == statusCommand.FullCommand() {
+		status, err := workflow.Status(context.Background(), *statusDirFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := workflow.PrintStatus(os.Stdout, status, *statusJSONFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if command == outputCommand.FullCommand() {
+		outputs, err := workflow.Outputs(context.Background(), *outputDirFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := workflow.PrintOutputs(os.Stdout, outputs, *outputNameArg, *outputFormatFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if command == validateCommand.FullCommand() {
+		entries, err := workflow.ValidateConfig(*validateConfigFlag, *lenientFlag)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := workflow.PrintValidation(os.Stdout, entries, *validateOutputFlag); err != nil {
+			log.Fatal(err)
+		}
+		if workflow.HasValidationErrors(entries) {
+			os.Exit(1)
+		}
+		return
+	}
+	if command == stateMigrateCommand.FullCommand() {
+		if err := workflow.MigrateState(context.Background(), *stateMigrateDirFlag, *stateMigrateForceFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if command == unlockCommand.FullCommand() {
+		if err := workflow.Unlock(*unlockDirFlag, *unlockForceFlag); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
+	if *clusterInstallResumeFlag {
+		w.Resume()
+	}
+	if *clusterDestroyForceFlag {
+		w.ForceDestroy()
+	}
+	if *clusterDestroyContinueFlag {
+		w.ContinueOnError()
+	}
+	if *clusterInstallSavedPlansFlag || *clusterDestroySavedPlansFlag {
+		w.UseSavedPlans()
+	}
+	w.SetParallelism(parallelism)
+	if *offlineFlag || *pluginDirFlag != "" {
+		w.SetOffline(*pluginDirFlag)
+	}
+	if *lenientFlag {
+		w.Lenient()
+	}
+	if *allowRecreateFlag {
+		w.AllowRecreate()
+	}
+	if *terraformBinaryFlag != "" {
+		w.SetTerraformBinary(*terraformBinaryFlag)
+	}
+	if strings.HasPrefix(command, clusterInstallCommand.FullCommand()) {
+		policy := workflow.DefaultRetryPolicy
+		policy.MaxAttempts = *clusterInstallRetriesFlag
+		policy.Backoff = *clusterInstallRetryBackoffFlag
+		for _, expr := range *clusterInstallRetryableFlag {
+			re, err := regexp.Compile(expr)
+			if err != nil {
+				log.Fatalf("invalid retryable error %q: %v", expr, err)
+			}
+			policy.Retryable = append(policy.Retryable, re)
+		}
+		w.SetRetryPolicy(policy)
+		if *clusterInstallRollbackFlag {
+			w.RollbackOnFailure()
+		}
+	}
+	if timeout > 0 {
+		w.SetStepTimeout("", timeout)
+	}
+	for name, value := range stepTimeouts {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			log.Fatalf("invalid timeout for step %s: %v", name, err)
+		}
+		w.SetStepTimeout(name, d)
+	}
+
+	if err := w.Select(selection); err != nil {
+		log.Fatal(err)
+	}
+
+	if listSteps {
+		for i, step := range w.Steps() {
+			fmt.Printf("%2d. %s\n", i+1, step)
+		}
+		return
+	}
+
+	if err := w.Execute(interruptContext()); err != nil {
 		log.Fatal(err)
 		os.Exit(1)
 	}
 }
+
+// interruptContext returns a context that is cancelled once SIGINT or SIGTERM
+// is received, so that running steps can stop gracefully.
+func interruptContext() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-c
+		log.Warnf("Received %s, interrupting running steps", sig)
+		cancel()
+		for sig := range c {
+			log.Warnf("Received %s, still waiting for running steps to stop", sig)
+		}
+	}()
+	return ctx
+}
+
+// confirm asks the question on the terminal and returns whether the answer
+// is yes.
+func confirm(question string) (bool, error) {
+	fmt.Printf("%s Only 'yes' will be accepted: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return false, fmt.Errorf("failed to read the answer: %v", err)
+	}
+	return strings.TrimSpace(answer) == "yes", nil
+}
@@ -270,6 +270,25 @@ func marshalYAML(obj interface{}) (string, error) {
 	return string(data), nil
 }
 
+// Endpoints are the addresses a cluster is reached at, as derived from its
+// config.
+type Endpoints struct {
+	APIServerURL  string
+	BaseAddress   string
+	ConsoleURL    string
+	OIDCIssuerURL string
+}
+
+// Endpoints returns the addresses the cluster is reached at.
+func (c *ConfigGenerator) Endpoints() Endpoints {
+	return Endpoints{
+		APIServerURL:  c.getAPIServerURL(),
+		BaseAddress:   c.getBaseAddress(),
+		ConsoleURL:    fmt.Sprintf("https://%s", c.getBaseAddress()),
+		OIDCIssuerURL: c.getOicdIssuerURL(),
+	}
+}
+
 func (c *ConfigGenerator) getEtcdServersURLs() string {
 	etcdServers := make([]string, c.Cluster.NodeCount(c.Cluster.Etcd.NodePools))
 	for i := range etcdServers {
@@ -48,6 +48,20 @@ func TestUrlFunctions(t *testing.T) {
 	}
 }
 
+func TestEndpoints(t *testing.T) {
+	config := initConfig(t, "test.yaml")
+
+	expected := Endpoints{
+		APIServerURL:  "https://test-api.cluster.com:6443",
+		BaseAddress:   "test.cluster.com",
+		ConsoleURL:    "https://test.cluster.com",
+		OIDCIssuerURL: "https://test.cluster.com/identity",
+	}
+	if got := config.Endpoints(); got != expected {
+		t.Errorf("Test case TestEndpoints: expected: %+v, got: %+v", expected, got)
+	}
+}
+
 func TestGetEtcdServersURLs(t *testing.T) {
 	testCases := []struct {
 		test       string
@@ -91,8 +91,10 @@ type Cluster struct {
 	Name            string `json:"tectonic_cluster_name,omitempty" yaml:"name,omitempty"`
 	Networking      `json:",inline" yaml:"networking,omitempty"`
 	NodePools       `json:"-" yaml:"nodePools"`
-	Platform        Platform `json:"tectonic_platform" yaml:"platform,omitempty"`
-	PullSecretPath  string   `json:"tectonic_pull_secret_path,omitempty" yaml:"pullSecretPath,omitempty"`
+	Platform        Platform     `json:"tectonic_platform" yaml:"platform,omitempty"`
+	PullSecretPath  string       `json:"tectonic_pull_secret_path,omitempty" yaml:"pullSecretPath,omitempty"`
+	StateBackend    StateBackend `json:"-" yaml:"stateBackend,omitempty"`
+	Terraform       `json:"-" yaml:"terraform,omitempty"`
 	Worker          `json:",inline" yaml:"worker,omitempty"`
 }
 
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"fmt"
+)
+
+// ErrorCode identifies the kind of problem found in a field of a cluster
+// config. Codes are stable, so that tools can match them.
+type ErrorCode string
+
+const (
+	// ErrorCodeRequired is used when a required field is empty.
+	ErrorCodeRequired ErrorCode = "Required"
+	// ErrorCodeInvalid is used when a field has a malformed value.
+	ErrorCodeInvalid ErrorCode = "Invalid"
+	// ErrorCodeNotSupported is used when a field is not one of the values
+	// it supports.
+	ErrorCodeNotSupported ErrorCode = "NotSupported"
+	// ErrorCodeNotFound is used when a field refers to something that does
+	// not exist, such as a node pool.
+	ErrorCodeNotFound ErrorCode = "NotFound"
+	// ErrorCodeDuplicate is used when a value is used by several fields
+	// that must not share it.
+	ErrorCodeDuplicate ErrorCode = "Duplicate"
+	// ErrorCodeTooMany is used when a list has more elements than allowed.
+	ErrorCodeTooMany ErrorCode = "TooMany"
+	// ErrorCodeOverlap is used when a network range overlaps another one.
+	ErrorCodeOverlap ErrorCode = "Overlap"
+	// ErrorCodeInvalidFile is used when the file a field points to cannot be
+	// read or has an invalid content.
+	ErrorCodeInvalidFile ErrorCode = "InvalidFile"
+	// ErrorCodeEvenEtcdCount is used when the etcd node pools have an even
+	// number of nodes.
+	ErrorCodeEvenEtcdCount ErrorCode = "EvenEtcdCount"
+	// ErrorCodeUnknownField is used when a key of the config matches no
+	// field.
+	ErrorCodeUnknownField ErrorCode = "UnknownField"
+)
+
+// Severity tells whether a problem found in a cluster config prevents using
+// it.
+type Severity string
+
+const (
+	// SeverityError makes the config invalid.
+	SeverityError Severity = "error"
+	// SeverityWarning is reported without failing the validation.
+	SeverityWarning Severity = "warning"
+)
+
+// Error is a problem found in a field of a cluster config.
+type Error struct {
+	// Field is the YAML path of the field, e.g. "nodePools[2].ignitionFile".
+	Field    string
+	Code     ErrorCode
+	Severity Severity
+	// Err is the cause of the problem.
+	Err error
+}
+
+// Error implements the error interface.
+func (e *Error) Error() string {
+	if e.Field == "" {
+		return e.Err.Error()
+	}
+	return fmt.Sprintf("%s: %v", e.Field, e.Err)
+}
+
+// ErrorList is the list of the problems found in a cluster config.
+type ErrorList []*Error
+
+// Errors returns the entries of the list that make the config invalid.
+func (l ErrorList) Errors() ErrorList {
+	return l.filter(SeverityError)
+}
+
+// Warnings returns the entries of the list that do not make the config
+// invalid.
+func (l ErrorList) Warnings() ErrorList {
+	return l.filter(SeverityWarning)
+}
+
+func (l ErrorList) filter(severity Severity) ErrorList {
+	var filtered ErrorList
+	for _, e := range l {
+		if e.Severity == severity {
+			filtered = append(filtered, e)
+		}
+	}
+	return filtered
+}
+
+// add appends err, if any, to the list as an error of the given field.
+func (l *ErrorList) add(field string, code ErrorCode, err error) {
+	if err != nil {
+		*l = append(*l, &Error{Field: field, Code: code, Severity: SeverityError, Err: err})
+	}
+}
+
+// warn appends err, if any, to the list as a warning about the given field.
+func (l *ErrorList) warn(field string, code ErrorCode, err error) {
+	if err != nil {
+		*l = append(*l, &Error{Field: field, Code: code, Severity: SeverityWarning, Err: err})
+	}
+}
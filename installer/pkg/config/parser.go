@@ -2,12 +2,29 @@ package config
 
 import (
 	"io/ioutil"
+	"reflect"
 
 	"gopkg.in/yaml.v2"
 )
 
 // ParseConfig parses a yaml string and returns, if successful, a Cluster.
+// Keys matching no field of the Cluster, e.g. because of a typo, are refused
+// with an ErrUnknownFields, rather than silently leaving the field to its
+// default.
 func ParseConfig(data []byte) (*Cluster, error) {
+	unknown, err := unknownFields(data, reflect.TypeOf(Cluster{}))
+	if err != nil {
+		return nil, err
+	}
+	if len(unknown) != 0 {
+		return nil, &ErrUnknownFields{fields: unknown}
+	}
+	return ParseConfigLenient(data)
+}
+
+// ParseConfigLenient parses a yaml string like ParseConfig, but ignores the
+// keys matching no field of the Cluster.
+func ParseConfigLenient(data []byte) (*Cluster, error) {
 	cluster := defaultCluster
 
 	if err := yaml.Unmarshal(data, &cluster); err != nil {
@@ -0,0 +1,46 @@
+package config
+
+import (
+	"reflect"
+)
+
+// ValidationEntry describes a problem found in a cluster config, in a form
+// fit for reports, e.g. for linting configs in a pipeline.
+type ValidationEntry struct {
+	// Field is the YAML path of the offending field, if known.
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+	// Type is the name of the error type, e.g. "ErrUnmatchedNodePool", or
+	// "Error" for errors of no specific type.
+	Type     string    `json:"type"`
+	Code     ErrorCode `json:"code,omitempty"`
+	Severity Severity  `json:"severity"`
+}
+
+// NewValidationEntry returns the report entry of the given problem.
+func NewValidationEntry(e *Error) ValidationEntry {
+	entry := ValidationEntry{
+		Field:    e.Field,
+		Message:  e.Err.Error(),
+		Type:     "Error",
+		Code:     e.Code,
+		Severity: e.Severity,
+	}
+	t := reflect.TypeOf(e.Err)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.PkgPath() == reflect.TypeOf(Cluster{}).PkgPath() {
+		entry.Type = t.Name()
+	}
+	return entry
+}
+
+// ValidationEntries returns the report entries of the problems of the list.
+func (l ErrorList) ValidationEntries() []ValidationEntry {
+	entries := make([]ValidationEntry, 0, len(l))
+	for _, e := range l {
+		entries = append(entries, NewValidationEntry(e))
+	}
+	return entries
+}
@@ -0,0 +1,314 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// maxSuggestionDepth is how deep in the fields of a struct suggestions for an
+// unknown key are looked for, e.g. 3 to suggest "aws.etcd.ec2Type" for a
+// top-level key.
+const maxSuggestionDepth = 3
+
+var (
+	yamlKeyRegexp         = regexp.MustCompile(`^("[^"]*"|'[^']*'|[^\s#'"\[\]{},:-][^#:]*?|-[^\s#:][^#:]*?)\s*:(\s|$)`)
+	yamlBlockScalarRegexp = regexp.MustCompile(`:\s+[|>][-+0-9]*\s*(#.*)?$`)
+)
+
+// ErrUnknownField is a key of a config that matches no field.
+type ErrUnknownField struct {
+	// field is the YAML path of the key, e.g. "nodePools[0].nam".
+	field string
+	// line and column locate the key in the config, if it was found.
+	line, column int
+	// suggestions are the YAML paths of the fields the key may be a typo of.
+	suggestions []string
+}
+
+// ErrUnknownField implements the error interface.
+func (e *ErrUnknownField) Error() string {
+	msg := fmt.Sprintf("unknown field %q", e.field)
+	if e.line != 0 {
+		msg += fmt.Sprintf(" at line %d, column %d", e.line, e.column)
+	}
+	if len(e.suggestions) == 0 {
+		return msg
+	}
+	quoted := make([]string, 0, len(e.suggestions))
+	for _, s := range e.suggestions {
+		quoted = append(quoted, fmt.Sprintf("%q", s))
+	}
+	suggestion := quoted[len(quoted)-1]
+	if len(quoted) > 1 {
+		suggestion = strings.Join(quoted[:len(quoted)-1], ", ") + " or " + suggestion
+	}
+	return fmt.Sprintf("%s; did you mean %s?", msg, suggestion)
+}
+
+// ErrUnknownFields is returned by ParseConfig when keys of the config match no
+// field, e.g. because of a typo.
+type ErrUnknownFields struct {
+	fields []*ErrUnknownField
+}
+
+// ErrUnknownFields implements the error interface.
+func (e *ErrUnknownFields) Error() string {
+	msgs := make([]string, 0, len(e.fields))
+	for _, f := range e.fields {
+		msgs = append(msgs, f.Error())
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// ErrorList returns the unknown keys as errors of their fields.
+func (e *ErrUnknownFields) ErrorList() ErrorList {
+	var errs ErrorList
+	for _, f := range e.fields {
+		errs.add(f.field, ErrorCodeUnknownField, f)
+	}
+	return errs
+}
+
+// unknownFields returns the keys of the given YAML document that match no
+// field of the given type, in the order of the document.
+func unknownFields(data []byte, t reflect.Type) ([]*ErrUnknownField, error) {
+	var doc yaml.MapSlice
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	var unknown []*ErrUnknownField
+	walkUnknownFields("", doc, t, &unknown)
+	if len(unknown) == 0 {
+		return nil, nil
+	}
+
+	locations := yamlKeyLocations(data)
+	for _, f := range unknown {
+		if l, ok := locations[f.field]; ok {
+			f.line, f.column = l[0], l[1]
+		}
+	}
+	return unknown, nil
+}
+
+// walkUnknownFields adds the keys of the given YAML value, found at the given
+// path, that match no field of the given type to unknown.
+func walkUnknownFields(path string, v interface{}, t reflect.Type, unknown *[]*ErrUnknownField) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch node := v.(type) {
+	case yaml.MapSlice:
+		switch t.Kind() {
+		case reflect.Struct:
+			fields := yamlFields(t)
+			for _, item := range node {
+				key := fmt.Sprint(item.Key)
+				field, ok := fields[key]
+				if !ok {
+					*unknown = append(*unknown, &ErrUnknownField{
+						field:       joinYAMLPath(path, key),
+						suggestions: suggestFields(path, key, t),
+					})
+					continue
+				}
+				walkUnknownFields(joinYAMLPath(path, key), item.Value, field, unknown)
+			}
+		case reflect.Map:
+			for _, item := range node {
+				walkUnknownFields(joinYAMLPath(path, fmt.Sprint(item.Key)), item.Value, t.Elem(), unknown)
+			}
+		}
+	case []interface{}:
+		if t.Kind() == reflect.Slice || t.Kind() == reflect.Array {
+			for i, e := range node {
+				walkUnknownFields(fmt.Sprintf("%s[%d]", path, i), e, t.Elem(), unknown)
+			}
+		}
+	}
+}
+
+// yamlFields returns the types of the fields of the given struct, by YAML key,
+// following the rules of the yaml package.
+func yamlFields(t reflect.Type) map[string]reflect.Type {
+	fields := make(map[string]reflect.Type)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" && !f.Anonymous {
+			continue
+		}
+		tag := f.Tag.Get("yaml")
+		if tag == "-" {
+			continue
+		}
+		opts := strings.Split(tag, ",")
+		name := opts[0]
+		inline := false
+		for _, opt := range opts[1:] {
+			inline = inline || opt == "inline"
+		}
+		if inline {
+			for k, v := range yamlFields(f.Type) {
+				fields[k] = v
+			}
+			continue
+		}
+		if name == "" {
+			name = strings.ToLower(f.Name)
+		}
+		fields[name] = f.Type
+	}
+	return fields
+}
+
+// suggestFields returns the YAML paths of the fields of the given struct,
+// found at the given path, whose key is closest to the unknown one. Fields at
+// the level of the key are preferred over nested ones.
+func suggestFields(path, key string, t reflect.Type) []string {
+	var fields, nested []string
+	var walk func(prefix string, t reflect.Type, depth int)
+	walk = func(prefix string, t reflect.Type, depth int) {
+		for name, ft := range yamlFields(t) {
+			p := joinYAMLPath(prefix, name)
+			if depth == 1 {
+				fields = append(fields, p)
+			} else {
+				nested = append(nested, p)
+			}
+			for ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct && depth < maxSuggestionDepth {
+				walk(p, ft, depth+1)
+			}
+		}
+	}
+	walk(path, t, 1)
+
+	if suggestions := closestFields(key, fields); len(suggestions) != 0 {
+		return suggestions
+	}
+	return closestFields(key, nested)
+}
+
+// closestFields returns the YAML paths whose last key is the closest to the
+// given one, if close enough to be a typo of it, sorted.
+func closestFields(key string, paths []string) []string {
+	maxDistance := len(key) / 3
+	if maxDistance < 2 {
+		maxDistance = 2
+	}
+	var closest []string
+	best := maxDistance + 1
+	for _, p := range paths {
+		name := p[strings.LastIndex(p, ".")+1:]
+		d := editDistance(strings.ToLower(key), strings.ToLower(name))
+		switch {
+		case d < best:
+			best, closest = d, []string{p}
+		case d == best:
+			closest = append(closest, p)
+		}
+	}
+	sort.Strings(closest)
+	return closest
+}
+
+// editDistance returns the Levenshtein distance between two strings.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = prev[j-1] + cost
+			if prev[j]+1 < cur[j] {
+				cur[j] = prev[j] + 1
+			}
+			if cur[j-1]+1 < cur[j] {
+				cur[j] = cur[j-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
+
+// yamlKeyLocations returns the line and column of the keys of the given YAML
+// document, by YAML path. Only the keys of block mappings are located.
+func yamlKeyLocations(data []byte) map[string][2]int {
+	type frame struct {
+		indent int
+		path   string
+		item   bool
+	}
+	locations := make(map[string][2]int)
+	items := make(map[string]int)
+	stack := []frame{{indent: -1}}
+	blockScalarIndent := -1
+
+	for n, line := range strings.Split(string(data), "\n") {
+		trimmed := strings.TrimLeft(line, " ")
+		col := len(line) - len(trimmed)
+		if blockScalarIndent != -1 {
+			if trimmed == "" || col > blockScalarIndent {
+				continue
+			}
+			blockScalarIndent = -1
+		}
+		if trimmed == "" || trimmed[0] == '#' || strings.HasPrefix(line, "---") || strings.HasPrefix(line, "...") {
+			continue
+		}
+
+		// Sequence items open a frame per "- ", possibly several on a line.
+		for trimmed == "-" || strings.HasPrefix(trimmed, "- ") {
+			for top := stack[len(stack)-1]; len(stack) > 1 && (top.indent > col || top.indent == col && top.item); top = stack[len(stack)-1] {
+				stack = stack[:len(stack)-1]
+			}
+			parent := stack[len(stack)-1].path
+			path := fmt.Sprintf("%s[%d]", parent, items[parent])
+			items[parent]++
+			stack = append(stack, frame{indent: col, path: path, item: true})
+			rest := strings.TrimLeft(trimmed[1:], " ")
+			col += len(trimmed) - len(rest)
+			trimmed = rest
+		}
+
+		m := yamlKeyRegexp.FindStringSubmatch(trimmed)
+		if m == nil {
+			continue
+		}
+		for top := stack[len(stack)-1]; len(stack) > 1 && top.indent >= col; top = stack[len(stack)-1] {
+			stack = stack[:len(stack)-1]
+		}
+		path := joinYAMLPath(stack[len(stack)-1].path, strings.Trim(m[1], `"'`))
+		if _, ok := locations[path]; !ok {
+			locations[path] = [2]int{n + 1, col + 1}
+		}
+		stack = append(stack, frame{indent: col, path: path})
+		if yamlBlockScalarRegexp.MatchString(trimmed) {
+			blockScalarIndent = col
+		}
+	}
+	return locations
+}
+
+// joinYAMLPath returns the YAML path of the given key of the value at path.
+func joinYAMLPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
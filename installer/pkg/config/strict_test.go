@@ -0,0 +1,106 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseConfigUnknownFields(t *testing.T) {
+	cases := []struct {
+		config  string
+		unknown []ErrUnknownField
+	}{
+		{
+			config: "name: test\nnodePools:\n  - name: etcd\n    count: 3\n",
+		},
+		{
+			config: "name: test\nnodePool:\n  - name: etcd\n",
+			unknown: []ErrUnknownField{
+				{field: "nodePool", line: 2, column: 1, suggestions: []string{"nodePools"}},
+			},
+		},
+		{
+			config: "aws:\n  region: eu-west-1\n  master:\n    ec2Typ: m4.large\n",
+			unknown: []ErrUnknownField{
+				{field: "aws.master.ec2Typ", line: 4, column: 5, suggestions: []string{"aws.master.ec2Type"}},
+			},
+		},
+		{
+			config: "aws:\n  # The type of the instances.\n  ec2Type: m4.large\n",
+			unknown: []ErrUnknownField{
+				{field: "aws.ec2Type", line: 3, column: 3, suggestions: []string{"aws.etcd.ec2Type", "aws.master.ec2Type", "aws.worker.ec2Type"}},
+			},
+		},
+		{
+			config: "nodePools:\n- name: etcd\n  count: 3\n- nam: master\n  count: 1\n",
+			unknown: []ErrUnknownField{
+				{field: "nodePools[1].nam", line: 4, column: 3, suggestions: []string{"nodePools[1].name"}},
+			},
+		},
+		{
+			config: "libvirt:\n  network:\n    ifname: tt0\n  sshKey: |\n    key: value\n",
+			unknown: []ErrUnknownField{
+				{field: "libvirt.network.ifname", line: 3, column: 5, suggestions: []string{"libvirt.network.ifName"}},
+			},
+		},
+		{
+			config: "aws:\n  extraTags:\n    anyKey: value\nunrelated: true\n",
+			unknown: []ErrUnknownField{
+				{field: "unrelated", line: 4, column: 1},
+			},
+		},
+	}
+
+	for i, c := range cases {
+		_, err := ParseConfig([]byte(c.config))
+		if len(c.unknown) == 0 {
+			if err != nil {
+				t.Errorf("test case %d: expected no error, got: %v", i, err)
+			}
+			continue
+		}
+		unknown, ok := err.(*ErrUnknownFields)
+		if !ok {
+			t.Errorf("test case %d: expected unknown fields, got: %v", i, err)
+			continue
+		}
+		var fields []ErrUnknownField
+		for _, f := range unknown.fields {
+			fields = append(fields, *f)
+		}
+		if !reflect.DeepEqual(fields, c.unknown) {
+			t.Errorf("test case %d: expected unknown fields %+v, got: %+v", i, c.unknown, fields)
+		}
+
+		cluster, err := ParseConfigLenient([]byte(c.config))
+		if err != nil || cluster == nil {
+			t.Errorf("test case %d: expected the lenient parse to succeed, got: %v", i, err)
+		}
+	}
+}
+
+func TestErrUnknownField(t *testing.T) {
+	cases := []struct {
+		err      ErrUnknownField
+		expected string
+	}{
+		{
+			err:      ErrUnknownField{field: "iscsi"},
+			expected: `unknown field "iscsi"`,
+		},
+		{
+			err:      ErrUnknownField{field: "nodePool", line: 2, column: 1, suggestions: []string{"nodePools"}},
+			expected: `unknown field "nodePool" at line 2, column 1; did you mean "nodePools"?`,
+		},
+		{
+			err:      ErrUnknownField{field: "aws.ec2Type", line: 3, column: 3, suggestions: []string{"aws.etcd.ec2Type", "aws.master.ec2Type", "aws.worker.ec2Type"}},
+			expected: `unknown field "aws.ec2Type" at line 3, column 3; did you mean "aws.etcd.ec2Type", "aws.master.ec2Type" or "aws.worker.ec2Type"?`,
+		},
+	}
+
+	for i, c := range cases {
+		if msg := c.err.Error(); msg != c.expected {
+			t.Errorf("test case %d: expected %q, got: %q", i, c.expected, msg)
+		}
+	}
+}
@@ -74,6 +74,54 @@ type Networking struct {
 	PodCIDR     string                      `json:"tectonic_cluster_cidr,omitempty" yaml:"podCIDR,omitempty"`
 }
 
+// StateBackendType is the type of store keeping the Terraform state of the
+// steps.
+type StateBackendType string
+
+const (
+	// StateBackendLocal keeps the state in the cluster directory.
+	StateBackendLocal StateBackendType = "local"
+	// StateBackendHTTP keeps the state behind a REST endpoint, following the
+	// protocol of Terraform's http backend.
+	StateBackendHTTP StateBackendType = "http"
+	// StateBackendS3 keeps the state in an S3-compatible bucket.
+	StateBackendS3 StateBackendType = "s3"
+)
+
+// StateBackend defines where the Terraform state of the steps is stored.
+type StateBackend struct {
+	Type StateBackendType `json:"-" yaml:"type,omitempty"`
+	HTTP HTTPStateBackend `json:"-" yaml:"http,omitempty"`
+	S3   S3StateBackend   `json:"-" yaml:"s3,omitempty"`
+}
+
+// HTTPStateBackend converts http state backend related config.
+type HTTPStateBackend struct {
+	Address  string `json:"-" yaml:"address,omitempty"`
+	Username string `json:"-" yaml:"username,omitempty"`
+	Password string `json:"-" yaml:"password,omitempty"`
+}
+
+// S3StateBackend converts S3 state backend related config. The credentials
+// are read from the standard AWS environment variables.
+type S3StateBackend struct {
+	Bucket   string `json:"-" yaml:"bucket,omitempty"`
+	Prefix   string `json:"-" yaml:"prefix,omitempty"`
+	Region   string `json:"-" yaml:"region,omitempty"`
+	Endpoint string `json:"-" yaml:"endpoint,omitempty"`
+	Encrypt  bool   `json:"-" yaml:"encrypt,omitempty"`
+	// LockTable is the DynamoDB table Terraform locks the states with.
+	LockTable string `json:"-" yaml:"lockTable,omitempty"`
+}
+
+// Terraform defines how the installer runs Terraform.
+type Terraform struct {
+	// Offline runs Terraform without network access, using the providers
+	// found in PluginDir instead of downloading them.
+	Offline   bool   `json:"-" yaml:"offline,omitempty"`
+	PluginDir string `json:"-" yaml:"pluginDir,omitempty"`
+}
+
 // Worker converts worker related config.
 type Worker struct {
 	Count     int      `json:"tectonic_worker_count,omitempty" yaml:"-"`
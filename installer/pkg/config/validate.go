@@ -64,6 +64,17 @@ func (e *ErrSharedNodePool) Error() string {
 	return fmt.Sprintf("node pools cannot be shared, but %q is used by %s", e.name, strings.Join(e.fields, ", "))
 }
 
+// ErrEvenEtcdCount is reported as a warning when an even number of etcd nodes
+// is requested, which tolerates no more failures than the odd number below it.
+type ErrEvenEtcdCount struct {
+	count int
+}
+
+// ErrEvenEtcdCount implements the error interface.
+func (e *ErrEvenEtcdCount) Error() string {
+	return fmt.Sprintf("the etcd node pools have %d nodes, but an odd number tolerates as many failures with one node less", e.count)
+}
+
 // ErrInvalidIgnConfig is returned when a invalid ign config is given.
 type ErrInvalidIgnConfig struct {
 	filePath string
@@ -75,9 +86,11 @@ func (e *ErrInvalidIgnConfig) Error() string {
 	return fmt.Sprintf("failed to parse ignition file %s: %s", e.filePath, e.rpt)
 }
 
-// Validate ensures that the Cluster is semantically correct and returns an error if not.
-func (c *Cluster) Validate() []error {
-	var errs []error
+// Validate ensures that the Cluster is semantically correct and returns the
+// problems found in it, by field. Only the entries of severity error make the
+// cluster invalid; see ErrorList.Errors.
+func (c *Cluster) Validate() ErrorList {
+	var errs ErrorList
 	errs = append(errs, c.validateNodePools()...)
 	errs = append(errs, c.validateIgnitionFiles()...)
 	errs = append(errs, c.validateNetworking()...)
@@ -86,49 +99,32 @@ func (c *Cluster) Validate() []error {
 	errs = append(errs, c.validateTectonicFiles()...)
 	errs = append(errs, c.validateLibvirt()...)
 	errs = append(errs, c.validateCA()...)
-	if err := validate.PrefixError("cluster name", validate.ClusterName(c.Name)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("base domain", validate.DomainName(c.BaseDomain)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("admin password", validate.NonEmpty(c.Admin.Password)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("admin email", validate.Email(c.Admin.Email)); err != nil {
-		errs = append(errs, err)
-	}
+	errs = append(errs, c.validateStateBackend()...)
+	errs.add("name", ErrorCodeInvalid, validate.ClusterName(c.Name))
+	errs.add("baseDomain", ErrorCodeInvalid, validate.DomainName(c.BaseDomain))
+	errs.add("admin.password", ErrorCodeRequired, validate.NonEmpty(c.Admin.Password))
+	errs.add("admin.email", ErrorCodeInvalid, validate.Email(c.Admin.Email))
 	return errs
 }
 
 // validateAWS validates all fields specific to AWS.
-func (c *Cluster) validateAWS() []error {
-	var errs []error
+func (c *Cluster) validateAWS() ErrorList {
+	var errs ErrorList
 	if c.Platform != PlatformAWS {
 		return errs
 	}
-	if err := c.validateAWSEndpoints(); err != nil {
-		errs = append(errs, err)
-	}
-	if err := c.validateTNCS3Bucket(); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("aws vpcCIDRBlock", validate.SubnetCIDR(c.AWS.VPCCIDRBlock)); err != nil {
-		errs = append(errs, err)
-	}
-	errs = append(errs, c.validateOverlapWithPodOrServiceCIDR(c.AWS.VPCCIDRBlock, "aws vpcCIDRBlock")...)
-	if err := validate.PrefixError("aws profile", validate.NonEmpty(c.AWS.Profile)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("aws region", validate.NonEmpty(c.AWS.Region)); err != nil {
-		errs = append(errs, err)
-	}
+	errs.add("aws.endpoints", ErrorCodeNotSupported, c.validateAWSEndpoints())
+	errs.add("name", ErrorCodeInvalid, c.validateTNCS3Bucket())
+	errs.add("aws.vpcCIDRBlock", ErrorCodeInvalid, validate.SubnetCIDR(c.AWS.VPCCIDRBlock))
+	errs = append(errs, c.validateOverlapWithPodOrServiceCIDR(c.AWS.VPCCIDRBlock, "aws.vpcCIDRBlock")...)
+	errs.add("aws.profile", ErrorCodeRequired, validate.NonEmpty(c.AWS.Profile))
+	errs.add("aws.region", ErrorCodeRequired, validate.NonEmpty(c.AWS.Region))
 	return errs
 }
 
 // validateCL validates all fields specific to Container Linux.
-func (c *Cluster) validateCL() []error {
-	var errs []error
+func (c *Cluster) validateCL() ErrorList {
+	var errs ErrorList
 	switch c.ContainerLinux.Channel {
 	case ContainerLinuxChannelStable:
 		fallthrough
@@ -137,86 +133,57 @@ func (c *Cluster) validateCL() []error {
 	case ContainerLinuxChannelAlpha:
 		break
 	default:
-		errs = append(errs, fmt.Errorf("invalid Container Linux channel %q", c.ContainerLinux.Channel))
+		errs.add("containerLinux.channel", ErrorCodeNotSupported, fmt.Errorf("invalid Container Linux channel %q", c.ContainerLinux.Channel))
 	}
 	if c.ContainerLinux.Version != ContainerLinuxVersionLatest && !regexp.MustCompile(`\d+\.\d+\.\d+`).MatchString(c.ContainerLinux.Version) {
-		errs = append(errs, fmt.Errorf("invalid Container Linux version %q", c.ContainerLinux.Version))
+		errs.add("containerLinux.version", ErrorCodeInvalid, fmt.Errorf("invalid Container Linux version %q", c.ContainerLinux.Version))
 	}
 	return errs
 }
 
-// validateOverlapWithPodOrServiceCIDR ensures that the given CIDR does not
-// overlap with the pod or service CIDRs of the cluster config.
-func (c *Cluster) validateOverlapWithPodOrServiceCIDR(cidr, name string) []error {
-	var errs []error
-	if err := validate.PrefixError(fmt.Sprintf("%s and podCIDR", name), validate.CIDRsDontOverlap(cidr, c.Networking.PodCIDR)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError(fmt.Sprintf("%s and serviceCIDR", name), validate.CIDRsDontOverlap(cidr, c.Networking.ServiceCIDR)); err != nil {
-		errs = append(errs, err)
-	}
+// validateOverlapWithPodOrServiceCIDR ensures that the given CIDR, the value
+// of the given field, does not overlap with the pod or service CIDRs of the
+// cluster config.
+func (c *Cluster) validateOverlapWithPodOrServiceCIDR(cidr, field string) ErrorList {
+	var errs ErrorList
+	errs.add(field, ErrorCodeOverlap, validate.PrefixError("networking.podCIDR", validate.CIDRsDontOverlap(cidr, c.Networking.PodCIDR)))
+	errs.add(field, ErrorCodeOverlap, validate.PrefixError("networking.serviceCIDR", validate.CIDRsDontOverlap(cidr, c.Networking.ServiceCIDR)))
 	return errs
 }
 
 // validateLibvirt validates all fields specific to libvirt.
-func (c *Cluster) validateLibvirt() []error {
-	var errs []error
+func (c *Cluster) validateLibvirt() ErrorList {
+	var errs ErrorList
 	if c.Platform != PlatformLibvirt {
 		return errs
 	}
-	if err := validate.PrefixError("libvirt network ipRange", validate.SubnetCIDR(c.Libvirt.Network.IPRange)); err != nil {
-		errs = append(errs, err)
-	}
+	errs.add("libvirt.network.ipRange", ErrorCodeInvalid, validate.SubnetCIDR(c.Libvirt.Network.IPRange))
 	if len(c.Libvirt.MasterIPs) > 0 {
 		if len(c.Libvirt.MasterIPs) != c.NodeCount(c.Master.NodePools) {
-			errs = append(errs, fmt.Errorf("length of masterIPs does't match master count"))
+			errs.add("libvirt.masterIPs", ErrorCodeInvalid, fmt.Errorf("length of masterIPs does't match master count"))
 		}
 		for i, ip := range c.Libvirt.MasterIPs {
-			if err := validate.PrefixError(fmt.Sprintf("libvirt masterIPs[%d] %q", i, ip), validate.IPv4(ip)); err != nil {
-				errs = append(errs, err)
-			}
+			errs.add(fmt.Sprintf("libvirt.masterIPs[%d]", i), ErrorCodeInvalid, validate.PrefixError(fmt.Sprintf("%q", ip), validate.IPv4(ip)))
 		}
 	}
-	if err := validate.PrefixError("libvirt uri", validate.NonEmpty(c.Libvirt.URI)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("libvirt imagePath is not a valid QCOW image", validate.FileHeader(c.Libvirt.QCOWImagePath, qcowMagic)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("libvirt sshKey", validate.NonEmpty(c.Libvirt.SSHKey)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("libvirt network name", validate.NonEmpty(c.Libvirt.Network.Name)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("libvirt network ifName", validate.NonEmpty(c.Libvirt.Network.IfName)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("libvirt network dnsServer", validate.IPv4(c.Libvirt.Network.DNSServer)); err != nil {
-		errs = append(errs, err)
-	}
-	errs = append(errs, c.validateOverlapWithPodOrServiceCIDR(c.Libvirt.Network.IPRange, "libvirt ipRange")...)
+	errs.add("libvirt.uri", ErrorCodeRequired, validate.NonEmpty(c.Libvirt.URI))
+	errs.add("libvirt.imagePath", ErrorCodeInvalidFile, validate.PrefixError("not a valid QCOW image", validate.FileHeader(c.Libvirt.QCOWImagePath, qcowMagic)))
+	errs.add("libvirt.sshKey", ErrorCodeRequired, validate.NonEmpty(c.Libvirt.SSHKey))
+	errs.add("libvirt.network.name", ErrorCodeRequired, validate.NonEmpty(c.Libvirt.Network.Name))
+	errs.add("libvirt.network.ifName", ErrorCodeRequired, validate.NonEmpty(c.Libvirt.Network.IfName))
+	errs.add("libvirt.network.dnsServer", ErrorCodeInvalid, validate.IPv4(c.Libvirt.Network.DNSServer))
+	errs = append(errs, c.validateOverlapWithPodOrServiceCIDR(c.Libvirt.Network.IPRange, "libvirt.network.ipRange")...)
 	return errs
 }
 
-func (c *Cluster) validateNetworking() []error {
-	var errs []error
+func (c *Cluster) validateNetworking() ErrorList {
+	var errs ErrorList
 	// https://en.wikipedia.org/wiki/Maximum_transmission_unit#MTUs_for_common_media
-	if err := validate.PrefixError("mtu", validate.IntRange(c.Networking.MTU, 68, 64*1024)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("podCIDR", validate.SubnetCIDR(c.Networking.PodCIDR)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("serviceCIDR", validate.SubnetCIDR(c.Networking.ServiceCIDR)); err != nil {
-		errs = append(errs, err)
-	}
-	if err := c.validateNetworkType(); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.PrefixError("pod and service CIDRs", validate.CIDRsDontOverlap(c.Networking.PodCIDR, c.Networking.ServiceCIDR)); err != nil {
-		errs = append(errs, err)
-	}
+	errs.add("networking.mtu", ErrorCodeInvalid, validate.IntRange(c.Networking.MTU, 68, 64*1024))
+	errs.add("networking.podCIDR", ErrorCodeInvalid, validate.SubnetCIDR(c.Networking.PodCIDR))
+	errs.add("networking.serviceCIDR", ErrorCodeInvalid, validate.SubnetCIDR(c.Networking.ServiceCIDR))
+	errs.add("networking.type", ErrorCodeNotSupported, c.validateNetworkType())
+	errs.add("networking.podCIDR", ErrorCodeOverlap, validate.PrefixError("networking.serviceCIDR", validate.CIDRsDontOverlap(c.Networking.PodCIDR, c.Networking.ServiceCIDR)))
 	return errs
 }
 
@@ -236,10 +203,15 @@ func (c *Cluster) validateNetworkType() error {
 }
 
 // ValidateAndLog performs cluster configuration validation using `Validate`
-// but rather than return a slice of errors, it logs any errors and returns
-// a single error for convenience.
+// but rather than return a list of errors, it logs any errors and warnings
+// and returns a single error for convenience. Warnings alone do not fail the
+// validation.
 func (c *Cluster) ValidateAndLog() error {
-	if errs := c.Validate(); len(errs) != 0 {
+	errs := c.Validate()
+	for _, w := range errs.Warnings() {
+		log.Warn(w)
+	}
+	if errs = errs.Errors(); len(errs) != 0 {
 		s := ""
 		if len(errs) != 1 {
 			s = "s"
@@ -283,32 +255,27 @@ func (c *Cluster) validateTNCS3Bucket() error {
 	return nil
 }
 
-func (c *Cluster) validateTectonicFiles() []error {
-	var errs []error
-	if err := validate.JSONFile(c.PullSecretPath); err != nil {
-		errs = append(errs, err)
-	}
-	if err := validate.License(c.LicensePath); err != nil {
-		errs = append(errs, err)
-	}
+func (c *Cluster) validateTectonicFiles() ErrorList {
+	var errs ErrorList
+	errs.add("pullSecretPath", ErrorCodeInvalidFile, validate.JSONFile(c.PullSecretPath))
+	errs.add("licensePath", ErrorCodeInvalidFile, validate.License(c.LicensePath))
 	return errs
 }
 
-func (c *Cluster) validateIgnitionFiles() []error {
-	var errs []error
-	for _, n := range c.NodePools {
+func (c *Cluster) validateIgnitionFiles() ErrorList {
+	var errs ErrorList
+	for i, n := range c.NodePools {
 		if n.IgnitionFile == "" {
 			continue
 		}
+		field := fmt.Sprintf("nodePools[%d].ignitionFile", i)
 
 		if err := validate.FileExists(n.IgnitionFile); err != nil {
-			errs = append(errs, err)
+			errs.add(field, ErrorCodeInvalidFile, err)
 			continue
 		}
 
-		if err := validateIgnitionConfig(n.IgnitionFile); err != nil {
-			errs = append(errs, err)
-		}
+		errs.add(field, ErrorCodeInvalidFile, validateIgnitionConfig(n.IgnitionFile))
 	}
 	return errs
 }
@@ -329,8 +296,8 @@ func validateIgnitionConfig(filePath string) error {
 	return nil
 }
 
-func (c *Cluster) validateNodePools() []error {
-	var errs []error
+func (c *Cluster) validateNodePools() ErrorList {
+	var errs ErrorList
 	n := c.NodePools.Map()
 	fields := []struct {
 		pools []string
@@ -342,30 +309,34 @@ func (c *Cluster) validateNodePools() []error {
 	}
 	for _, f := range fields {
 		var found bool
-		for _, p := range f.pools {
+		for i, p := range f.pools {
 			if p == "" {
 				continue
 			}
 			found = true
 			if _, ok := n[p]; !ok {
-				errs = append(errs, &ErrUnmatchedNodePool{p})
+				errs.add(fmt.Sprintf("%s.nodePools[%d]", f.field, i), ErrorCodeNotFound, &ErrUnmatchedNodePool{p})
 			}
 		}
 		if !found {
-			errs = append(errs, &ErrMissingNodePool{f.field})
+			errs.add(f.field+".nodePools", ErrorCodeRequired, &ErrMissingNodePool{f.field})
 		}
 		if len(f.pools) > 1 {
-			errs = append(errs, &ErrMoreThanOneNodePool{f.field})
+			errs.add(f.field+".nodePools", ErrorCodeTooMany, &ErrMoreThanOneNodePool{f.field})
 		}
 	}
 
+	if count := c.NodeCount(c.Etcd.NodePools); count > 0 && count%2 == 0 {
+		errs.warn("etcd.nodePools", ErrorCodeEvenEtcdCount, &ErrEvenEtcdCount{count})
+	}
+
 	errs = append(errs, c.validateNoSharedNodePools()...)
 
 	return errs
 }
 
-func (c *Cluster) validateNoSharedNodePools() []error {
-	var errs []error
+func (c *Cluster) validateNoSharedNodePools() ErrorList {
+	var errs ErrorList
 	fields := make(map[string]map[string]struct{})
 	for i := range c.Master.NodePools {
 		if c.Master.NodePools[i] != "" {
@@ -407,32 +378,46 @@ func (c *Cluster) validateNoSharedNodePools() []error {
 		for f := range v {
 			err.fields = append(err.fields, f)
 		}
-		errs = append(errs, err)
+		errs.add("nodePools", ErrorCodeDuplicate, err)
 	}
 	return errs
 }
 
-func (c *Cluster) validateCA() []error {
-	var errs []error
+// validateStateBackend validates the fields of the selected state backend.
+func (c *Cluster) validateStateBackend() ErrorList {
+	var errs ErrorList
+	switch c.StateBackend.Type {
+	case "", StateBackendLocal:
+	case StateBackendHTTP:
+		errs.add("stateBackend.http.address", ErrorCodeInvalid, validate.URL(c.StateBackend.HTTP.Address))
+	case StateBackendS3:
+		errs.add("stateBackend.s3.bucket", ErrorCodeRequired, validate.NonEmpty(c.StateBackend.S3.Bucket))
+		if c.StateBackend.S3.Endpoint != "" {
+			errs.add("stateBackend.s3.endpoint", ErrorCodeInvalid, validate.URL(c.StateBackend.S3.Endpoint))
+		}
+	default:
+		errs.add("stateBackend.type", ErrorCodeNotSupported, fmt.Errorf("invalid state backend type %q; must be one of %s", c.StateBackend.Type, []StateBackendType{StateBackendLocal, StateBackendHTTP, StateBackendS3}))
+	}
+	return errs
+}
+
+func (c *Cluster) validateCA() ErrorList {
+	var errs ErrorList
 
 	switch {
 	case (c.CA.RootCACertPath == "") != (c.CA.RootCAKeyPath == ""):
-		errs = append(errs, fmt.Errorf("rootCACertPath and rootCAKeyPath must both be set or empty"))
-	case c.CA.RootCAKeyPath != "":
-		if err := validate.FileExists(c.CA.RootCAKeyPath); err != nil {
-			errs = append(errs, err)
-		}
-		if err := validateCAKey(c.CA.RootCAKeyPath); err != nil {
-			errs = append(errs, err)
+		field := "CA.rootCAKeyPath"
+		if c.CA.RootCACertPath == "" {
+			field = "CA.rootCACertPath"
 		}
+		errs.add(field, ErrorCodeRequired, fmt.Errorf("rootCACertPath and rootCAKeyPath must both be set or empty"))
+	case c.CA.RootCAKeyPath != "":
+		errs.add("CA.rootCAKeyPath", ErrorCodeInvalidFile, validate.FileExists(c.CA.RootCAKeyPath))
+		errs.add("CA.rootCAKeyPath", ErrorCodeInvalidFile, validateCAKey(c.CA.RootCAKeyPath))
 		fallthrough
 	case c.CA.RootCACertPath != "":
-		if err := validate.FileExists(c.CA.RootCACertPath); err != nil {
-			errs = append(errs, err)
-		}
-		if err := validateCACert(c.CA.RootCACertPath); err != nil {
-			errs = append(errs, err)
-		}
+		errs.add("CA.rootCACertPath", ErrorCodeInvalidFile, validate.FileExists(c.CA.RootCACertPath))
+		errs.add("CA.rootCACertPath", ErrorCodeInvalidFile, validateCACert(c.CA.RootCACertPath))
 	}
 	return errs
 }
@@ -1,6 +1,7 @@
 package config
 
 import (
+	"errors"
 	"io/ioutil"
 	"os"
 	"testing"
@@ -54,7 +55,7 @@ func TestMissingNodePool(t *testing.T) {
 		var n int
 		errs := c.cluster.Validate()
 		for _, err := range errs {
-			if _, ok := err.(*ErrMissingNodePool); ok {
+			if _, ok := err.Err.(*ErrMissingNodePool); ok {
 				n++
 			}
 		}
@@ -130,7 +131,7 @@ func TestMoreThanOneNodePool(t *testing.T) {
 		var n int
 		errs := c.cluster.Validate()
 		for _, err := range errs {
-			if _, ok := err.(*ErrMoreThanOneNodePool); ok {
+			if _, ok := err.Err.(*ErrMoreThanOneNodePool); ok {
 				n++
 			}
 		}
@@ -234,7 +235,7 @@ func TestUnmatchedNodePool(t *testing.T) {
 		var n int
 		errs := c.cluster.Validate()
 		for _, err := range errs {
-			if _, ok := err.(*ErrUnmatchedNodePool); ok {
+			if _, ok := err.Err.(*ErrUnmatchedNodePool); ok {
 				n++
 			}
 		}
@@ -324,7 +325,7 @@ func TestSharedNodePool(t *testing.T) {
 		var n int
 		errs := c.cluster.Validate()
 		for _, err := range errs {
-			if _, ok := err.(*ErrSharedNodePool); ok {
+			if _, ok := err.Err.(*ErrSharedNodePool); ok {
 				n++
 			}
 		}
@@ -335,6 +336,113 @@ func TestSharedNodePool(t *testing.T) {
 	}
 }
 
+func TestEvenEtcdCount(t *testing.T) {
+	cases := []struct {
+		count int
+		err   bool
+	}{
+		{count: 0, err: false},
+		{count: 1, err: false},
+		{count: 2, err: true},
+		{count: 3, err: false},
+		{count: 4, err: true},
+	}
+
+	for i, c := range cases {
+		cluster := Cluster{
+			Etcd: Etcd{
+				NodePools: []string{"etcd"},
+			},
+			NodePools: NodePools{
+				{
+					Name:  "etcd",
+					Count: c.count,
+				},
+			},
+		}
+		var found bool
+		for _, err := range cluster.Validate() {
+			if _, ok := err.Err.(*ErrEvenEtcdCount); ok {
+				found = true
+				if err.Severity != SeverityWarning || err.Field != "etcd.nodePools" {
+					t.Errorf("test case %d: expected a warning about etcd.nodePools, got: %+v", i, err)
+				}
+			}
+		}
+		if found != c.err {
+			t.Errorf("test case %d: expected even etcd count warning: %v, got: %v", i, c.err, found)
+		}
+	}
+}
+
+func TestNewValidationEntry(t *testing.T) {
+	cases := []struct {
+		err   *Error
+		entry ValidationEntry
+	}{
+		{
+			err:   &Error{Field: "worker.nodePools[0]", Code: ErrorCodeNotFound, Severity: SeverityError, Err: &ErrUnmatchedNodePool{name: "workers"}},
+			entry: ValidationEntry{Field: "worker.nodePools[0]", Message: `no node pool named "workers" was found`, Type: "ErrUnmatchedNodePool", Code: ErrorCodeNotFound, Severity: SeverityError},
+		},
+		{
+			err:   &Error{Field: "etcd.nodePools", Code: ErrorCodeEvenEtcdCount, Severity: SeverityWarning, Err: &ErrEvenEtcdCount{count: 2}},
+			entry: ValidationEntry{Field: "etcd.nodePools", Message: (&ErrEvenEtcdCount{count: 2}).Error(), Type: "ErrEvenEtcdCount", Code: ErrorCodeEvenEtcdCount, Severity: SeverityWarning},
+		},
+		{
+			err:   &Error{Field: "baseDomain", Code: ErrorCodeInvalid, Severity: SeverityError, Err: errors.New("invalid base domain")},
+			entry: ValidationEntry{Field: "baseDomain", Message: "invalid base domain", Type: "Error", Code: ErrorCodeInvalid, Severity: SeverityError},
+		},
+	}
+
+	for i, c := range cases {
+		if entry := NewValidationEntry(c.err); entry != c.entry {
+			t.Errorf("test case %d: expected entry %+v, got: %+v", i, c.entry, entry)
+		}
+	}
+}
+
+func TestValidateFieldPaths(t *testing.T) {
+	cluster := defaultCluster
+	cluster.Platform = PlatformAWS
+	cluster.AWS.VPCCIDRBlock = "10.2.0.0/16"
+	cluster.Master.NodePools = []string{"master"}
+	cluster.Worker.NodePools = []string{"worker", "workers"}
+	cluster.Etcd.NodePools = []string{"etcd"}
+	cluster.NodePools = NodePools{
+		{Name: "etcd", Count: 2},
+		{Name: "master", Count: 1, IgnitionFile: "./fixtures/missing.ign"},
+		{Name: "worker", Count: 1},
+	}
+
+	cases := []struct {
+		field    string
+		code     ErrorCode
+		severity Severity
+	}{
+		{field: "worker.nodePools[1]", code: ErrorCodeNotFound, severity: SeverityError},
+		{field: "worker.nodePools", code: ErrorCodeTooMany, severity: SeverityError},
+		{field: "nodePools[1].ignitionFile", code: ErrorCodeInvalidFile, severity: SeverityError},
+		{field: "aws.vpcCIDRBlock", code: ErrorCodeOverlap, severity: SeverityError},
+		{field: "etcd.nodePools", code: ErrorCodeEvenEtcdCount, severity: SeverityWarning},
+	}
+
+	errs := cluster.Validate()
+	for i, c := range cases {
+		var found bool
+		for _, err := range errs {
+			if err.Field == c.field && err.Code == c.code && err.Severity == c.severity {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("test case %d: expected a %s %s entry for %s, got: %v", i, c.severity, c.code, c.field, errs)
+		}
+	}
+	if len(errs.Warnings()) != 1 || len(errs.Errors()) != len(errs)-1 {
+		t.Errorf("expected exactly one warning, got: %v", errs.Warnings())
+	}
+}
+
 func TestAWSEndpoints(t *testing.T) {
 	cases := []struct {
 		cluster Cluster
@@ -476,10 +584,10 @@ func TestValidateIgnitionFiles(t *testing.T) {
 	if len(errs) != 2 {
 		t.Errorf("expected: %d ignition errors, got: %d", 2, len(errs))
 	}
-	if !os.IsNotExist(errs[0]) {
+	if !os.IsNotExist(errs[0].Err) {
 		t.Errorf("expected: notExistError, got: %v", errs[0])
 	}
-	if _, ok := errs[1].(*ErrInvalidIgnConfig); !ok {
+	if _, ok := errs[1].Err.(*ErrInvalidIgnConfig); !ok {
 		t.Errorf("expected: ErrInvalidIgnConfig, got: %v", errs[1])
 	}
 }
@@ -0,0 +1,423 @@
+// Package tfstate reads the Terraform state files produced by the installer
+// steps, e.g. topology.tfstate, across the versions of the state format.
+package tfstate
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// ManagedMode is the mode of the resources Terraform manages.
+	ManagedMode = "managed"
+	// DataMode is the mode of the data sources.
+	DataMode = "data"
+)
+
+// State is the content of a Terraform state file.
+type State struct {
+	// Version is the version of the state format.
+	Version          int
+	TerraformVersion string
+	// Serial is incremented by Terraform each time the state changes.
+	Serial  int
+	Lineage string
+	// Outputs are the outputs of the root module.
+	Outputs map[string]Output
+	// ModuleOutputs are the outputs of each module, by module address.
+	// Only the root module, of address "", is recorded from version 4 on.
+	ModuleOutputs map[string]map[string]Output
+	Resources     []Resource
+}
+
+// Output is the value of a Terraform output.
+type Output struct {
+	Sensitive bool
+	Value     interface{}
+}
+
+// Resource is a resource or data source, along with its instances.
+type Resource struct {
+	// Module is the address of the resource's module, e.g. "module.vpc",
+	// or "" for the root module.
+	Module    string
+	Mode      string
+	Type      string
+	Name      string
+	Instances []Instance
+}
+
+// Address returns the address of the resource, e.g.
+// "module.vpc.aws_elb.api_external".
+func (r Resource) Address() string {
+	address := r.Type + "." + r.Name
+	if r.Mode == DataMode {
+		address = "data." + address
+	}
+	if r.Module != "" {
+		address = r.Module + "." + address
+	}
+	return address
+}
+
+// Instance is an instance of a resource.
+type Instance struct {
+	// Index is the count index of the instance, or -1 if the resource has
+	// no count.
+	Index      int
+	ID         string
+	Attributes map[string]interface{}
+}
+
+// Attribute returns the attribute at the given dotted path, e.g.
+// "network_interface.0.addresses.0", as a string.
+func (i Instance) Attribute(path string) (string, bool) {
+	// Up to version 3, the attributes are flattened.
+	if v, ok := i.Attributes[path]; ok {
+		return scalar(v)
+	}
+	var v interface{} = i.Attributes
+	for _, key := range strings.Split(path, ".") {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			child, ok := node[key]
+			if !ok {
+				return "", false
+			}
+			v = child
+		case []interface{}:
+			index, err := strconv.Atoi(key)
+			if err != nil || index < 0 || index >= len(node) {
+				return "", false
+			}
+			v = node[index]
+		default:
+			return "", false
+		}
+	}
+	return scalar(v)
+}
+
+// scalar returns the given attribute value as a string, if it is one.
+func scalar(v interface{}) (string, bool) {
+	switch v := v.(type) {
+	case string:
+		return v, true
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), true
+	case bool:
+		return strconv.FormatBool(v), true
+	}
+	return "", false
+}
+
+// ReadFile reads the state file at the given path.
+func ReadFile(path string) (*State, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	state, err := Parse(content)
+	if err != nil {
+		return nil, fmt.Errorf("invalid state file %s: %v", path, err)
+	}
+	return state, nil
+}
+
+// Parse parses the content of a state file.
+func Parse(content []byte) (*State, error) {
+	var header struct {
+		Version int `json:"version"`
+	}
+	if err := json.Unmarshal(content, &header); err != nil {
+		return nil, err
+	}
+	switch header.Version {
+	case 1, 2, 3:
+		return parseV3(content)
+	case 4:
+		return parseV4(content)
+	}
+	return nil, fmt.Errorf("unsupported state format version %d", header.Version)
+}
+
+// ResourcesByType returns the managed resources of the given type, in any
+// module.
+func (s *State) ResourcesByType(resourceType string) []Resource {
+	var resources []Resource
+	for _, r := range s.Resources {
+		if r.Mode == ManagedMode && r.Type == resourceType {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// ResourcesByModule returns the resources of the module of the given
+// address, e.g. "module.vpc", or of the root module if empty.
+func (s *State) ResourcesByModule(module string) []Resource {
+	var resources []Resource
+	for _, r := range s.Resources {
+		if r.Module == module {
+			resources = append(resources, r)
+		}
+	}
+	return resources
+}
+
+// String returns the value of the named root output, which must be a string.
+func (s *State) String(name string) (string, error) {
+	o, ok := s.Outputs[name]
+	if !ok {
+		return "", fmt.Errorf("no output %q", name)
+	}
+	v, ok := o.Value.(string)
+	if !ok {
+		return "", fmt.Errorf("output %q is not a string", name)
+	}
+	return v, nil
+}
+
+// StringList returns the value of the named root output, which must be a
+// list of strings.
+func (s *State) StringList(name string) ([]string, error) {
+	o, ok := s.Outputs[name]
+	if !ok {
+		return nil, fmt.Errorf("no output %q", name)
+	}
+	values, ok := o.Value.([]interface{})
+	if !ok {
+		return nil, fmt.Errorf("output %q is not a list", name)
+	}
+	list := make([]string, 0, len(values))
+	for _, v := range values {
+		s, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("output %q is not a list of strings", name)
+		}
+		list = append(list, s)
+	}
+	return list, nil
+}
+
+// MasterIPs returns the IP addresses of the master instances the state
+// manages, in order. Masters run by an autoscaling group are not part of the
+// state.
+func (s *State) MasterIPs() []string {
+	var ips []string
+	for _, r := range s.Resources {
+		if r.Mode != ManagedMode || !strings.Contains(r.Name, "master") {
+			continue
+		}
+		for _, i := range r.Instances {
+			var ip string
+			switch r.Type {
+			case "aws_instance":
+				ip, _ = i.Attribute("private_ip")
+			case "libvirt_domain":
+				ip, _ = i.Attribute("network_interface.0.addresses.0")
+			}
+			if ip != "" {
+				ips = append(ips, ip)
+			}
+		}
+	}
+	return ips
+}
+
+// APIDNSNames returns the DNS names of the external and internal API load
+// balancers, if the state has them.
+func (s *State) APIDNSNames() (external, internal string) {
+	for _, r := range s.ResourcesByType("aws_elb") {
+		if len(r.Instances) == 0 {
+			continue
+		}
+		name, _ := r.Instances[0].Attribute("dns_name")
+		switch r.Name {
+		case "api_external":
+			external = name
+		case "api_internal":
+			internal = name
+		}
+	}
+	return external, internal
+}
+
+// ELBNames returns the names of the load balancers of the state, by resource
+// name, e.g. "api_external".
+func (s *State) ELBNames() map[string]string {
+	names := make(map[string]string)
+	for _, r := range s.ResourcesByType("aws_elb") {
+		if len(r.Instances) == 0 {
+			continue
+		}
+		if name, ok := r.Instances[0].Attribute("name"); ok {
+			names[r.Name] = name
+		}
+	}
+	return names
+}
+
+// moduleAddress returns the address of the module of the given path, e.g.
+// ["root", "vpc"], of the state format up to version 3.
+func moduleAddress(path []string) string {
+	var parts []string
+	for _, p := range path {
+		if p != "root" {
+			parts = append(parts, "module."+p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
+
+type stateV3 struct {
+	Version          int    `json:"version"`
+	TerraformVersion string `json:"terraform_version"`
+	Serial           int    `json:"serial"`
+	Lineage          string `json:"lineage"`
+	Modules          []struct {
+		Path    []string `json:"path"`
+		Outputs map[string]struct {
+			Sensitive bool        `json:"sensitive"`
+			Value     interface{} `json:"value"`
+		} `json:"outputs"`
+		Resources map[string]struct {
+			Type    string `json:"type"`
+			Primary *struct {
+				ID         string                 `json:"id"`
+				Attributes map[string]interface{} `json:"attributes"`
+			} `json:"primary"`
+		} `json:"resources"`
+	} `json:"modules"`
+}
+
+// parseV3 parses the state format up to version 3, used up to Terraform
+// 0.11, whose resources are keyed by "[data.]type.name[.index]".
+func parseV3(content []byte) (*State, error) {
+	var raw stateV3
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	s := &State{
+		Version:          raw.Version,
+		TerraformVersion: raw.TerraformVersion,
+		Serial:           raw.Serial,
+		Lineage:          raw.Lineage,
+		Outputs:          make(map[string]Output),
+		ModuleOutputs:    make(map[string]map[string]Output),
+	}
+
+	for _, m := range raw.Modules {
+		module := moduleAddress(m.Path)
+		outputs := make(map[string]Output)
+		for name, o := range m.Outputs {
+			outputs[name] = Output{Sensitive: o.Sensitive, Value: o.Value}
+		}
+		s.ModuleOutputs[module] = outputs
+		if module == "" {
+			s.Outputs = outputs
+		}
+
+		byAddress := make(map[string]*Resource)
+		var addresses []string
+		for key, r := range m.Resources {
+			mode := ManagedMode
+			if strings.HasPrefix(key, "data.") {
+				mode = DataMode
+				key = strings.TrimPrefix(key, "data.")
+			}
+			parts := strings.Split(key, ".")
+			if len(parts) < 2 {
+				return nil, fmt.Errorf("invalid resource key %q", key)
+			}
+			index := -1
+			if len(parts) > 2 {
+				var err error
+				if index, err = strconv.Atoi(parts[2]); err != nil {
+					return nil, fmt.Errorf("invalid resource key %q", key)
+				}
+			}
+			address := parts[0] + "." + parts[1]
+			if mode == DataMode {
+				address = "data." + address
+			}
+			res, ok := byAddress[address]
+			if !ok {
+				res = &Resource{Module: module, Mode: mode, Type: parts[0], Name: parts[1]}
+				byAddress[address] = res
+				addresses = append(addresses, address)
+			}
+			instance := Instance{Index: index}
+			if r.Primary != nil {
+				instance.ID = r.Primary.ID
+				instance.Attributes = r.Primary.Attributes
+			}
+			res.Instances = append(res.Instances, instance)
+		}
+		sort.Strings(addresses)
+		for _, address := range addresses {
+			r := byAddress[address]
+			sort.Slice(r.Instances, func(i, j int) bool { return r.Instances[i].Index < r.Instances[j].Index })
+			s.Resources = append(s.Resources, *r)
+		}
+	}
+	return s, nil
+}
+
+type stateV4 struct {
+	Version          int    `json:"version"`
+	TerraformVersion string `json:"terraform_version"`
+	Serial           int    `json:"serial"`
+	Lineage          string `json:"lineage"`
+	Outputs          map[string]struct {
+		Sensitive bool        `json:"sensitive"`
+		Value     interface{} `json:"value"`
+	} `json:"outputs"`
+	Resources []struct {
+		Module    string `json:"module"`
+		Mode      string `json:"mode"`
+		Type      string `json:"type"`
+		Name      string `json:"name"`
+		Instances []struct {
+			IndexKey   interface{}            `json:"index_key"`
+			Attributes map[string]interface{} `json:"attributes"`
+		} `json:"instances"`
+	} `json:"resources"`
+}
+
+// parseV4 parses the state format version 4, used from Terraform 0.12 on.
+func parseV4(content []byte) (*State, error) {
+	var raw stateV4
+	if err := json.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	s := &State{
+		Version:          raw.Version,
+		TerraformVersion: raw.TerraformVersion,
+		Serial:           raw.Serial,
+		Lineage:          raw.Lineage,
+		Outputs:          make(map[string]Output),
+	}
+	for name, o := range raw.Outputs {
+		s.Outputs[name] = Output{Sensitive: o.Sensitive, Value: o.Value}
+	}
+	s.ModuleOutputs = map[string]map[string]Output{"": s.Outputs}
+
+	for _, r := range raw.Resources {
+		res := Resource{Module: r.Module, Mode: r.Mode, Type: r.Type, Name: r.Name}
+		for _, i := range r.Instances {
+			instance := Instance{Index: -1, Attributes: i.Attributes}
+			if index, ok := i.IndexKey.(float64); ok {
+				instance.Index = int(index)
+			}
+			instance.ID, _ = instance.Attribute("id")
+			res.Instances = append(res.Instances, instance)
+		}
+		s.Resources = append(s.Resources, res)
+	}
+	return s, nil
+}
@@ -0,0 +1,238 @@
+package tfstate
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestReadFile(t *testing.T) {
+	testCases := []struct {
+		test             string
+		path             string
+		version          int
+		terraformVersion string
+		serial           int
+		resources        []string
+	}{
+		{
+			test:             "topology",
+			path:             "./fixtures/topology.aws.tfstate",
+			version:          3,
+			terraformVersion: "0.11.7",
+			serial:           7,
+			resources: []string{
+				"aws_s3_bucket.tectonic",
+				"data.aws_region.current",
+				"module.vpc.aws_elb.api_external",
+				"module.vpc.aws_elb.api_internal",
+				"module.vpc.aws_elb.console",
+				"module.vpc.aws_subnet.master_subnet",
+				"module.vpc.aws_vpc.new_vpc",
+			},
+		},
+		{
+			test:             "masters libvirt",
+			path:             "./fixtures/masters.libvirt.tfstate",
+			version:          3,
+			terraformVersion: "0.11.7",
+			serial:           3,
+			resources: []string{
+				"data.terraform_remote_state.assets",
+				"libvirt_domain.master",
+				"libvirt_ignition.master",
+			},
+		},
+		{
+			test:             "version 4",
+			path:             "./fixtures/masters.aws.v4.tfstate",
+			version:          4,
+			terraformVersion: "0.12.29",
+			serial:           12,
+			resources: []string{
+				"data.aws_ami.coreos_ami",
+				"module.masters.aws_instance.master",
+				"module.masters.aws_elb.api_external",
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		s, err := ReadFile(tc.path)
+		if err != nil {
+			t.Errorf("Test case %s: failed to read state: %v", tc.test, err)
+			continue
+		}
+		if s.Version != tc.version || s.TerraformVersion != tc.terraformVersion || s.Serial != tc.serial {
+			t.Errorf("Test case %s: expected version %d by Terraform %s at serial %d, got %d by %s at %d", tc.test, tc.version, tc.terraformVersion, tc.serial, s.Version, s.TerraformVersion, s.Serial)
+		}
+		var resources []string
+		for _, r := range s.Resources {
+			resources = append(resources, r.Address())
+		}
+		if !reflect.DeepEqual(resources, tc.resources) {
+			t.Errorf("Test case %s: expected resources %v, got %v", tc.test, tc.resources, resources)
+		}
+	}
+}
+
+func TestParseUnsupportedVersion(t *testing.T) {
+	if _, err := Parse([]byte(`{"version": 5}`)); err == nil {
+		t.Error("expected an unsupported state format version to be rejected")
+	}
+	if _, err := Parse([]byte(`{"version": 3, "modules": [{"path": ["root"], "resources": {"invalid": {}}}]}`)); err == nil {
+		t.Error("expected an invalid resource key to be rejected")
+	}
+}
+
+func TestResources(t *testing.T) {
+	s, err := ReadFile("./fixtures/topology.aws.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	subnets := s.ResourcesByType("aws_subnet")
+	if len(subnets) != 1 || len(subnets[0].Instances) != 2 {
+		t.Fatalf("expected one aws_subnet resource with 2 instances, got %+v", subnets)
+	}
+	for i, expected := range []string{"subnet-0a", "subnet-0b"} {
+		if instance := subnets[0].Instances[i]; instance.Index != i || instance.ID != expected {
+			t.Errorf("expected instance %d to be %s, got %+v", i, expected, instance)
+		}
+	}
+	if regions := s.ResourcesByType("aws_region"); len(regions) != 0 {
+		t.Errorf("expected data sources to be left out, got %+v", regions)
+	}
+	if got := len(s.ResourcesByModule("module.vpc")); got != 5 {
+		t.Errorf("expected 5 resources in the vpc module, got %d", got)
+	}
+	if got := len(s.ResourcesByModule("")); got != 2 {
+		t.Errorf("expected 2 resources in the root module, got %d", got)
+	}
+}
+
+func TestOutputs(t *testing.T) {
+	s, err := ReadFile("./fixtures/topology.aws.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bucket, err := s.String("s3_bucket")
+	if err != nil || bucket != "mycluster-tnc.example.com" {
+		t.Errorf("expected the s3_bucket output, got %q: %v", bucket, err)
+	}
+	lbs, err := s.StringList("aws_lbs")
+	if err != nil || len(lbs) != 4 {
+		t.Errorf("expected the 4 load balancers of the aws_lbs output, got %v: %v", lbs, err)
+	}
+	if _, err := s.String("aws_lbs"); err == nil {
+		t.Error("expected a list output not to be read as a string")
+	}
+	if _, err := s.StringList("missing"); err == nil {
+		t.Error("expected a missing output to be reported")
+	}
+	if vpc := s.ModuleOutputs["module.vpc"]["vpc_id"]; vpc.Value != "vpc-0a1b2c3d" {
+		t.Errorf("expected the vpc_id output of the vpc module, got %v", vpc.Value)
+	}
+
+	v4, err := ReadFile("./fixtures/masters.aws.v4.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ips, err := v4.StringList("master_ips")
+	if err != nil || len(ips) != 2 {
+		t.Errorf("expected the master_ips output, got %v: %v", ips, err)
+	}
+	if !v4.Outputs["admin_password"].Sensitive {
+		t.Error("expected the admin_password output to be sensitive")
+	}
+}
+
+func TestMasterIPs(t *testing.T) {
+	testCases := []struct {
+		test     string
+		path     string
+		expected []string
+	}{
+		{
+			test:     "libvirt",
+			path:     "./fixtures/masters.libvirt.tfstate",
+			expected: []string{"192.168.124.11", "192.168.124.12"},
+		},
+		{
+			test:     "version 4",
+			path:     "./fixtures/masters.aws.v4.tfstate",
+			expected: []string{"10.0.3.10", "10.0.19.11"},
+		},
+		{
+			test: "no masters",
+			path: "./fixtures/topology.aws.tfstate",
+		},
+	}
+
+	for _, tc := range testCases {
+		s, err := ReadFile(tc.path)
+		if err != nil {
+			t.Errorf("Test case %s: failed to read state: %v", tc.test, err)
+			continue
+		}
+		if got := s.MasterIPs(); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("Test case %s: expected master IPs %v, got %v", tc.test, tc.expected, got)
+		}
+	}
+}
+
+func TestLoadBalancers(t *testing.T) {
+	s, err := ReadFile("./fixtures/topology.aws.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	external, internal := s.APIDNSNames()
+	if external != "mycluster-ext-1234567890.eu-west-1.elb.amazonaws.com" || internal != "internal-mycluster-int-1234567890.eu-west-1.elb.amazonaws.com" {
+		t.Errorf("expected the API DNS names, got %q and %q", external, internal)
+	}
+	expected := map[string]string{
+		"api_external": "mycluster-ext",
+		"api_internal": "mycluster-int",
+		"console":      "mycluster-con",
+	}
+	if got := s.ELBNames(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected ELB names %v, got %v", expected, got)
+	}
+
+	v4, err := ReadFile("./fixtures/masters.aws.v4.tfstate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if external, _ := v4.APIDNSNames(); external != "mycluster-ext-0987654321.eu-west-1.elb.amazonaws.com" {
+		t.Errorf("expected the API DNS name of a version 4 state, got %q", external)
+	}
+}
+
+func TestAttribute(t *testing.T) {
+	i := Instance{Attributes: map[string]interface{}{
+		"flat.0.value": "flat",
+		"nested": map[string]interface{}{
+			"list": []interface{}{"a", map[string]interface{}{"port": float64(443), "enabled": true}},
+		},
+	}}
+	testCases := []struct {
+		path     string
+		expected string
+		found    bool
+	}{
+		{path: "flat.0.value", expected: "flat", found: true},
+		{path: "nested.list.0", expected: "a", found: true},
+		{path: "nested.list.1.port", expected: "443", found: true},
+		{path: "nested.list.1.enabled", expected: "true", found: true},
+		{path: "nested.list.2"},
+		{path: "nested.list"},
+		{path: "missing"},
+	}
+
+	for _, tc := range testCases {
+		got, found := i.Attribute(tc.path)
+		if got != tc.expected || found != tc.found {
+			t.Errorf("Test case %s: expected %q (%t), got %q (%t)", tc.path, tc.expected, tc.found, got, found)
+		}
+	}
+}
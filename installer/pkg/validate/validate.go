@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net"
+	"net/url"
 	"os"
 	"regexp"
 	"strconv"
@@ -320,6 +321,19 @@ func HostPort(v string) error {
 	return Port(split[1])
 }
 
+// URL checks if the given string is an absolute http or https URL and returns an error if not.
+func URL(v string) error {
+	if err := NonEmpty(v); err != nil {
+		return err
+	}
+
+	u, err := url.Parse(v)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errors.New("invalid URL (must be an http or https URL)")
+	}
+	return nil
+}
+
 // Email checks if the given string is a valid email address and returns an error if not.
 func Email(v string) error {
 	if err := NonEmpty(v); err != nil {
@@ -354,6 +354,21 @@ func TestHostPort(t *testing.T) {
 	runTests(t, "HostPort", HostPort, tests)
 }
 
+func TestURL(t *testing.T) {
+	const invalidMsg = "invalid URL (must be an http or https URL)"
+	tests := []test{
+		{"", emptyMsg},
+		{" ", emptyMsg},
+		{"abc.com", invalidMsg},
+		{"ftp://abc.com", invalidMsg},
+		{"http://", invalidMsg},
+		{"http://abc.com", ""},
+		{"https://abc.com:8443/state", ""},
+		{"https://1.2.3.4/state?x=y", ""},
+	}
+	runTests(t, "URL", URL, tests)
+}
+
 func TestEmail(t *testing.T) {
 	const invalidMsg = "invalid email address"
 	tests := []test{
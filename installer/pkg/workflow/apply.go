@@ -0,0 +1,440 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+	yaml "gopkg.in/yaml.v2"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+// appliedConfigFileName is the copy of the config last applied to the
+// cluster, against which 'tectonic apply' finds the changes to make.
+const appliedConfigFileName = "applied-config.yaml"
+
+var templateVariableRegexp = regexp.MustCompile(`\bvar\.([A-Za-z0-9_-]+)`)
+
+// immutableConfigFields are the config fields, by YAML path, that cannot
+// change once a cluster exists, short of recreating it.
+var immutableConfigFields = []string{
+	"name",
+	"baseDomain",
+	"platform",
+	"networking.serviceCIDR",
+	"aws.region",
+}
+
+// ApplyDiff is the step of the 'apply' workflow finding the steps affected
+// by the changes made to the config since it was last applied.
+var ApplyDiff = NewStep("config-diff", applyDiffStep)
+
+// applySteps are the install steps the 'apply' workflow re-runs when the
+// Terraform variables their templates use changed, along with their
+// Terraform state, in dependency order.
+var applySteps = []struct {
+	step  Step
+	state string
+}{
+	{InstallTLS, tlsStep},
+	{InstallAssets, assetsStep},
+	{InstallTopology, topologyStep},
+	{InstallTNCARecord, tncDNSStep},
+	{InstallEtcd, etcdStep},
+	{InstallJoinMasters, mastersStep},
+	{InstallJoinWorkers, joinWorkersStep},
+}
+
+// configChange is a config field whose value changed.
+type configChange struct {
+	path     string
+	old, new interface{}
+}
+
+// String returns the change in a human readable form, hiding secrets.
+func (c configChange) String() string {
+	if strings.Contains(strings.ToLower(c.path), "password") {
+		return c.path + ": (changed)"
+	}
+	return fmt.Sprintf("%s: %v -> %v", c.path, formatStatusValue(c.old), formatStatusValue(c.new))
+}
+
+// ApplyWorkflow creates new instances of the 'apply' workflow, responsible
+// for making an existing cluster match the changes made to its config since
+// it was last applied. Only the steps whose templates use the changed
+// Terraform variables, and those depending on them, are re-run.
+func ApplyWorkflow(clusterDir string) Workflow {
+	b := NewBuilder(clusterDir).Setup(RefreshConfig, ApplyDiff)
+	for _, step := range []Step{
+		InstallConfigMaps,
+		onlyIfAffected(InstallTLS),
+		onlyIfAffected(InstallAssets),
+		InstallIgnition,
+		onlyIfAffected(InstallTopology),
+		onlyIfAffected(InstallTNCARecord),
+		onlyIfAffected(InstallEtcd),
+		onlyIfAffected(InstallJoinMasters),
+		onlyIfAffected(InstallJoinWorkers),
+	} {
+		b.Add(step, installDeps[step.Name()]...)
+	}
+	return b.workflow
+}
+
+// onlyIfAffected returns the given step, which only runs if the config diff
+// found it affected.
+func onlyIfAffected(step Step) Step {
+	run := func(ctx context.Context, m *State) error {
+		if !m.affectedSteps[step.Name()] {
+			log.Debugf("Skipping step %s: not affected by the config changes", step.Name())
+			return nil
+		}
+		return step.Run(ctx, m)
+	}
+	if runsTerraform(step) {
+		return newTerraformStep(step.Name(), run)
+	}
+	return NewStep(step.Name(), run)
+}
+
+// applyDiffStep compares the config of the cluster with the one last
+// applied, refuses the changes of immutable fields and records the steps
+// the other changes affect.
+func applyDiffStep(ctx context.Context, m *State) error {
+	applied, err := readAppliedConfig(m.clusterDir)
+	if err != nil {
+		return err
+	}
+	applied.Internal = m.cluster.Internal
+
+	changes, err := diffClusterConfigs(*applied, m.cluster)
+	if err != nil {
+		return err
+	}
+	// Once applied, the config is recorded even if no step is affected.
+	m.applied = true
+	if len(changes) == 0 {
+		log.Info("The config did not change since it was last applied")
+		return nil
+	}
+	for _, c := range changes {
+		log.Infof("Changed %s", c)
+	}
+
+	vars, err := changedTerraformVariables(*applied, m.cluster)
+	if err != nil {
+		return err
+	}
+	affected := make(map[string]bool)
+	for _, s := range applySteps {
+		// Steps never installed are left for 'tectonic install' to create.
+		installed, err := m.hasState(ctx, s.state)
+		if err != nil {
+			return err
+		}
+		if !installed {
+			continue
+		}
+		templateDir, err := m.stepTemplates(s.state)
+		if err != nil {
+			return err
+		}
+		used, err := templateVariables(templateDir)
+		if err != nil {
+			return err
+		}
+		for _, v := range vars {
+			if used[v] {
+				affected[s.step.Name()] = true
+				break
+			}
+		}
+		// The steps reading the state of an affected step are affected
+		// too; applySteps lists them after it.
+		for _, dep := range allInstallDeps(s.step.Name()) {
+			if affected[dep] {
+				affected[s.step.Name()] = true
+			}
+		}
+		if affected[s.step.Name()] {
+			log.Infof("Step %s is affected by the config changes", s.step.Name())
+		}
+	}
+	if len(affected) == 0 {
+		log.Warn("The config changes do not affect any installed Terraform step")
+	}
+	m.affectedSteps = affected
+	return nil
+}
+
+// allInstallDeps returns the install steps the given one depends on,
+// directly or not.
+func allInstallDeps(step string) []string {
+	var deps []string
+	seen := make(map[string]bool)
+	var visit func(string)
+	visit = func(step string) {
+		for _, dep := range installDeps[step] {
+			if !seen[dep] {
+				seen[dep] = true
+				deps = append(deps, dep)
+				visit(dep)
+			}
+		}
+	}
+	visit(step)
+	return deps
+}
+
+// readAppliedConfig returns the config last applied to the cluster.
+func readAppliedConfig(clusterDir string) (*config.Cluster, error) {
+	path := filepath.Join(clusterDir, appliedConfigFileName)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no record of the config last applied in %s; run 'tectonic install' first", clusterDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+	// The snapshot was accepted when it was applied, leniently or not.
+	cluster, err := config.ParseConfigLenient(data)
+	if err != nil {
+		return nil, fmt.Errorf("%s is not a valid config file: %s", path, err)
+	}
+	return cluster, nil
+}
+
+// saveAppliedConfigOnce records the config of the cluster directory as the
+// one last applied, unless a config was already recorded. It runs once the
+// first step of a run applied changes: a cluster whose first install fails
+// already has its immutable fields protected, while the record of an existing
+// cluster is only updated once a run fully succeeded, so that the changes of
+// the steps that failed are found again by the next 'tectonic apply'. The
+// caller must hold the state lock.
+func (m *State) saveAppliedConfigOnce() error {
+	_, err := os.Stat(filepath.Join(m.clusterDir, appliedConfigFileName))
+	if !os.IsNotExist(err) {
+		return err
+	}
+	return m.saveAppliedConfig()
+}
+
+// removeAppliedConfig forgets the config last applied, once the cluster has
+// no Terraform resource left.
+func (m *State) removeAppliedConfig(ctx context.Context) error {
+	for _, name := range terraformStates {
+		n, err := m.stateResources(ctx, name)
+		if err != nil {
+			return err
+		}
+		if n != 0 {
+			return nil
+		}
+	}
+	return m.withLock(func() error {
+		if err := os.Remove(filepath.Join(m.clusterDir, appliedConfigFileName)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove the record of the applied config: %v", err)
+		}
+		return nil
+	})
+}
+
+// saveAppliedConfig records the config of the cluster directory as the one
+// last applied.
+func (m *State) saveAppliedConfig() error {
+	content, err := ioutil.ReadFile(filepath.Join(m.clusterDir, configFileName))
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.clusterDir, appliedConfigFileName), content, 0644); err != nil {
+		return fmt.Errorf("failed to record the applied config: %v", err)
+	}
+	return nil
+}
+
+// diffClusterConfigs returns the fields that differ between two configs, by
+// YAML path, sorted. Lists are compared as a whole.
+func diffClusterConfigs(old, new config.Cluster) ([]configChange, error) {
+	oldFields, err := configFields(old)
+	if err != nil {
+		return nil, err
+	}
+	newFields, err := configFields(new)
+	if err != nil {
+		return nil, err
+	}
+
+	var changes []configChange
+	for path, v := range newFields {
+		if !reflect.DeepEqual(oldFields[path], v) {
+			changes = append(changes, configChange{path: path, old: oldFields[path], new: v})
+		}
+	}
+	for path, v := range oldFields {
+		if _, ok := newFields[path]; !ok {
+			changes = append(changes, configChange{path: path, old: v})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].path < changes[j].path })
+	return changes, nil
+}
+
+// configFields returns the values of the config, by YAML path.
+func configFields(c config.Cluster) (map[string]interface{}, error) {
+	content, err := yaml.Marshal(c)
+	if err != nil {
+		return nil, err
+	}
+	var raw interface{}
+	if err := yaml.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	}
+	fields := make(map[string]interface{})
+	var flatten func(prefix string, v interface{})
+	flatten = func(prefix string, v interface{}) {
+		node, ok := v.(map[interface{}]interface{})
+		if !ok {
+			fields[prefix] = v
+			return
+		}
+		for k, child := range node {
+			path := fmt.Sprint(k)
+			if prefix != "" {
+				path = prefix + "." + path
+			}
+			flatten(path, child)
+		}
+	}
+	flatten("", raw)
+	return fields, nil
+}
+
+// ImmutableFieldError is returned when fields of the config that cannot
+// change once the cluster exists were changed since it was last applied.
+type ImmutableFieldError struct {
+	changes []configChange
+}
+
+// Fields returns the YAML paths of the changed immutable fields.
+func (e *ImmutableFieldError) Fields() []string {
+	fields := make([]string, 0, len(e.changes))
+	for _, c := range e.changes {
+		fields = append(fields, c.path)
+	}
+	return fields
+}
+
+// ImmutableFieldError implements the error interface.
+func (e *ImmutableFieldError) Error() string {
+	msgs := make([]string, 0, len(e.changes))
+	for _, c := range e.changes {
+		msgs = append(msgs, fmt.Sprintf("field %s cannot be changed once the cluster exists (%s)", c.path, strings.TrimPrefix(c.String(), c.path+": ")))
+	}
+	return fmt.Sprintf("%s; revert the change, or pass --allow-recreate to destroy and recreate the resources depending on it", strings.Join(msgs, "; "))
+}
+
+// checkImmutableChanges returns an ImmutableFieldError listing the changes
+// of immutable fields, if any.
+func checkImmutableChanges(changes []configChange) error {
+	var refused []configChange
+	for _, c := range changes {
+		for _, field := range immutableConfigFields {
+			if c.path == field {
+				refused = append(refused, c)
+			}
+		}
+	}
+	if len(refused) == 0 {
+		return nil
+	}
+	return &ImmutableFieldError{changes: refused}
+}
+
+// checkImmutableFields ensures that the immutable fields of the given config
+// did not change since it was last applied, if it ever was, unless the
+// workflow allows recreating the cluster.
+func (m *State) checkImmutableFields(cluster config.Cluster) error {
+	if _, err := os.Stat(filepath.Join(m.clusterDir, appliedConfigFileName)); os.IsNotExist(err) {
+		return nil
+	}
+	applied, err := readAppliedConfig(m.clusterDir)
+	if err != nil {
+		return err
+	}
+	applied.Internal = cluster.Internal
+	changes, err := diffClusterConfigs(*applied, cluster)
+	if err != nil {
+		return err
+	}
+	err = checkImmutableChanges(changes)
+	if err != nil && m.allowRecreate {
+		log.Warnf("Allowing changes that recreate the cluster: %s", strings.Join(err.(*ImmutableFieldError).Fields(), ", "))
+		return nil
+	}
+	return err
+}
+
+// AllowRecreate configures the workflow to accept changes of the config
+// fields that cannot change once the cluster exists, so that Terraform
+// destroys and recreates the resources depending on them.
+func (w *Workflow) AllowRecreate() {
+	w.state.allowRecreate = true
+}
+
+// changedTerraformVariables returns the names of the Terraform variables
+// that differ between two configs, sorted.
+func changedTerraformVariables(old, new config.Cluster) ([]string, error) {
+	var vars [2]map[string]interface{}
+	for i, c := range []config.Cluster{old, new} {
+		content, err := c.TFVars()
+		if err != nil {
+			return nil, err
+		}
+		if err := json.Unmarshal([]byte(content), &vars[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	var names []string
+	for name, v := range vars[1] {
+		if !reflect.DeepEqual(vars[0][name], v) {
+			names = append(names, name)
+		}
+	}
+	for name := range vars[0] {
+		if _, ok := vars[1][name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// templateVariables returns the Terraform variables the templates of the
+// given directory use.
+func templateVariables(templateDir string) (map[string]bool, error) {
+	files, err := filepath.Glob(filepath.Join(templateDir, "*.tf"))
+	if err != nil {
+		return nil, err
+	}
+	vars := make(map[string]bool)
+	for _, file := range files {
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read templates: %v", err)
+		}
+		for _, m := range templateVariableRegexp.FindAllStringSubmatch(string(content), -1) {
+			vars[m[1]] = true
+		}
+	}
+	return vars, nil
+}
@@ -0,0 +1,263 @@
+package workflow
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+func TestDiffClusterConfigs(t *testing.T) {
+	old := config.Cluster{
+		Name:      "mycluster",
+		Admin:     config.Admin{Email: "a@example.com", Password: "secret"},
+		NodePools: config.NodePools{{Name: "worker", Count: 3}},
+	}
+	new := old
+	new.Admin = config.Admin{Email: "b@example.com", Password: "other"}
+	new.NodePools = config.NodePools{{Name: "worker", Count: 5}}
+
+	changes, err := diffClusterConfigs(old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var paths []string
+	for _, c := range changes {
+		paths = append(paths, c.path)
+	}
+	if expected := "admin.email,admin.password,nodePools"; strings.Join(paths, ",") != expected {
+		t.Errorf("expected changes of %s, got %s", expected, strings.Join(paths, ","))
+	}
+	for _, c := range changes {
+		if strings.Contains(c.String(), "secret") || strings.Contains(c.String(), "other") {
+			t.Errorf("expected the password to be hidden, got %s", c)
+		}
+	}
+}
+
+func TestCheckImmutableChanges(t *testing.T) {
+	testCases := []struct {
+		test          string
+		changes       []configChange
+		expectedError bool
+	}{
+		{
+			test:    "mutable",
+			changes: []configChange{{path: "nodePools"}, {path: "aws.worker.ec2Type"}},
+		},
+		{
+			test:          "service CIDR",
+			changes:       []configChange{{path: "networking.serviceCIDR", old: "10.3.0.0/16", new: "10.4.0.0/16"}},
+			expectedError: true,
+		},
+		{
+			test:          "name",
+			changes:       []configChange{{path: "name", old: "a", new: "b"}},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		if err := checkImmutableChanges(tc.changes); (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+		}
+	}
+}
+
+func TestApplyWorkflow(t *testing.T) {
+	testCases := []struct {
+		test          string
+		edit          func(string) string
+		expectedApply []string
+		expectedError bool
+	}{
+		{
+			test: "no change",
+			edit: func(c string) string { return c },
+		},
+		{
+			test: "worker count",
+			edit: func(c string) string {
+				updated, err := setNodePoolCount([]byte(c), "worker", 5)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(updated)
+			},
+			expectedApply: []string{joinWorkersStep},
+		},
+		{
+			test: "etcd count",
+			edit: func(c string) string {
+				updated, err := setNodePoolCount([]byte(c), "etcd", 5)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(updated)
+			},
+			expectedApply: []string{etcdStep, mastersStep + " " + bootstrapOff, joinWorkersStep},
+		},
+		{
+			test:          "cluster name",
+			edit:          func(c string) string { return strings.Replace(c, "name: aws-basic", "name: other", 1) },
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		clusterDir, stepsDir := newTestClusterDir(t)
+		templates := map[string]string{
+			etcdStep:        "count = \"${var.tectonic_etcd_count}\"\n",
+			joinWorkersStep: "count = \"${var.tectonic_worker_count}\"\n",
+			mastersStep:     "count = \"${var.tectonic_master_count}\"\n",
+		}
+		for step, content := range templates {
+			if err := ioutil.WriteFile(filepath.Join(stepsDir, step, "aws", "main.tf"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, step := range []string{topologyStep, etcdStep, mastersStep, joinWorkersStep} {
+			if err := ioutil.WriteFile(filepath.Join(clusterDir, stateFileName(step)), []byte(`{"version": 3, "modules": []}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// The TLS assets of the installed cluster sign its ignition configs.
+		if err := os.MkdirAll(filepath.Join(clusterDir, "generated", "tls"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(clusterDir, "generated", "tls", "root-ca.crt"), []byte("fake CA"), 0644); err != nil {
+			t.Fatal(err)
+		}
+		configPath := filepath.Join(clusterDir, configFileName)
+		content, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(filepath.Join(clusterDir, appliedConfigFileName), content, 0644); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(configPath, []byte(tc.edit(string(content))), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		r := newFakeRunner()
+		err = runWithFake(ApplyWorkflow(clusterDir), r, stepsDir)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+		}
+		if applied := commands(r.Calls(), "apply"); strings.Join(applied, ",") != strings.Join(tc.expectedApply, ",") {
+			t.Errorf("Test case %s: expected %v to be applied, got %v", tc.test, tc.expectedApply, applied)
+		}
+		if !tc.expectedError {
+			recorded, _ := ioutil.ReadFile(filepath.Join(clusterDir, appliedConfigFileName))
+			current, _ := ioutil.ReadFile(configPath)
+			if string(recorded) != string(current) {
+				t.Errorf("Test case %s: expected the config to be recorded as applied", tc.test)
+			}
+		}
+		os.RemoveAll(filepath.Dir(clusterDir))
+	}
+}
+
+func TestAppliedConfigLifecycle(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+	appliedPath := filepath.Join(clusterDir, appliedConfigFileName)
+
+	// A first install that fails part way already records its config.
+	r := newFakeRunner()
+	r.FailOn("apply", joinWorkersStep, errors.New("apply failed"))
+	if err := runWithFake(InstallFullWorkflow(clusterDir), r, stepsDir); err == nil {
+		t.Fatal("expected the install to fail")
+	}
+	if _, err := os.Stat(appliedPath); err != nil {
+		t.Errorf("expected the config to be recorded once a step applied, got: %v", err)
+	}
+
+	if err := runWithFake(DestroyWorkflow(clusterDir), newFakeRunner(), stepsDir); err != nil {
+		t.Fatalf("expected the destroy to succeed, got: %v", err)
+	}
+	if _, err := os.Stat(appliedPath); !os.IsNotExist(err) {
+		t.Errorf("expected the record of the applied config to be removed by the destroy, got: %v", err)
+	}
+}
+
+func TestReadClusterConfigImmutableFields(t *testing.T) {
+	testCases := []struct {
+		test           string
+		edit           func(string) string
+		applied        bool
+		allowRecreate  bool
+		expectedFields []string
+	}{
+		{
+			test: "never applied",
+			edit: func(c string) string { return strings.Replace(c, "name: aws-basic", "name: other", 1) },
+		},
+		{
+			test:           "name",
+			edit:           func(c string) string { return strings.Replace(c, "name: aws-basic", "name: other", 1) },
+			applied:        true,
+			expectedFields: []string{"name"},
+		},
+		{
+			test: "service CIDR and name",
+			edit: func(c string) string {
+				return strings.NewReplacer("name: aws-basic", "name: other", "serviceCIDR: 10.3.0.0/16", "serviceCIDR: 10.4.0.0/16").Replace(c)
+			},
+			applied:        true,
+			expectedFields: []string{"name", "networking.serviceCIDR"},
+		},
+		{
+			test:          "allowed recreate",
+			edit:          func(c string) string { return strings.Replace(c, "name: aws-basic", "name: other", 1) },
+			applied:       true,
+			allowRecreate: true,
+		},
+		{
+			test: "mutable field",
+			edit: func(c string) string {
+				updated, err := setNodePoolCount([]byte(c), "worker", 5)
+				if err != nil {
+					t.Fatal(err)
+				}
+				return string(updated)
+			},
+			applied: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		clusterDir, _ := newTestClusterDir(t)
+		configPath := filepath.Join(clusterDir, configFileName)
+		content, err := ioutil.ReadFile(configPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.applied {
+			if err := ioutil.WriteFile(filepath.Join(clusterDir, appliedConfigFileName), content, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := ioutil.WriteFile(configPath, []byte(tc.edit(string(content))), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		m := &State{clusterDir: clusterDir, allowRecreate: tc.allowRecreate}
+		err = readClusterConfigStep(m)
+		var fields []string
+		if immutableErr, ok := err.(*ImmutableFieldError); ok {
+			fields = immutableErr.Fields()
+		} else if err != nil {
+			t.Errorf("Test case %s: expected an ImmutableFieldError, got %v", tc.test, err)
+		}
+		if strings.Join(fields, ",") != strings.Join(tc.expectedFields, ",") {
+			t.Errorf("Test case %s: expected changes of %v to be refused, got %v", tc.test, tc.expectedFields, fields)
+		}
+		os.RemoveAll(filepath.Dir(clusterDir))
+	}
+}
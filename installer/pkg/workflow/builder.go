@@ -0,0 +1,162 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Observer is notified of the progress of a workflow. Steps run concurrently,
+// so its methods may be called from several goroutines at the same time.
+type Observer interface {
+	// StepStarted is called before the named step runs.
+	StepStarted(step string)
+	// StepFinished is called once the named step returned, with its error
+	// and how long it ran.
+	StepFinished(step string, err error, duration time.Duration)
+}
+
+// SetObserver sets the observer notified of the progress of the workflow.
+func (w *Workflow) SetObserver(o Observer) {
+	w.observer = o
+}
+
+// Builder composes a custom workflow out of the built-in steps and steps of
+// its own. For instance, a step can run between the bootstrap and the join
+// steps of the install workflow with:
+//
+//	w, err := workflow.NewInstallBuilder(dir).
+//		Add(myStep, "bootstrap").
+//		AddDependency("join-masters", myStep.Name()).
+//		Build()
+type Builder struct {
+	workflow Workflow
+	err      error
+}
+
+// NewBuilder returns a builder of a workflow without steps, running against
+// the given cluster directory.
+func NewBuilder(clusterDir string) *Builder {
+	return &Builder{workflow: Workflow{state: State{clusterDir: clusterDir}}}
+}
+
+// NewInstallBuilder returns a builder of the full install workflow, running
+// against the given cluster directory.
+func NewInstallBuilder(clusterDir string) *Builder {
+	return &Builder{workflow: InstallFullWorkflow(clusterDir)}
+}
+
+// NewDestroyBuilder returns a builder of the destroy workflow, running
+// against the given cluster directory.
+func NewDestroyBuilder(clusterDir string) *Builder {
+	return &Builder{workflow: DestroyWorkflow(clusterDir)}
+}
+
+// Setup adds steps that run first, one after the other, before all other
+// steps. They are meant to load the state the other steps rely on and are
+// never filtered out by a step selection.
+func (b *Builder) Setup(steps ...Step) *Builder {
+	for _, step := range steps {
+		b.workflow.steps = append(b.workflow.steps, stepNode{Step: step, setup: true})
+	}
+	return b
+}
+
+// Add adds a step that runs once all the named steps completed. Dependencies
+// that are not part of the workflow are ignored.
+func (b *Builder) Add(step Step, deps ...string) *Builder {
+	b.workflow.steps = append(b.workflow.steps, stepNode{Step: step, deps: deps})
+	return b
+}
+
+// AddDependency makes the named step wait for the given steps to complete.
+func (b *Builder) AddDependency(name string, deps ...string) *Builder {
+	for i, step := range b.workflow.steps {
+		if step.Name() != name || step.setup {
+			continue
+		}
+		b.workflow.steps[i].deps = append(append([]string{}, step.deps...), deps...)
+		return b
+	}
+	if b.err == nil {
+		b.err = fmt.Errorf("cannot add dependencies to unknown step %q", name)
+	}
+	return b
+}
+
+// Observe sets the observer notified of the progress of the workflow.
+func (b *Builder) Observe(o Observer) *Builder {
+	b.workflow.observer = o
+	return b
+}
+
+// Build returns the workflow. Its steps are ordered so that every step comes
+// after its dependencies; an error is returned if that is not possible.
+func (b *Builder) Build() (Workflow, error) {
+	if b.err != nil {
+		return Workflow{}, b.err
+	}
+	steps, err := sortSteps(b.workflow.steps)
+	if err != nil {
+		return Workflow{}, err
+	}
+	if err := validateGraph(steps); err != nil {
+		return Workflow{}, err
+	}
+	w := b.workflow
+	w.steps = steps
+	return w, nil
+}
+
+// sortSteps orders the given steps so that setup steps come first and every
+// other step comes after its dependencies, keeping the declaration order
+// otherwise.
+func sortSteps(steps []stepNode) ([]stepNode, error) {
+	inWorkflow := make(map[string]bool)
+	for _, step := range steps {
+		if inWorkflow[step.Name()] {
+			return nil, fmt.Errorf("step %q is declared more than once", step.Name())
+		}
+		inWorkflow[step.Name()] = true
+	}
+
+	sorted := make([]stepNode, 0, len(steps))
+	placed := make(map[string]bool)
+	for _, step := range steps {
+		if step.setup {
+			sorted = append(sorted, step)
+			placed[step.Name()] = true
+		}
+	}
+	for len(sorted) < len(steps) {
+		progress := false
+		for _, step := range steps {
+			if placed[step.Name()] {
+				continue
+			}
+			ready := true
+			for _, dep := range step.deps {
+				if inWorkflow[dep] && !placed[dep] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				sorted = append(sorted, step)
+				placed[step.Name()] = true
+				progress = true
+				break
+			}
+		}
+		if !progress {
+			var pending []string
+			for _, step := range steps {
+				if !placed[step.Name()] {
+					pending = append(pending, step.Name())
+				}
+			}
+			return nil, fmt.Errorf("steps %s depend on each other", strings.Join(pending, ", "))
+		}
+	}
+	return sorted, nil
+}
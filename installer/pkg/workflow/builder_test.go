@@ -0,0 +1,128 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestBuilderBuild(t *testing.T) {
+	custom := NewStep("custom", test1Step)
+
+	testCases := []struct {
+		test          string
+		builder       *Builder
+		expected      []string
+		expectedError bool
+	}{
+		{
+			test: "steps in declaration order",
+			builder: NewBuilder("").
+				Setup(NewStep("setup", test1Step)).
+				Add(NewStep("a", test1Step)).
+				Add(NewStep("b", test1Step), "a"),
+			expected: []string{"setup", "a", "b"},
+		},
+		{
+			test: "step moved after its dependencies",
+			builder: NewBuilder("").
+				Add(NewStep("a", test1Step)).
+				Add(NewStep("b", test1Step), "a").
+				Add(NewStep("c", test1Step), "a").
+				AddDependency("b", "c"),
+			expected: []string{"a", "c", "b"},
+		},
+		{
+			test: "custom step between bootstrap and join",
+			builder: NewInstallBuilder("").
+				Add(custom, "bootstrap").
+				AddDependency("join-masters", "custom"),
+			expected: []string{
+				"refresh-config", "config-maps", "tls", "assets", "ignition", "topology", "tnc-cname",
+				"bootstrap", "tnc-a-record", "etcd", "join-workers", "custom", "join-masters",
+			},
+		},
+		{
+			test: "unknown step",
+			builder: NewBuilder("").
+				Add(NewStep("a", test1Step)).
+				AddDependency("b", "a"),
+			expectedError: true,
+		},
+		{
+			test: "duplicate step",
+			builder: NewBuilder("").
+				Add(NewStep("a", test1Step)).
+				Add(NewStep("a", test1Step)),
+			expectedError: true,
+		},
+		{
+			test: "cycle",
+			builder: NewBuilder("").
+				Add(NewStep("a", test1Step), "b").
+				Add(NewStep("b", test1Step), "a"),
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		w, err := tc.builder.Build()
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %t, got: %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if got := strings.Join(w.Steps(), ","); got != strings.Join(tc.expected, ",") {
+			t.Errorf("Test case %s: expected steps %v, got %v", tc.test, tc.expected, w.Steps())
+		}
+	}
+}
+
+// observer records the notifications of a workflow.
+type observer struct {
+	sync.Mutex
+	events []string
+}
+
+func (o *observer) StepStarted(step string) {
+	o.Lock()
+	defer o.Unlock()
+	o.events = append(o.events, "start "+step)
+}
+
+func (o *observer) StepFinished(step string, err error, duration time.Duration) {
+	o.Lock()
+	defer o.Unlock()
+	if err != nil {
+		o.events = append(o.events, "fail "+step)
+		return
+	}
+	o.events = append(o.events, "finish "+step)
+}
+
+func TestWorkflowObserver(t *testing.T) {
+	o := &observer{}
+	w, err := NewBuilder("").
+		Add(NewStep("a", test1Step)).
+		Add(NewStep("b", func(context.Context, *State) error {
+			return errors.New("step failed")
+		}), "a").
+		Observe(o).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Execute(context.Background()); err == nil {
+		t.Error("expected the workflow to fail")
+	}
+
+	expected := []string{"start a", "finish a", "start b", "fail b"}
+	if strings.Join(o.events, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected events %v, got %v", expected, o.events)
+	}
+}
@@ -0,0 +1,397 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/tfstate"
+)
+
+const checkpointsFileName = "checkpoints.json"
+
+// checkpoint records that a Terraform step completed successfully,
+// along with a hash of the inputs it was applied with.
+type checkpoint struct {
+	InputHash string    `json:"inputHash"`
+	Completed time.Time `json:"completed"`
+}
+
+// checkpoints is the on-disk record of the Terraform steps completed in a
+// cluster directory. It allows an interrupted workflow to be resumed
+// without re-applying steps whose inputs did not change.
+type checkpoints struct {
+	Steps map[string]checkpoint `json:"steps"`
+	// Interrupted records the workflow steps that were cut short by a signal
+	// or a timeout, along with the time of the interruption.
+	Interrupted map[string]time.Time `json:"interrupted,omitempty"`
+
+	path string
+}
+
+// loadCheckpoints reads the checkpoints file of the given cluster directory.
+// A missing file yields an empty set of checkpoints.
+func loadCheckpoints(clusterDir string) (*checkpoints, error) {
+	c := &checkpoints{
+		Steps:       make(map[string]checkpoint),
+		Interrupted: make(map[string]time.Time),
+		path:        filepath.Join(clusterDir, checkpointsFileName),
+	}
+
+	data, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return nil, fmt.Errorf("failed to read checkpoints file %s: %v", c.path, err)
+	}
+	if err := json.Unmarshal(data, c); err != nil {
+		return nil, fmt.Errorf("invalid checkpoints file %s: %v", c.path, err)
+	}
+	if c.Steps == nil {
+		c.Steps = make(map[string]checkpoint)
+	}
+	if c.Interrupted == nil {
+		c.Interrupted = make(map[string]time.Time)
+	}
+	return c, nil
+}
+
+// isDone reports whether the given step was completed by a previous run with
+// the same inputs.
+func (c *checkpoints) isDone(key, inputHash string) bool {
+	cp, ok := c.Steps[key]
+	return ok && cp.InputHash == inputHash
+}
+
+// complete records the given step as done and persists the checkpoints.
+func (c *checkpoints) complete(key, inputHash string) error {
+	c.Steps[key] = checkpoint{
+		InputHash: inputHash,
+		Completed: time.Now().UTC(),
+	}
+	return c.save()
+}
+
+// remove forgets about every invocation of the given step and persists the
+// checkpoints.
+func (c *checkpoints) remove(step string) error {
+	var found bool
+	for key := range c.Steps {
+		if key == step || strings.HasPrefix(key, step+" ") {
+			delete(c.Steps, key)
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return c.save()
+}
+
+// save writes the checkpoints file atomically, so that an interruption
+// never leaves a truncated file behind.
+func (c *checkpoints) save() error {
+	data, err := json.MarshalIndent(c, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := c.path + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write checkpoints file: %v", err)
+	}
+	return os.Rename(tmp, c.path)
+}
+
+// checkpointKey identifies a Terraform step invocation. The same templates
+// are applied more than once with different arguments, e.g. the masters step
+// in bootstrap mode and in join mode, so the arguments are part of the key.
+func checkpointKey(step string, extraArgs ...string) string {
+	if len(extraArgs) == 0 {
+		return step
+	}
+	return fmt.Sprintf("%s %s", step, strings.Join(extraArgs, " "))
+}
+
+// generatedOutputs are the files matched by the generated inputs of a state
+// that the state writes itself, relative to the cluster directory.
+var generatedOutputs = map[string][]string{
+	assetsStep: {filepath.Join(generatedPath, "tls", "service-account.key")},
+}
+
+// generatedInputs returns the files generated by other steps that the
+// templates of the given state read, as globs relative to the cluster
+// directory. The tectonic system config map is left out: it holds a secret
+// that every run generates anew, while the rest of it derives from the
+// cluster config, as the Terraform variables do.
+func (m *State) generatedInputs(state string) []string {
+	switch state {
+	case assetsStep:
+		return []string{
+			filepath.Join(generatedPath, "tls", "*.crt"),
+			filepath.Join(generatedPath, "tls", "*.key"),
+			filepath.Join(generatedPath, kcoConfigFileName),
+			filepath.Join(generatedPath, tncoConfigFileName),
+			filepath.Join(kubeSystemPath, kubeSystemFileName),
+		}
+	case mastersStep:
+		return []string{m.cluster.IgnitionMaster}
+	case joinWorkersStep:
+		return []string{m.cluster.IgnitionWorker}
+	}
+	return nil
+}
+
+// stepInputHash computes a hash over everything a Terraform state consumes:
+// its arguments, the cluster's Terraform variables, its templates, the files
+// generated by other steps that it reads and the lineage and serial of the
+// states it reads with terraform_remote_state.
+func (m *State) stepInputHash(ctx context.Context, state, templateDir string, extraArgs ...string) (string, error) {
+	h := sha256.New()
+	for _, arg := range extraArgs {
+		fmt.Fprintf(h, "arg:%s\n", arg)
+	}
+
+	if err := hashFile(h, "tfvars", filepath.Join(m.clusterDir, terraformVariablesFileName)); err != nil {
+		return "", err
+	}
+
+	var files []string
+	err := filepath.Walk(templateDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to list templates in %s: %v", templateDir, err)
+	}
+	sort.Strings(files)
+	for _, f := range files {
+		rel, err := filepath.Rel(templateDir, f)
+		if err != nil {
+			return "", err
+		}
+		if err := hashFile(h, rel, f); err != nil {
+			return "", err
+		}
+	}
+
+	if err := m.hashGeneratedInputs(h, state); err != nil {
+		return "", err
+	}
+	if err := m.hashRemoteStates(ctx, h, files); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// hashGeneratedInputs hashes the generated inputs of the given state that
+// exist.
+func (m *State) hashGeneratedInputs(w io.Writer, state string) error {
+	own := make(map[string]bool)
+	for _, f := range generatedOutputs[state] {
+		own[f] = true
+	}
+	for _, pattern := range m.generatedInputs(state) {
+		if pattern == "" {
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(m.clusterDir, pattern))
+		if err != nil {
+			return err
+		}
+		sort.Strings(matches)
+		for _, f := range matches {
+			rel, err := filepath.Rel(m.clusterDir, f)
+			if err != nil {
+				return err
+			}
+			if own[rel] {
+				continue
+			}
+			if err := hashFile(w, "generated:"+filepath.ToSlash(rel), f); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// hashRemoteStates hashes the lineage and serial of the states read by the
+// given templates with terraform_remote_state, so that a state changed since
+// the templates were applied, e.g. by another checkout of the cluster
+// directory, is found.
+func (m *State) hashRemoteStates(ctx context.Context, w io.Writer, templates []string) error {
+	read := make(map[string]bool)
+	for _, f := range templates {
+		if filepath.Ext(f) != ".tf" {
+			continue
+		}
+		content, err := ioutil.ReadFile(f)
+		if err != nil {
+			return err
+		}
+		for _, block := range localRemoteStates(string(content)) {
+			read[block.state] = true
+		}
+	}
+	var names []string
+	for name := range read {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		content, err := m.readStateFile(ctx, name)
+		if os.IsNotExist(err) {
+			fmt.Fprintf(w, "state:%s absent\n", name)
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read state %s: %v", name, err)
+		}
+		state, err := tfstate.Parse(content)
+		if err != nil {
+			return fmt.Errorf("invalid state %s: %v", stateFileName(name), err)
+		}
+		fmt.Fprintf(w, "state:%s %s %d\n", name, state.Lineage, state.Serial)
+	}
+	return nil
+}
+
+func hashFile(w io.Writer, name, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to hash step input %s: %v", path, err)
+	}
+	defer f.Close()
+
+	fmt.Fprintf(w, "file:%s\n", name)
+	_, err = io.Copy(w, f)
+	return err
+}
+
+// loadCheckpoints returns the checkpoints of the cluster directory, reading
+// them from disk on first use. The caller must hold the state lock.
+func (m *State) loadCheckpoints() (*checkpoints, error) {
+	if m.checkpoints == nil {
+		c, err := loadCheckpoints(m.clusterDir)
+		if err != nil {
+			return nil, err
+		}
+		m.checkpoints = c
+	}
+	return m.checkpoints, nil
+}
+
+// startStep reports whether the given step can be skipped because the
+// workflow is resuming, the step's state exists, a previous run completed it
+// with the same inputs and none of the workflow steps it depends on applied
+// changes during this run. Otherwise, the step is about to be applied and the
+// workflow steps depending on the running one may not be skipped anymore.
+func (m *State) startStep(ctx context.Context, key, inputHash string, hasState bool) (bool, error) {
+	name := runningStep(ctx)
+	var done bool
+	err := m.withLock(func() error {
+		cp, err := m.loadCheckpoints()
+		if err != nil {
+			return err
+		}
+		if m.resume && hasState && !m.reapplied[name] && cp.isDone(key, inputHash) {
+			done = true
+			return nil
+		}
+		m.markReapplied(name)
+		return nil
+	})
+	return done, err
+}
+
+// inheritReapplied marks the named workflow step as having applied changes
+// if one of its dependencies did, as it may consume their changed outputs.
+// The dependencies must have completed.
+func (m *State) inheritReapplied(name string, deps []string) {
+	m.withLock(func() error {
+		for _, dep := range deps {
+			if m.reapplied[dep] {
+				m.markReapplied(name)
+				break
+			}
+		}
+		return nil
+	})
+}
+
+// markReapplied records that the named workflow step applied changes. The
+// caller must hold the state lock.
+func (m *State) markReapplied(name string) {
+	if m.reapplied == nil {
+		m.reapplied = make(map[string]bool)
+	}
+	m.reapplied[name] = true
+}
+
+// completeStep records the given step as completed with the given inputs.
+func (m *State) completeStep(key, inputHash string) error {
+	return m.withLock(func() error {
+		cp, err := m.loadCheckpoints()
+		if err != nil {
+			return err
+		}
+		return cp.complete(key, inputHash)
+	})
+}
+
+// forgetStep removes the checkpoints of a destroyed step.
+func (m *State) forgetStep(step string) error {
+	return m.withLock(func() error {
+		cp, err := m.loadCheckpoints()
+		if err != nil {
+			return err
+		}
+		return cp.remove(step)
+	})
+}
+
+// interruptStep records that the named workflow step was cut short.
+func (m *State) interruptStep(name string) error {
+	return m.withLock(func() error {
+		cp, err := m.loadCheckpoints()
+		if err != nil {
+			return err
+		}
+		cp.Interrupted[name] = time.Now().UTC()
+		return cp.save()
+	})
+}
+
+// clearInterruption forgets that the named workflow step was cut short by a
+// previous run. It returns the time of that interruption, if any.
+func (m *State) clearInterruption(name string) (time.Time, error) {
+	var at time.Time
+	err := m.withLock(func() error {
+		cp, err := m.loadCheckpoints()
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if at, ok = cp.Interrupted[name]; !ok {
+			return nil
+		}
+		delete(cp.Interrupted, name)
+		return cp.save()
+	})
+	return at, err
+}
@@ -0,0 +1,225 @@
+package workflow
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	clusterDir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatalf("failed to create cluster dir: %v", err)
+	}
+	defer os.RemoveAll(clusterDir)
+
+	cp, err := loadCheckpoints(clusterDir)
+	if err != nil {
+		t.Fatalf("failed to load missing checkpoints: %v", err)
+	}
+	if cp.isDone(tlsStep, "hash") {
+		t.Errorf("expected no step to be done in an empty cluster dir")
+	}
+
+	bootstrapKey := checkpointKey(mastersStep, bootstrapOn)
+	joinKey := checkpointKey(mastersStep, bootstrapOff)
+	if bootstrapKey == joinKey {
+		t.Fatalf("expected different keys for bootstrap and join, got %q", joinKey)
+	}
+	for _, key := range []string{tlsStep, bootstrapKey, joinKey} {
+		if err := cp.complete(key, "hash"); err != nil {
+			t.Fatalf("failed to complete step %q: %v", key, err)
+		}
+	}
+
+	cp, err = loadCheckpoints(clusterDir)
+	if err != nil {
+		t.Fatalf("failed to reload checkpoints: %v", err)
+	}
+
+	testCases := []struct {
+		test     string
+		key      string
+		hash     string
+		expected bool
+	}{
+		{
+			test:     "same inputs",
+			key:      tlsStep,
+			hash:     "hash",
+			expected: true,
+		},
+		{
+			test:     "changed inputs",
+			key:      tlsStep,
+			hash:     "other",
+			expected: false,
+		},
+		{
+			test:     "unknown step",
+			key:      etcdStep,
+			hash:     "hash",
+			expected: false,
+		},
+	}
+	for _, tc := range testCases {
+		if got := cp.isDone(tc.key, tc.hash); got != tc.expected {
+			t.Errorf("test case %s: expected %v, got %v", tc.test, tc.expected, got)
+		}
+	}
+
+	if err := cp.remove(mastersStep); err != nil {
+		t.Fatalf("failed to remove step: %v", err)
+	}
+	if len(cp.Steps) != 1 {
+		t.Errorf("expected all masters invocations to be removed, got %v", cp.Steps)
+	}
+}
+
+func TestResumeReappliesDependentSteps(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+	if err := runWithFake(InstallFullWorkflow(clusterDir), newFakeRunner(), stepsDir); err != nil {
+		t.Fatalf("expected the install to succeed, got: %v", err)
+	}
+
+	// Changing the masters templates re-applies join-masters, but not
+	// join-workers, which does not depend on it.
+	if err := ioutil.WriteFile(filepath.Join(stepsDir, mastersStep, "aws", "main.tf"), []byte("# changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w := InstallFullWorkflow(clusterDir)
+	w.Resume()
+	r := newFakeRunner()
+	if err := runWithFake(w, r, stepsDir); err != nil {
+		t.Fatalf("expected the resumed install to succeed, got: %v", err)
+	}
+	expected := checkpointKey(mastersStep, bootstrapOff)
+	if applied := commands(r.Calls(), "apply"); strings.Join(applied, ",") != expected {
+		t.Errorf("expected only %q to be applied, got %v", expected, applied)
+	}
+}
+
+func TestStepInputHash(t *testing.T) {
+	clusterDir, err := ioutil.TempDir("", "checkpoints")
+	if err != nil {
+		t.Fatalf("failed to create cluster dir: %v", err)
+	}
+	defer os.RemoveAll(clusterDir)
+
+	templateDir := filepath.Join(clusterDir, "templates")
+	if err := os.MkdirAll(filepath.Join(clusterDir, generatedPath, "tls"), 0755); err != nil {
+		t.Fatalf("failed to create generated dir: %v", err)
+	}
+	writeTemplates(t, templateDir, map[string]string{
+		"inputs.tf": "data \"terraform_remote_state\" \"topology\" {\n  backend = \"local\"\n\n  config {\n    path = \"${path.cwd}/topology.tfstate\"\n  }\n}\n",
+	})
+	tfvars := filepath.Join(clusterDir, terraformVariablesFileName)
+	if err := ioutil.WriteFile(tfvars, []byte("a"), 0644); err != nil {
+		t.Fatalf("failed to write %s: %v", tfvars, err)
+	}
+
+	m := &State{clusterDir: clusterDir}
+	hash := func(extraArgs ...string) string {
+		h, err := m.stepInputHash(context.Background(), assetsStep, templateDir, extraArgs...)
+		if err != nil {
+			t.Fatalf("failed to hash inputs: %v", err)
+		}
+		return h
+	}
+	base := hash()
+	if again := hash(); again != base {
+		t.Errorf("expected hash to be stable, got %s and %s", base, again)
+	}
+	if withArgs := hash(bootstrapOn); withArgs == base {
+		t.Errorf("expected arguments to change the hash")
+	}
+
+	testCases := []struct {
+		test     string
+		file     string
+		content  string
+		expected bool
+	}{
+		{
+			test:     "tfvars",
+			file:     terraformVariablesFileName,
+			content:  "b",
+			expected: true,
+		},
+		{
+			test:     "template",
+			file:     "templates/main.tf",
+			content:  "b",
+			expected: true,
+		},
+		{
+			test:     "tls asset",
+			file:     "generated/tls/kube-ca.crt",
+			content:  "a",
+			expected: true,
+		},
+		{
+			test:     "config map",
+			file:     "generated/kco-config.yaml",
+			content:  "a",
+			expected: true,
+		},
+		{
+			test:    "own output",
+			file:    "generated/tls/service-account.key",
+			content: "a",
+		},
+		{
+			test:    "unread file",
+			file:    "generated/auth/kubeconfig",
+			content: "a",
+		},
+		{
+			test:     "upstream state applied",
+			file:     "topology.tfstate",
+			content:  `{"version": 3, "lineage": "a", "serial": 1, "modules": []}`,
+			expected: true,
+		},
+		{
+			test:     "upstream state changed",
+			file:     "topology.tfstate",
+			content:  `{"version": 3, "lineage": "a", "serial": 2, "modules": []}`,
+			expected: true,
+		},
+		{
+			test:     "upstream state recreated",
+			file:     "topology.tfstate",
+			content:  `{"version": 3, "lineage": "b", "serial": 2, "modules": []}`,
+			expected: true,
+		},
+		{
+			test:    "unread state",
+			file:    "etcd.tfstate",
+			content: `{"version": 3, "lineage": "a", "serial": 1, "modules": []}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		writeTemplates(t, clusterDir, map[string]string{tc.file: tc.content})
+		changed := hash()
+		if (changed != base) != tc.expected {
+			t.Errorf("Test case %s: expected a change to %s to change the hash: %t", tc.test, tc.file, tc.expected)
+		}
+		base = changed
+	}
+
+	// The masters read their ignition config.
+	m.cluster.IgnitionMaster = "ignition-master.ign"
+	masters, err := m.stepInputHash(context.Background(), mastersStep, templateDir)
+	if err != nil {
+		t.Fatalf("failed to hash inputs: %v", err)
+	}
+	writeTemplates(t, clusterDir, map[string]string{m.cluster.IgnitionMaster: "{}"})
+	if changed, _ := m.stepInputHash(context.Background(), mastersStep, templateDir); changed == masters {
+		t.Error("expected a change to the ignition config of the masters to change their hash")
+	}
+}
@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// The outcomes of a step in a run that continues on errors.
+const (
+	stepSucceeded = "succeeded"
+	stepFailed    = "failed"
+	stepSkipped   = "skipped"
+)
+
+// stepOutcome is the result of a step in a run that continues on errors.
+type stepOutcome struct {
+	status string
+	// attempts is the number of times the step ran.
+	attempts int
+	err      error
+}
+
+// ContinueOnError configures the workflow to try every step, even those
+// depending on a failed one, rather than stopping at the first failure. The
+// failed steps are tried once more at the end and a report of all steps is
+// printed. The Terraform states of the steps that did not complete are kept,
+// so that running the workflow again finishes the job.
+func (w *Workflow) ContinueOnError() {
+	w.continueOnError = true
+}
+
+// runContinuingOnError runs the steps, then retries once those that failed,
+// and writes the report of the run to out. The errors of the steps that are
+// left over are returned.
+func (w *Workflow) runContinuingOnError(ctx context.Context, steps []stepNode, out io.Writer) error {
+	outcomes := make(map[string]*stepOutcome)
+	err := w.run(ctx, steps, outcomes)
+	if _, ok := err.(*ExecutionError); err != nil && !ok {
+		return err
+	}
+
+	var left []stepNode
+	for _, step := range steps {
+		if o := outcomes[step.Name()]; o == nil || o.status == stepFailed {
+			left = append(left, step)
+		}
+	}
+	if len(left) != 0 && ctx.Err() == nil {
+		log.Infof("Retrying %d steps that did not complete", len(left))
+		err = w.run(ctx, left, outcomes)
+		if _, ok := err.(*ExecutionError); err != nil && !ok {
+			return err
+		}
+	}
+
+	if err := printStepReport(out, steps, outcomes); err != nil {
+		return err
+	}
+
+	var errs []StepError
+	for _, step := range steps {
+		o := outcomes[step.Name()]
+		switch {
+		case o == nil || o.status == "":
+			errs = append(errs, StepError{Step: step.Name(), Err: ctx.Err()})
+		case o.status == stepFailed:
+			errs = append(errs, StepError{Step: step.Name(), Err: o.err})
+		}
+	}
+	if len(errs) != 0 {
+		return &ExecutionError{Errors: errs}
+	}
+	return nil
+}
+
+// skipStep records that the workflow step running in the given context found
+// nothing to do.
+func (m *State) skipStep(ctx context.Context) {
+	name := runningStep(ctx)
+	if name == "" {
+		return
+	}
+	m.withLock(func() error {
+		if m.skipped == nil {
+			m.skipped = make(map[string]bool)
+		}
+		m.skipped[name] = true
+		return nil
+	})
+}
+
+// wasSkipped returns whether the named workflow step found nothing to do.
+func (m *State) wasSkipped(name string) bool {
+	var skipped bool
+	m.withLock(func() error {
+		skipped = m.skipped[name]
+		return nil
+	})
+	return skipped
+}
+
+// printStepReport writes the outcome of each step to w, as a table.
+func printStepReport(w io.Writer, steps []stepNode, outcomes map[string]*stepOutcome) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tRESULT\tATTEMPTS\tERROR\t")
+	for _, step := range steps {
+		o := outcomes[step.Name()]
+		if o == nil || o.status == "" {
+			fmt.Fprintf(tw, "%s\tnot run\t%d\t\t\n", step.Name(), attempts(o))
+			continue
+		}
+		var msg string
+		if o.err != nil {
+			msg = strings.Replace(o.err.Error(), "\n", " ", -1)
+			if len(msg) > maxStatusValueLength {
+				msg = msg[:maxStatusValueLength-3] + "..."
+			}
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\t\n", step.Name(), o.status, o.attempts, msg)
+	}
+	return tw.Flush()
+}
+
+// attempts returns the number of times the step of the outcome ran.
+func attempts(o *stepOutcome) int {
+	if o == nil {
+		return 0
+	}
+	return o.attempts
+}
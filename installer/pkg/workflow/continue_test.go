@@ -0,0 +1,154 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// flakyStep returns a step that fails the given number of times, then
+// succeeds, possibly finding nothing to do.
+func flakyStep(failures int, nothingToDo bool, calls map[string]int, lock *sync.Mutex, name string) StepFunc {
+	return func(ctx context.Context, m *State) error {
+		lock.Lock()
+		defer lock.Unlock()
+		calls[name]++
+		if calls[name] <= failures {
+			return errors.New(name + " failed")
+		}
+		if nothingToDo {
+			m.skipStep(ctx)
+		}
+		return nil
+	}
+}
+
+func TestWorkflowContinueOnError(t *testing.T) {
+	testCases := []struct {
+		test             string
+		failures         map[string]int
+		nothingToDo      map[string]bool
+		expectedCalls    map[string]int
+		expectedStatus   map[string]string
+		expectedFailures []string
+	}{
+		{
+			test:           "no failure",
+			expectedCalls:  map[string]int{"a": 1, "b": 1, "c": 1, "d": 1},
+			expectedStatus: map[string]string{"a": stepSucceeded, "b": stepSucceeded, "c": stepSucceeded, "d": stepSucceeded},
+		},
+		{
+			test:           "failure fixed by the retry",
+			failures:       map[string]int{"b": 1},
+			expectedCalls:  map[string]int{"a": 1, "b": 2, "c": 1, "d": 1},
+			expectedStatus: map[string]string{"a": stepSucceeded, "b": stepSucceeded, "c": stepSucceeded, "d": stepSucceeded},
+		},
+		{
+			test:             "persistent failure",
+			failures:         map[string]int{"b": 2},
+			expectedCalls:    map[string]int{"a": 1, "b": 2, "c": 1, "d": 1},
+			expectedStatus:   map[string]string{"a": stepSucceeded, "b": stepFailed, "c": stepSucceeded, "d": stepSucceeded},
+			expectedFailures: []string{"b"},
+		},
+		{
+			test:           "nothing to do",
+			nothingToDo:    map[string]bool{"c": true},
+			expectedCalls:  map[string]int{"a": 1, "b": 1, "c": 1, "d": 1},
+			expectedStatus: map[string]string{"a": stepSucceeded, "b": stepSucceeded, "c": stepSkipped, "d": stepSucceeded},
+		},
+	}
+
+	for _, tc := range testCases {
+		calls := make(map[string]int)
+		lock := &sync.Mutex{}
+		step := func(name string, deps ...string) stepNode {
+			return newStep(name, flakyStep(tc.failures[name], tc.nothingToDo[name], calls, lock, name), deps...)
+		}
+		wf := Workflow{
+			steps:           []stepNode{step("a"), step("b", "a"), step("c", "a"), step("d", "b", "c")},
+			continueOnError: true,
+		}
+
+		var out bytes.Buffer
+		err := wf.runContinuingOnError(context.Background(), wf.steps, &out)
+
+		var failures []string
+		if execErr, ok := err.(*ExecutionError); ok {
+			for _, e := range execErr.Errors {
+				failures = append(failures, e.Step)
+			}
+		} else if err != nil {
+			t.Errorf("Test case %s: expected an ExecutionError, got %v", tc.test, err)
+		}
+		if strings.Join(failures, ",") != strings.Join(tc.expectedFailures, ",") {
+			t.Errorf("Test case %s: expected failed steps %v, got %v", tc.test, tc.expectedFailures, failures)
+		}
+		for name, expected := range tc.expectedCalls {
+			if calls[name] != expected {
+				t.Errorf("Test case %s: expected step %s to run %d times, got %d", tc.test, name, expected, calls[name])
+			}
+		}
+		if calls["d"] != tc.expectedCalls["d"] {
+			t.Errorf("Test case %s: expected step d to run %d times, got %d", tc.test, tc.expectedCalls["d"], calls["d"])
+		}
+		for name, status := range tc.expectedStatus {
+			found := false
+			for _, line := range strings.Split(out.String(), "\n") {
+				fields := strings.Fields(line)
+				if len(fields) >= 2 && fields[0] == name {
+					found = fields[1] == status
+				}
+			}
+			if !found {
+				t.Errorf("Test case %s: expected step %s to be reported as %s, got:\n%s", tc.test, name, status, out.String())
+			}
+		}
+	}
+}
+
+func TestDestroyWorkflowContinueOnError(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	if err := runWithFake(InstallFullWorkflow(clusterDir), newFakeRunner(), stepsDir); err != nil {
+		t.Fatalf("expected the install to succeed, got: %v", err)
+	}
+
+	// The network cannot be removed, e.g. because of a stuck ELB.
+	r := newFakeRunner()
+	r.FailOn("destroy", topologyStep, errors.New("DependencyViolation"))
+	w := DestroyWorkflow(clusterDir)
+	w.ContinueOnError()
+	err := runWithFake(w, r, stepsDir)
+
+	execErr, ok := err.(*ExecutionError)
+	if !ok || len(execErr.Errors) != 1 || execErr.Errors[0].Step != "topology" {
+		t.Fatalf("expected only step topology to fail, got: %v", err)
+	}
+	expected := []string{
+		mastersStep + " " + bootstrapOff,
+		joinWorkersStep,
+		etcdStep,
+		tncDNSStep + " " + bootstrapOff,
+		topologyStep,
+		assetsStep,
+		tlsStep,
+		topologyStep,
+	}
+	if got := commands(r.Calls(), "destroy"); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected destroys %v, got %v", expected, got)
+	}
+	for _, step := range []string{tlsStep, assetsStep, tncDNSStep, mastersStep, etcdStep, joinWorkersStep} {
+		if hasStateFile(clusterDir, step) {
+			t.Errorf("expected the state of step %s to be removed", step)
+		}
+	}
+	if !hasStateFile(clusterDir, topologyStep) {
+		t.Error("expected the state of the failed step topology to be kept")
+	}
+}
@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -12,15 +13,15 @@ import (
 // responsible for converting an old cluster config.
 func ConvertWorkflow(configFilePath string) Workflow {
 	return Workflow{
-		metadata: metadata{configFilePath: configFilePath},
-		steps: []Step{
-			readTFVarsConfigStep,
-			printYAMLConfigStep,
+		state: State{configFilePath: configFilePath},
+		steps: []stepNode{
+			newSetupStep("read-tfvars", readTFVarsConfigStep),
+			newStep("print-yaml", printYAMLConfigStep),
 		},
 	}
 }
 
-func readTFVarsConfigStep(m *metadata) error {
+func readTFVarsConfigStep(ctx context.Context, m *State) error {
 	data, err := ioutil.ReadFile(m.configFilePath)
 	if err != nil {
 		return err
@@ -31,7 +32,7 @@ func readTFVarsConfigStep(m *metadata) error {
 	return json.Unmarshal([]byte(data), &m.cluster)
 }
 
-func printYAMLConfigStep(m *metadata) error {
+func printYAMLConfigStep(ctx context.Context, m *State) error {
 	yaml, err := m.cluster.YAML()
 	if err != nil {
 		return err
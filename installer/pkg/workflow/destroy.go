@@ -1,66 +1,272 @@
 package workflow
 
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/tfstate"
+)
+
+// The built-in steps of the destroy workflow.
+var (
+	DestroyJoinMasters = newTerraformStep("join-masters", destroyJoinMastersStep)
+	DestroyJoinWorkers = newTerraformStep("join-workers", destroyJoinWorkersStep)
+	DestroyEtcd        = newTerraformStep("etcd", destroyEtcdStep)
+	DestroyBootstrap   = newTerraformStep("bootstrap", destroyBootstrapStep)
+	DestroyTNCRecords  = newTerraformStep("tnc-dns", destroyTNCDNSStep)
+	DestroyTopology    = newTerraformStep("topology", destroyTopologyStep)
+	DestroyAssets      = newTerraformStep("assets", destroyAssetsStep)
+	DestroyTLS         = newTerraformStep("tls", destroyTLSAssetsStep)
+)
+
+// destroyDeps are the dependencies of the destroy steps, by name. A step is
+// only destroyed once nothing relies on it anymore.
+var destroyDeps = map[string][]string{
+	"etcd":      {"join-masters", "join-workers"},
+	"bootstrap": {"join-masters"},
+	"tnc-dns":   {"bootstrap", "etcd"},
+	"topology":  {"tnc-dns"},
+	"assets":    {"topology"},
+	"tls":       {"assets"},
+}
+
+// destroyStates are the Terraform states removed by the destroy steps, by
+// name.
+var destroyStates = map[string][]string{
+	"join-masters": {mastersStep},
+	"join-workers": {joinWorkersStep},
+	"etcd":         {etcdStep},
+	"bootstrap":    {mastersStep},
+	"tnc-dns":      {tncDNSStep},
+	"topology":     {topologyStep},
+	"assets":       {assetsStep},
+	"tls":          {tlsStep},
+}
+
 // DestroyWorkflow creates new instances of the 'destroy' workflow,
 // responsible for running the actions required to remove resources
 // of an existing cluster and clean up any remaining artefacts.
 func DestroyWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			destroyJoinMastersStep,
-			destroyJoinWorkersStep,
-			destroyEtcdStep,
-			destroyBootstrapStep,
-			destroyTNCDNSStep,
-			destroyTopologyStep,
-			destroyAssetsStep,
-			destroyTLSAssetsStep,
-		},
+	b := NewBuilder(clusterDir).Setup(RefreshConfig)
+	for _, step := range []Step{
+		DestroyJoinMasters,
+		DestroyJoinWorkers,
+		DestroyEtcd,
+		DestroyBootstrap,
+		DestroyTNCRecords,
+		DestroyTopology,
+		DestroyAssets,
+		DestroyTLS,
+	} {
+		b.Add(step, destroyDeps[step.Name()]...)
+	}
+	return b.workflow
+}
+
+// destroyTargetWorkflow returns a workflow destroying only the given steps,
+// once it checked that nothing still relies on them.
+func destroyTargetWorkflow(clusterDir string, steps ...Step) Workflow {
+	var names []string
+	for _, step := range steps {
+		names = append(names, step.Name())
+	}
+	check := NewStep("check-dependents", func(ctx context.Context, m *State) error {
+		return checkDestroyDependents(ctx, m, names)
+	})
+	b := NewBuilder(clusterDir).Setup(RefreshConfig, check)
+	for _, step := range steps {
+		b.Add(step, destroyDeps[step.Name()]...)
+	}
+	return b.workflow
+}
+
+// DestroyWorkersWorkflow creates new instances of the 'destroy workers'
+// workflow, responsible for removing the worker nodes of a cluster.
+func DestroyWorkersWorkflow(clusterDir string) Workflow {
+	return destroyTargetWorkflow(clusterDir, DestroyJoinWorkers)
+}
+
+// DestroyMastersWorkflow creates new instances of the 'destroy masters'
+// workflow, responsible for removing the master nodes of a cluster.
+func DestroyMastersWorkflow(clusterDir string) Workflow {
+	return destroyTargetWorkflow(clusterDir, DestroyJoinMasters)
+}
+
+// DestroyEtcdWorkflow creates new instances of the 'destroy etcd' workflow,
+// responsible for removing the etcd nodes of a cluster.
+func DestroyEtcdWorkflow(clusterDir string) Workflow {
+	return destroyTargetWorkflow(clusterDir, DestroyEtcd)
+}
+
+// DestroyBootstrapWorkflow creates new instances of the 'destroy bootstrap'
+// workflow, responsible for removing the bootstrap node of a cluster along
+// with the TNC records pointing to it.
+func DestroyBootstrapWorkflow(clusterDir string) Workflow {
+	return destroyTargetWorkflow(clusterDir, DestroyBootstrap, DestroyTNCRecords)
+}
+
+// DestroyTopologyWorkflow creates new instances of the 'destroy topology'
+// workflow, responsible for removing the network of a cluster.
+func DestroyTopologyWorkflow(clusterDir string) Workflow {
+	return destroyTargetWorkflow(clusterDir, DestroyTopology)
+}
+
+// ForceDestroy configures targeted destroy workflows to destroy their steps
+// even though the states of other steps still rely on them.
+func (w *Workflow) ForceDestroy() {
+	w.state.forceDestroy = true
+}
+
+// checkDestroyDependents ensures that none of the steps that must be
+// destroyed before the given ones, as per destroyDeps, still has resources,
+// unless the destroy is forced.
+func checkDestroyDependents(ctx context.Context, m *State, steps []string) error {
+	destroyed := make(map[string]bool)
+	for _, step := range steps {
+		for _, state := range destroyStates[step] {
+			destroyed[state] = true
+		}
+	}
+
+	var dependents []string
+	visited := make(map[string]bool)
+	var visit func(step string) error
+	visit = func(step string) error {
+		for _, dep := range destroyDeps[step] {
+			if visited[dep] {
+				continue
+			}
+			visited[dep] = true
+			for _, state := range destroyStates[dep] {
+				if destroyed[state] {
+					continue
+				}
+				// A state may be removed by several steps.
+				destroyed[state] = true
+				n, err := m.stateResources(ctx, state)
+				if err != nil {
+					return err
+				}
+				if n != 0 {
+					dependents = append(dependents, state)
+				}
+			}
+			if err := visit(dep); err != nil {
+				return err
+			}
+		}
+		return nil
 	}
+	for _, step := range steps {
+		if err := visit(step); err != nil {
+			return err
+		}
+	}
+	if len(dependents) == 0 {
+		return nil
+	}
+
+	msg := fmt.Sprintf("the states of %s still rely on step %s", strings.Join(dependents, ", "), strings.Join(steps, ", "))
+	if m.forceDestroy {
+		log.Warnf("Destroying anyway as forced: %s", msg)
+		return nil
+	}
+	return fmt.Errorf("refusing to destroy: %s; destroy them first or use --force", msg)
 }
 
-func destroyTLSAssetsStep(m *metadata) error {
-	return runDestroyStep(m, tlsStep)
+// stateResources returns the number of resource instances of the named
+// state. A state that was destroyed has none, though Terraform keeps its
+// file.
+func (m *State) stateResources(ctx context.Context, name string) (int, error) {
+	content, err := m.readStateFile(ctx, name)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read state %s: %v", name, err)
+	}
+	state, err := tfstate.Parse(content)
+	if err != nil {
+		return 0, fmt.Errorf("invalid state %s: %v", stateFileName(name), err)
+	}
+	return resourceCount(state), nil
 }
 
-func destroyAssetsStep(m *metadata) error {
-	return runDestroyStep(m, assetsStep)
+func destroyTLSAssetsStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, tlsStep)
 }
 
-func destroyEtcdStep(m *metadata) error {
-	return runDestroyStep(m, etcdStep)
+func destroyAssetsStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, assetsStep)
 }
 
-func destroyBootstrapStep(m *metadata) error {
-	return runDestroyStep(m, mastersStep, []string{bootstrapOff}...)
+func destroyEtcdStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, etcdStep)
 }
 
-func destroyTNCDNSStep(m *metadata) error {
-	return destroyTNCDNS(m)
+func destroyBootstrapStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, mastersStep, []string{bootstrapOff}...)
 }
 
-func destroyTopologyStep(m *metadata) error {
-	return runDestroyStep(m, topologyStep)
+func destroyTNCDNSStep(ctx context.Context, m *State) error {
+	return destroyTNCDNS(ctx, m)
 }
 
-func destroyJoinWorkersStep(m *metadata) error {
-	return runDestroyStep(m, joinWorkersStep)
+func destroyTopologyStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, topologyStep)
 }
 
-func destroyJoinMastersStep(m *metadata) error {
-	return runDestroyStep(m, mastersStep, []string{bootstrapOff}...)
+func destroyJoinWorkersStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, joinWorkersStep)
 }
 
-func runDestroyStep(m *metadata, step string, extraArgs ...string) error {
-	if !hasStateFile(m.clusterDir, step) {
+func destroyJoinMastersStep(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, mastersStep, []string{bootstrapOff}...)
+}
+
+func runDestroyStep(ctx context.Context, m *State, step string, extraArgs ...string) error {
+	has, err := m.hasState(ctx, step)
+	if err != nil {
+		return err
+	}
+	if !has {
 		// there is no statefile, therefore nothing to destroy for this step
+		m.skipStep(ctx)
 		return nil
 	}
-	templateDir, err := findStepTemplates(step, m.cluster.Platform)
+	templateDir, err := m.stepTemplates(step)
+	if err != nil {
+		return err
+	}
+	configDir, err := m.stepConfigDir(step, templateDir)
 	if err != nil {
 		return err
 	}
 
-	return tfDestroy(m.clusterDir, step, templateDir, extraArgs...)
+	// The step may be destroyed from another checkout of the cluster
+	// directory, without the data directory of its apply.
+	if err := m.terraform().Init(ctx, m.clusterDir, step, configDir); err != nil {
+		return err
+	}
+	if m.plan {
+		return runPlanStep(ctx, m, step, configDir, true, extraArgs...)
+	}
+
+	if m.savedPlans {
+		err = m.applySavedPlan(ctx, step, true, extraArgs...)
+	} else {
+		err = m.terraform().Destroy(ctx, m.clusterDir, step, configDir, extraArgs...)
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.forgetStep(step); err != nil {
+		return err
+	}
+	return m.removeAppliedConfig(ctx)
 }
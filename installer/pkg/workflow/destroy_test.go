@@ -0,0 +1,119 @@
+package workflow
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// liveState is a state with a resource left, while destroyedState is what
+// Terraform leaves once it destroyed all the resources of a state.
+const (
+	liveState = `{
+  "version": 3,
+  "serial": 2,
+  "lineage": "d0b6f3a3-3e5b-4e8c-9d0e-5e7b3b2c1a00",
+  "modules": [
+    {
+      "path": ["root"],
+      "resources": {
+        "aws_instance.worker": {"type": "aws_instance", "primary": {"id": "i-0123"}}
+      }
+    }
+  ]
+}`
+	destroyedState = `{
+  "version": 3,
+  "serial": 3,
+  "lineage": "d0b6f3a3-3e5b-4e8c-9d0e-5e7b3b2c1a00",
+  "modules": [{"path": ["root"], "resources": {}}]
+}`
+)
+
+func TestCheckDestroyDependents(t *testing.T) {
+	testCases := []struct {
+		test            string
+		steps           []string
+		states          []string
+		destroyedStates []string
+		force           bool
+		expectedError   bool
+	}{
+		{
+			test:   "workers",
+			steps:  []string{"join-workers"},
+			states: []string{topologyStep, mastersStep, etcdStep, joinWorkersStep},
+		},
+		{
+			test:          "etcd with workers",
+			steps:         []string{"etcd"},
+			states:        []string{topologyStep, etcdStep, joinWorkersStep},
+			expectedError: true,
+		},
+		{
+			test:   "etcd without nodes",
+			steps:  []string{"etcd"},
+			states: []string{topologyStep, etcdStep},
+		},
+		{
+			test:          "topology with masters",
+			steps:         []string{"topology"},
+			states:        []string{topologyStep, mastersStep},
+			expectedError: true,
+		},
+		{
+			test:   "forced topology with masters",
+			steps:  []string{"topology"},
+			states: []string{topologyStep, mastersStep},
+			force:  true,
+		},
+		{
+			test:   "bootstrap destroying the masters state itself",
+			steps:  []string{"bootstrap", "tnc-dns"},
+			states: []string{topologyStep, mastersStep, tncDNSStep},
+		},
+		{
+			test:          "bootstrap with etcd",
+			steps:         []string{"bootstrap", "tnc-dns"},
+			states:        []string{topologyStep, mastersStep, tncDNSStep, etcdStep},
+			expectedError: true,
+		},
+		{
+			test:            "etcd with destroyed workers",
+			steps:           []string{"etcd"},
+			states:          []string{topologyStep, etcdStep},
+			destroyedStates: []string{joinWorkersStep, mastersStep},
+		},
+		{
+			test:            "topology with destroyed nodes",
+			steps:           []string{"topology"},
+			states:          []string{topologyStep},
+			destroyedStates: []string{tncDNSStep, mastersStep, etcdStep, joinWorkersStep},
+		},
+	}
+
+	for _, tc := range testCases {
+		dir, err := ioutil.TempDir("", "destroy")
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, state := range tc.states {
+			if err := ioutil.WriteFile(filepath.Join(dir, stateFileName(state)), []byte(liveState), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for _, state := range tc.destroyedStates {
+			if err := ioutil.WriteFile(filepath.Join(dir, stateFileName(state)), []byte(destroyedState), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		m := &State{clusterDir: dir, forceDestroy: tc.force}
+		err = checkDestroyDependents(context.Background(), m, tc.steps)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+		}
+		os.RemoveAll(dir)
+	}
+}
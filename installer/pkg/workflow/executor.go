@@ -1,12 +1,15 @@
 package workflow
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"time"
 )
 
 // executor enables calling TerraForm from Go, across platforms, with any
@@ -16,7 +19,12 @@ import (
 // The TerraForm binary is expected to be in the executing binary's folder, in
 // the current working directory or in the PATH.
 type executor struct {
-	binaryPath string
+	binaryPath  string
+	stdout      io.Writer
+	stderr      io.Writer
+	gracePeriod time.Duration
+	// env is added to the environment TerraForm runs in.
+	env []string
 }
 
 // Set the binary names for different platforms
@@ -25,20 +33,36 @@ const (
 	tfBinWindows = "terraform.exe"
 )
 
+// defaultGracePeriod is how long TerraForm is given to exit cleanly once
+// interrupted, before being killed.
+const defaultGracePeriod = 2 * time.Minute
+
 // errBinaryNotFound denotes the fact that the TerraForm binary could not be
 // found on disk.
 var errBinaryNotFound = errors.New(
 	"TerraForm not in executable's folder, cwd nor PATH",
 )
 
-// newExecutor initializes a new Executor.
-func newExecutor() (*executor, error) {
-	ex := new(executor)
+// newExecutor initializes a new Executor, running the given TerraForm
+// binary or, if empty, the one found on disk.
+func newExecutor(binPath string) (*executor, error) {
+	ex := &executor{
+		stdout:      os.Stdout,
+		stderr:      os.Stderr,
+		gracePeriod: defaultGracePeriod,
+	}
 
-	// Find the TerraForm binary.
-	binPath, err := tfBinaryPath()
-	if err != nil {
-		return nil, err
+	if binPath != "" {
+		if stat, err := os.Stat(binPath); err != nil || stat.IsDir() {
+			return nil, fmt.Errorf("TerraForm binary %s not found", binPath)
+		}
+	} else {
+		// Find the TerraForm binary.
+		var err error
+		binPath, err = tfBinaryPath()
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	ex.binaryPath = binPath
@@ -50,7 +74,11 @@ func newExecutor() (*executor, error) {
 // An error is returned if the TerraForm binary could not be found, or if the
 // TerraForm call itself failed, in which case, details can be found in the
 // output.
-func (ex *executor) execute(clusterDir string, args ...string) error {
+//
+// When the context is done, TerraForm is sent an interrupt so that it can
+// release its state lock and write its state file. It is killed if it did not
+// exit once the grace period elapsed.
+func (ex *executor) execute(ctx context.Context, clusterDir string, args ...string) error {
 	// Prepare TerraForm command by setting up the command, configuration,
 	// and the working directory
 	if clusterDir == "" {
@@ -58,13 +86,45 @@ func (ex *executor) execute(clusterDir string, args ...string) error {
 	}
 
 	cmd := exec.Command(ex.binaryPath, args...)
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	cmd.Stdout = ex.stdout
+	cmd.Stderr = ex.stderr
 	cmd.Dir = clusterDir
+	if len(ex.env) != 0 {
+		cmd.Env = append(os.Environ(), ex.env...)
+	}
+	// TerraForm runs in its own process group, so that it only receives the
+	// interrupt forwarded below and not a second one from the terminal,
+	// which would make it exit immediately. As a background process, it
+	// cannot read from the terminal and its stdin is left unset.
+	setProcessGroup(cmd)
 
 	// Start TerraForm.
-	return cmd.Run()
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+
+	if err := interrupt(cmd.Process); err != nil {
+		cmd.Process.Kill()
+	}
+	select {
+	case <-done:
+	case <-time.After(ex.gracePeriod):
+		cmd.Process.Kill()
+		<-done
+		return fmt.Errorf("TerraForm did not exit within %s after being interrupted: %v", ex.gracePeriod, ctx.Err())
+	}
+	return fmt.Errorf("TerraForm was interrupted: %v", ctx.Err())
 }
 
 // tfBinatyPath searches for a TerraForm binary on disk:
@@ -0,0 +1,58 @@
+package workflow
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestExecutorInterrupt(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("interrupts are not supported on windows")
+	}
+
+	dir, err := ioutil.TempDir("", "executor")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %v", err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		test        string
+		script      string
+		gracePeriod time.Duration
+	}{
+		{
+			test:        "exits on interrupt",
+			script:      "trap 'exit 1' INT; while true; do sleep 0.01; done",
+			gracePeriod: time.Minute,
+		},
+		{
+			test:        "killed after grace period",
+			script:      "trap '' INT; while true; do sleep 0.01; done",
+			gracePeriod: 100 * time.Millisecond,
+		},
+	}
+
+	for _, tc := range testCases {
+		ex := &executor{
+			binaryPath:  "/bin/sh",
+			stdout:      ioutil.Discard,
+			stderr:      ioutil.Discard,
+			gracePeriod: tc.gracePeriod,
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+		start := time.Now()
+		err := ex.execute(ctx, dir, "-c", tc.script)
+		cancel()
+		if err == nil {
+			t.Errorf("Test case %s: expected an error", tc.test)
+		}
+		if elapsed := time.Since(start); elapsed > 10*time.Second {
+			t.Errorf("Test case %s: expected execute to return promptly, took %s", tc.test, elapsed)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build !windows
+// +build !windows
+
+package workflow
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// setProcessGroup starts the command in a new process group, detached from
+// the signals sent to the terminal's foreground process group.
+func setProcessGroup(cmd *exec.Cmd) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+}
+
+// interrupt asks the process to stop gracefully.
+func interrupt(p *os.Process) error {
+	return p.Signal(os.Interrupt)
+}
@@ -0,0 +1,15 @@
+package workflow
+
+import (
+	"os"
+	"os/exec"
+)
+
+// setProcessGroup is a no-op on Windows.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// interrupt stops the process. Sending an interrupt to another process is
+// not supported on Windows, so it is killed.
+func interrupt(p *os.Process) error {
+	return p.Kill()
+}
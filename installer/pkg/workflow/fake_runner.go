@@ -0,0 +1,218 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// RunnerCall is a Terraform command recorded by a FakeRunner.
+type RunnerCall struct {
+	// Command is one of init, apply, destroy, plan, apply-plan and output.
+	Command string
+	// State is the state the command ran against.
+	State string
+	// Args are the extra arguments of apply, destroy and plan, the templates
+	// directory of init, the plan file of apply-plan and the output name.
+	Args []string
+}
+
+// String returns the command and state of the call, e.g. "apply topology".
+func (c RunnerCall) String() string {
+	if c.State == "" {
+		return c.Command
+	}
+	return fmt.Sprintf("%s %s", c.Command, c.State)
+}
+
+// fakeFailure is an error a FakeRunner returns, a number of times.
+type fakeFailure struct {
+	err   error
+	times int
+}
+
+// FakeRunner is an in-memory Runner for tests, which never runs Terraform.
+// It records the commands it is asked to run and creates and removes state
+// files in the cluster directory like Terraform would, so that workflows can
+// check for them. It can be scripted to fail given commands.
+type FakeRunner struct {
+	mu       sync.Mutex
+	calls    []RunnerCall
+	failures map[string]*fakeFailure
+	hooks    map[string]func(clusterDir string) error
+	outputs  map[string]map[string]interface{}
+}
+
+// NewFakeRunner returns a FakeRunner that succeeds at everything.
+func NewFakeRunner() *FakeRunner {
+	return &FakeRunner{
+		failures: make(map[string]*fakeFailure),
+		hooks:    make(map[string]func(string) error),
+		outputs:  make(map[string]map[string]interface{}),
+	}
+}
+
+// FailOn makes the given command fail with err whenever it runs against the
+// given state. An empty state matches every state.
+func (f *FakeRunner) FailOn(command, state string, err error) {
+	f.FailTimes(command, state, -1, err)
+}
+
+// FailTimes makes the given command fail with err the next n times it runs
+// against the given state, then succeed. A negative n fails it forever.
+func (f *FakeRunner) FailTimes(command, state string, n int, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.failures[RunnerCall{Command: command, State: state}.String()] = &fakeFailure{err: err, times: n}
+}
+
+// OnApply registers a function called once the given state is applied, e.g.
+// to write the files the real templates would generate.
+func (f *FakeRunner) OnApply(state string, hook func(clusterDir string) error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.hooks[state] = hook
+}
+
+// SetOutput sets the value of an output of the given state.
+func (f *FakeRunner) SetOutput(state, name string, value interface{}) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.outputs[state] == nil {
+		f.outputs[state] = make(map[string]interface{})
+	}
+	f.outputs[state][name] = value
+}
+
+// Calls returns the commands run so far, in order.
+func (f *FakeRunner) Calls() []RunnerCall {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]RunnerCall(nil), f.calls...)
+}
+
+// record records a call and returns the error it was scripted to fail with.
+func (f *FakeRunner) record(command, state string, args ...string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.calls = append(f.calls, RunnerCall{Command: command, State: state, Args: args})
+
+	for _, key := range []string{RunnerCall{Command: command, State: state}.String(), command} {
+		failure, ok := f.failures[key]
+		if !ok || failure.times == 0 {
+			continue
+		}
+		if failure.times > 0 {
+			failure.times--
+		}
+		return failure.err
+	}
+	return nil
+}
+
+// Init implements Runner.
+func (f *FakeRunner) Init(ctx context.Context, clusterDir, state, templateDir string) error {
+	return f.record("init", state, templateDir)
+}
+
+// Apply implements Runner.
+func (f *FakeRunner) Apply(ctx context.Context, clusterDir, state, templateDir string, extraArgs ...string) error {
+	if err := f.record("apply", state, extraArgs...); err != nil {
+		return err
+	}
+	return f.applied(clusterDir, state)
+}
+
+// Destroy implements Runner.
+func (f *FakeRunner) Destroy(ctx context.Context, clusterDir, state, templateDir string, extraArgs ...string) error {
+	if err := f.record("destroy", state, extraArgs...); err != nil {
+		return err
+	}
+	return removeFakeState(clusterDir, state)
+}
+
+// Plan implements Runner.
+func (f *FakeRunner) Plan(ctx context.Context, clusterDir, state, templateDir, planFile string, destroy bool, extraArgs ...string) (string, error) {
+	if err := f.record("plan", state, extraArgs...); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Dir(filepath.Join(clusterDir, planFile)), 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(clusterDir, planFile), nil, 0644); err != nil {
+		return "", err
+	}
+	if destroy {
+		return "Plan: 0 to add, 0 to change, 1 to destroy.\n", nil
+	}
+	return "Plan: 1 to add, 0 to change, 0 to destroy.\n", nil
+}
+
+// ApplyPlan implements Runner.
+func (f *FakeRunner) ApplyPlan(ctx context.Context, clusterDir, state, planFile string) error {
+	if err := f.record("apply-plan", state, planFile); err != nil {
+		return err
+	}
+	path := filepath.Join(clusterDir, planFile)
+	if _, err := os.Stat(path); err != nil {
+		return fmt.Errorf("no saved plan for step %s: %v", state, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return err
+	}
+	if strings.HasSuffix(planFile, ".destroy.tfplan") {
+		return removeFakeState(clusterDir, state)
+	}
+	return f.applied(clusterDir, state)
+}
+
+// Output implements Runner.
+func (f *FakeRunner) Output(ctx context.Context, clusterDir, state, name string) (string, error) {
+	if err := f.record("output", state, name); err != nil {
+		return "", err
+	}
+	f.mu.Lock()
+	outputs := f.outputs[state]
+	f.mu.Unlock()
+
+	var value interface{} = outputs
+	if name != "" {
+		v, ok := outputs[name]
+		if !ok {
+			return "", fmt.Errorf("output %q not found in state %s", name, state)
+		}
+		value = v
+	}
+	content, err := json.Marshal(value)
+	return string(content), err
+}
+
+// applied writes the state file of the given state and runs its hook.
+func (f *FakeRunner) applied(clusterDir, state string) error {
+	path := filepath.Join(clusterDir, state+".tfstate")
+	content := fmt.Sprintf(`{"version": 3, "modules": [{"path": ["root"], "resources": {"null_resource.%s": {"type": "null_resource", "primary": {"id": "1"}}}}]}`, state)
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	hook := f.hooks[state]
+	f.mu.Unlock()
+	if hook != nil {
+		return hook(clusterDir)
+	}
+	return nil
+}
+
+// removeFakeState removes the state file of the given state.
+func removeFakeState(clusterDir, state string) error {
+	err := os.Remove(filepath.Join(clusterDir, state+".tfstate"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
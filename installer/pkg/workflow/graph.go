@@ -0,0 +1,179 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// defaultParallelism is the number of steps a workflow runs at the same time,
+// unless configured otherwise.
+const defaultParallelism = 4
+
+// StepError is the error of a single failed step.
+type StepError struct {
+	Step string
+	Err  error
+}
+
+// ExecutionError holds the errors of all the steps that failed during a
+// workflow execution.
+type ExecutionError struct {
+	Errors []StepError
+}
+
+// ExecutionError implements the error interface.
+func (e *ExecutionError) Error() string {
+	if len(e.Errors) == 1 {
+		return fmt.Sprintf("step %s failed: %v", e.Errors[0].Step, e.Errors[0].Err)
+	}
+	msgs := make([]string, 0, len(e.Errors))
+	for _, err := range e.Errors {
+		msgs = append(msgs, fmt.Sprintf("step %s failed: %v", err.Step, err.Err))
+	}
+	return fmt.Sprintf("%d steps failed: %s", len(e.Errors), strings.Join(msgs, "; "))
+}
+
+// validateGraph ensures that every step only depends on steps declared
+// before it. The declaration order is therefore a valid execution order and
+// the graph has no cycles.
+func validateGraph(steps []stepNode) error {
+	declared := make(map[string]bool)
+	all := make(map[string]bool)
+	for _, step := range steps {
+		if all[step.Name()] {
+			return fmt.Errorf("step %q is declared more than once", step.Name())
+		}
+		all[step.Name()] = true
+	}
+	for _, step := range steps {
+		for _, dep := range step.deps {
+			if all[dep] && !declared[dep] {
+				return fmt.Errorf("step %q depends on step %q, which is declared after it", step.Name(), dep)
+			}
+		}
+		declared[step.Name()] = true
+	}
+	return nil
+}
+
+type stepResult struct {
+	step string
+	err  error
+}
+
+// run executes the given steps as a dependency graph. If outcomes is set, the
+// outcome of each step is recorded in it, and a failed step does not stop the
+// others: the steps depending on it still run once it finished.
+func (w *Workflow) run(ctx context.Context, steps []stepNode, outcomes map[string]*stepOutcome) error {
+	if err := validateGraph(steps); err != nil {
+		return err
+	}
+
+	parallelism := w.parallelism
+	if parallelism < 1 {
+		parallelism = defaultParallelism
+	}
+	w.state.lock = &sync.Mutex{}
+
+	inWorkflow := make(map[string]bool)
+	for _, step := range steps {
+		inWorkflow[step.Name()] = true
+	}
+	ready := func(step stepNode, done map[string]bool) bool {
+		for _, dep := range step.deps {
+			if inWorkflow[dep] && !done[dep] {
+				return false
+			}
+		}
+		return true
+	}
+	outcome := func(step string) *stepOutcome {
+		if outcomes[step] == nil {
+			outcomes[step] = &stepOutcome{}
+		}
+		return outcomes[step]
+	}
+	continueOnError := outcomes != nil
+	// The outcomes of a previous run of the steps no longer hold.
+	for _, step := range steps {
+		if o := outcomes[step.Name()]; o != nil {
+			o.status = ""
+		}
+	}
+
+	// The steps still running are interrupted as soon as one fails.
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	done := make(map[string]bool)
+	started := make(map[string]bool)
+	results := make(chan stepResult)
+	var running int
+	var errs []StepError
+
+	for {
+		// Start every step whose dependencies completed, in declaration
+		// order, unless a step failed already.
+		for _, step := range steps {
+			if (len(errs) != 0 && !continueOnError) || ctx.Err() != nil || running >= parallelism {
+				break
+			}
+			if started[step.Name()] || !ready(step, done) {
+				continue
+			}
+			started[step.Name()] = true
+			running++
+			if continueOnError {
+				outcome(step.Name()).attempts++
+			}
+			log.Debugf("Starting step %s", step.Name())
+			go func(step stepNode) {
+				results <- stepResult{step: step.Name(), err: w.runStep(ctx, step)}
+			}(step)
+		}
+
+		if running == 0 {
+			break
+		}
+
+		res := <-results
+		running--
+		if res.err != nil {
+			log.Errorf("Step %s failed: %v", res.step, res.err)
+			errs = append(errs, StepError{Step: res.step, Err: res.err})
+			if continueOnError {
+				// The steps depending on the failed one are tried
+				// anyway, e.g. a destroy step whose dependency left a
+				// resource behind may still remove all of its own.
+				done[res.step] = true
+				o := outcome(res.step)
+				o.status, o.err = stepFailed, res.err
+				continue
+			}
+			cancel()
+			continue
+		}
+		log.Debugf("Step %s completed", res.step)
+		done[res.step] = true
+		if continueOnError {
+			o := outcome(res.step)
+			o.status, o.err = stepSucceeded, nil
+			if w.state.wasSkipped(res.step) {
+				o.status = stepSkipped
+			}
+		}
+	}
+
+	if len(errs) != 0 {
+		return &ExecutionError{Errors: errs}
+	}
+	// An interruption may land between two steps, without failing any.
+	if err := ctx.Err(); err != nil && len(done) != len(steps) {
+		return &ExecutionError{Errors: []StepError{{Step: "workflow", Err: err}}}
+	}
+	return nil
+}
@@ -0,0 +1,131 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+)
+
+// recorder records the order in which steps start and the maximum number of
+// steps running at the same time.
+type recorder struct {
+	sync.Mutex
+	started    []string
+	running    int
+	maxRunning int
+}
+
+func (r *recorder) step(name string, err error) StepFunc {
+	return func(context.Context, *State) error {
+		r.Lock()
+		r.started = append(r.started, name)
+		r.running++
+		if r.running > r.maxRunning {
+			r.maxRunning = r.running
+		}
+		r.Unlock()
+
+		time.Sleep(10 * time.Millisecond)
+
+		r.Lock()
+		r.running--
+		r.Unlock()
+		return err
+	}
+}
+
+func TestWorkflowExecuteGraph(t *testing.T) {
+	r := &recorder{}
+	wf := Workflow{
+		steps: []stepNode{
+			newStep("a", r.step("a", nil)),
+			newStep("b", r.step("b", nil), "a"),
+			newStep("c", r.step("c", nil), "a"),
+			newStep("d", r.step("d", nil), "b", "c"),
+		},
+		parallelism: 2,
+	}
+	if err := wf.Execute(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if len(r.started) != 4 || r.started[0] != "a" || r.started[3] != "d" {
+		t.Errorf("expected a first and d last, got %v", r.started)
+	}
+	if r.maxRunning != 2 {
+		t.Errorf("expected b and c to run concurrently, got at most %d steps at once", r.maxRunning)
+	}
+}
+
+func TestWorkflowExecuteParallelismLimit(t *testing.T) {
+	r := &recorder{}
+	wf := Workflow{
+		steps: []stepNode{
+			newStep("a", r.step("a", nil)),
+			newStep("b", r.step("b", nil)),
+			newStep("c", r.step("c", nil)),
+		},
+		parallelism: 1,
+	}
+	if err := wf.Execute(context.Background()); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if r.maxRunning != 1 {
+		t.Errorf("expected steps to run one at a time, got %d at once", r.maxRunning)
+	}
+}
+
+func TestWorkflowExecuteFailure(t *testing.T) {
+	r := &recorder{}
+	wf := Workflow{
+		steps: []stepNode{
+			newStep("a", r.step("a", errors.New("a failed"))),
+			newStep("b", r.step("b", errors.New("b failed"))),
+			newStep("c", r.step("c", nil), "a"),
+		},
+		parallelism: 2,
+	}
+	err := wf.Execute(context.Background())
+	execErr, ok := err.(*ExecutionError)
+	if !ok {
+		t.Fatalf("expected an ExecutionError, got %v", err)
+	}
+	if len(execErr.Errors) != 2 {
+		t.Errorf("expected the errors of a and b, got %v", execErr.Errors)
+	}
+	for _, name := range r.started {
+		if name == "c" {
+			t.Errorf("expected c not to start after a failed")
+		}
+	}
+}
+
+func TestValidateGraph(t *testing.T) {
+	testCases := []struct {
+		test          string
+		steps         []stepNode
+		expectedError bool
+	}{
+		{
+			test:  "valid graph",
+			steps: []stepNode{newStep("a", test1Step), newStep("b", test1Step, "a", "external")},
+		},
+		{
+			test:          "dependency declared later",
+			steps:         []stepNode{newStep("a", test1Step, "b"), newStep("b", test1Step)},
+			expectedError: true,
+		},
+		{
+			test:          "duplicate step",
+			steps:         []stepNode{newStep("a", test1Step), newStep("a", test1Step)},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		if err := validateGraph(tc.steps); (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package workflow
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"os"
@@ -28,10 +29,10 @@ const (
 // responsible for initializing a new cluster.
 func InitWorkflow(configFilePath string) Workflow {
 	return Workflow{
-		metadata: metadata{configFilePath: configFilePath},
-		steps: []Step{
-			prepareWorspaceStep,
-			refreshConfigStep,
+		state: State{configFilePath: configFilePath},
+		steps: []stepNode{
+			newSetupStep("prepare-workspace", prepareWorspaceStep),
+			{Step: RefreshConfig, setup: true},
 		},
 	}
 }
@@ -60,7 +61,7 @@ func buildInternalConfig(clusterDir string) error {
 	return writeFile(filepath.Join(clusterDir, internalFileName), string(internalFileContent))
 }
 
-func generateTerraformVariablesStep(m *metadata) error {
+func generateTerraformVariablesStep(m *State) error {
 	vars, err := m.cluster.TFVars()
 	if err != nil {
 		return err
@@ -70,7 +71,7 @@ func generateTerraformVariablesStep(m *metadata) error {
 	return writeFile(terraformVariablesFilePath, vars)
 }
 
-func prepareWorspaceStep(m *metadata) error {
+func prepareWorspaceStep(ctx context.Context, m *State) error {
 	dir, err := os.Getwd()
 	if err != nil {
 		return fmt.Errorf("failed to get current directory: %v", err)
@@ -81,7 +82,7 @@ func prepareWorspaceStep(m *metadata) error {
 	}
 
 	// load initial cluster config to get cluster.Name
-	cluster, err := readClusterConfig(m.configFilePath, "")
+	cluster, err := readClusterConfig(m.configFilePath, "", m.lenient)
 	if err != nil {
 		return fmt.Errorf("failed to get configuration from file %q: %v", m.configFilePath, err)
 	}
@@ -73,7 +73,7 @@ func initTestCluster(cfg, pullSecret, license string) (*config.Cluster, error) {
 	}
 	testConfig.PullSecretPath = pullSecret
 	testConfig.LicensePath = license
-	if len(testConfig.Validate()) != 0 {
+	if len(testConfig.Validate().Errors()) != 0 {
 		return nil, errors.New("failed to validate test conifg")
 	}
 	return testConfig, nil
@@ -107,7 +107,7 @@ func TestGenerateTerraformVariablesStep(t *testing.T) {
 	cluster.LicensePath = ""
 	cluster.PullSecretPath = ""
 
-	m := &metadata{
+	m := &State{
 		cluster:    *cluster,
 		clusterDir: clusterDir,
 	}
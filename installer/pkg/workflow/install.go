@@ -1,171 +1,253 @@
 package workflow
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 
+	log "github.com/Sirupsen/logrus"
+
 	"github.com/coreos/tectonic-installer/installer/pkg/config-generator"
 )
 
+// The built-in steps of the install workflows.
+var (
+	RefreshConfig      = NewStep("refresh-config", refreshConfigStep)
+	InstallConfigMaps  = NewStep("config-maps", generateClusterConfigMaps)
+	InstallTLS         = newTerraformStep("tls", installTLSAssetsStep)
+	InstallNewTLS      = NewStep("newtls", generateTLSConfigStep)
+	InstallAssets      = newTerraformStep("assets", installAssetsStep)
+	InstallIgnition    = NewStep("ignition", generateIgnConfigStep)
+	InstallTopology    = newTerraformStep("topology", installTopologyStep)
+	InstallTNCCNAME    = newTerraformStep("tnc-cname", installTNCCNAMEStep)
+	InstallBootstrap   = newTerraformStep("bootstrap", installBootstrapStep)
+	InstallTNCARecord  = newTerraformStep("tnc-a-record", installTNCARecordStep)
+	InstallEtcd        = newTerraformStep("etcd", installEtcdStep)
+	InstallJoinMasters = newTerraformStep("join-masters", installJoinMastersStep)
+	InstallJoinWorkers = newTerraformStep("join-workers", installJoinWorkersStep)
+)
+
+// installDeps are the dependencies of the install steps, by name.
+// Steps that only read other steps' state or generated files depend on them;
+// independent branches of the graph run concurrently.
+var installDeps = map[string][]string{
+	"assets":       {"config-maps", "tls"},
+	"ignition":     {"tls"},
+	"topology":     {"assets"},
+	"tnc-cname":    {"topology"},
+	"bootstrap":    {"ignition", "topology", "tnc-cname"},
+	"tnc-a-record": {"bootstrap"},
+	"etcd":         {"tnc-a-record"},
+	"join-masters": {"etcd"},
+	"join-workers": {"etcd"},
+}
+
+// installWorkflow returns a workflow refreshing the cluster config, then
+// running the given install steps.
+func installWorkflow(clusterDir string, steps ...Step) Workflow {
+	b := NewBuilder(clusterDir).Setup(RefreshConfig)
+	for _, step := range steps {
+		b.Add(step, installDeps[step.Name()]...)
+	}
+	return b.workflow
+}
+
 // InstallFullWorkflow creates new instances of the 'install' workflow,
 // responsible for running the actions necessary to install a new cluster.
 func InstallFullWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			generateClusterConfigMaps,
-			readClusterConfigStep,
-			installTLSAssetsStep,
-			generateClusterConfigMaps,
-			installAssetsStep,
-			generateIgnConfigStep,
-			installTopologyStep,
-			installTNCCNAMEStep,
-			installBootstrapStep,
-			installTNCARecordStep,
-			installEtcdStep,
-			installJoinMastersStep,
-			installJoinWorkersStep,
-		},
-	}
+	return installWorkflow(clusterDir,
+		InstallConfigMaps,
+		InstallTLS,
+		InstallAssets,
+		InstallIgnition,
+		InstallTopology,
+		InstallTNCCNAME,
+		InstallBootstrap,
+		InstallTNCARecord,
+		InstallEtcd,
+		InstallJoinMasters,
+		InstallJoinWorkers,
+	)
 }
 
 // InstallTLSNewWorkflow generates the TLS certificates using go, instead of TF
 func InstallTLSNewWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			generateClusterConfigMaps,
-			generateTLSConfigStep,
-		},
-	}
+	return installWorkflow(clusterDir,
+		InstallConfigMaps,
+		InstallNewTLS,
+	)
 }
 
 // InstallTLSWorkflow creates the TLS assets, previously created by the
 // "assets" step
 func InstallTLSWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			installTLSAssetsStep,
-		},
-	}
+	return installWorkflow(clusterDir,
+		InstallTLS,
+	)
 }
 
 // InstallAssetsWorkflow creates new instances of the 'assets' workflow,
 // responsible for running the actions necessary to generate cluster assets.
 func InstallAssetsWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			generateClusterConfigMaps,
-			installAssetsStep,
-			generateIgnConfigStep,
-		},
-	}
+	return installWorkflow(clusterDir,
+		InstallConfigMaps,
+		InstallAssets,
+		InstallIgnition,
+	)
 }
 
 // InstallBootstrapWorkflow creates new instances of the 'bootstrap' workflow,
 // responsible for running the actions necessary to generate a single bootstrap machine cluster.
 func InstallBootstrapWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			installTopologyStep,
-			installTNCCNAMEStep,
-			installBootstrapStep,
-			installTNCARecordStep,
-			installEtcdStep,
-		},
-	}
+	return installWorkflow(clusterDir,
+		InstallTopology,
+		InstallTNCCNAME,
+		InstallBootstrap,
+		InstallTNCARecord,
+		InstallEtcd,
+	)
 }
 
 // InstallJoinWorkflow creates new instances of the 'join' workflow,
 // responsible for running the actions necessary to scale the machines of the cluster.
 func InstallJoinWorkflow(clusterDir string) Workflow {
-	return Workflow{
-		metadata: metadata{clusterDir: clusterDir},
-		steps: []Step{
-			refreshConfigStep,
-			installJoinMastersStep,
-			installJoinWorkersStep,
-		},
-	}
+	return installWorkflow(clusterDir,
+		InstallJoinMasters,
+		InstallJoinWorkers,
+	)
 }
 
-func refreshConfigStep(m *metadata) error {
+func refreshConfigStep(ctx context.Context, m *State) error {
 	if err := readClusterConfigStep(m); err != nil {
 		return err
 	}
 	return generateTerraformVariablesStep(m)
 }
 
-func installTLSAssetsStep(m *metadata) error {
-	return runInstallStep(m, tlsStep)
+func installTLSAssetsStep(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, tlsStep)
 
 }
 
-func installAssetsStep(m *metadata) error {
-	return runInstallStep(m, assetsStep)
+func installAssetsStep(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, assetsStep)
 }
 
-func installTopologyStep(m *metadata) error {
-	return runInstallStep(m, topologyStep)
+func installTopologyStep(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, topologyStep)
 }
 
-func installBootstrapStep(m *metadata) error {
-	if !clusterIsBootstrapped(m.clusterDir) {
-		return runInstallStep(m, mastersStep, []string{bootstrapOn}...)
+func installBootstrapStep(ctx context.Context, m *State) error {
+	bootstrapped, err := clusterIsBootstrapped(ctx, m)
+	if err != nil || bootstrapped {
+		return err
 	}
-	return nil
+	return runInstallStep(ctx, m, mastersStep, []string{bootstrapOn}...)
 }
 
-func installTNCCNAMEStep(m *metadata) error {
-	if !clusterIsBootstrapped(m.clusterDir) {
-		return createTNCCNAME(m)
+func installTNCCNAMEStep(ctx context.Context, m *State) error {
+	bootstrapped, err := clusterIsBootstrapped(ctx, m)
+	if err != nil || bootstrapped {
+		return err
 	}
-	return nil
+	return createTNCCNAME(ctx, m)
 }
 
-func installTNCARecordStep(m *metadata) error {
-	return createTNCARecord(m)
+func installTNCARecordStep(ctx context.Context, m *State) error {
+	return createTNCARecord(ctx, m)
 }
 
-func installEtcdStep(m *metadata) error {
-	return runInstallStep(m, etcdStep)
+func installEtcdStep(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, etcdStep)
 }
 
-func installJoinMastersStep(m *metadata) error {
-	return runInstallStep(m, mastersStep, []string{bootstrapOff}...)
+func installJoinMastersStep(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, mastersStep, []string{bootstrapOff}...)
 }
 
-func installJoinWorkersStep(m *metadata) error {
-	return runInstallStep(m, joinWorkersStep)
+func installJoinWorkersStep(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, joinWorkersStep)
 }
 
-func runInstallStep(m *metadata, step string, extraArgs ...string) error {
-	templateDir, err := findStepTemplates(step, m.cluster.Platform)
+func runInstallStep(ctx context.Context, m *State, step string, extraArgs ...string) error {
+	templateDir, err := m.stepTemplates(step)
 	if err != nil {
 		return err
 	}
-	if err := tfInit(m.clusterDir, templateDir); err != nil {
+	configDir, err := m.stepConfigDir(step, templateDir)
+	if err != nil {
 		return err
 	}
-	return tfApply(m.clusterDir, step, templateDir, extraArgs...)
+
+	if m.plan {
+		if err := m.terraform().Init(ctx, m.clusterDir, step, configDir); err != nil {
+			return err
+		}
+		return runPlanStep(ctx, m, step, configDir, false, extraArgs...)
+	}
+
+	key := checkpointKey(step, extraArgs...)
+	inputHash, err := m.stepInputHash(ctx, step, templateDir, extraArgs...)
+	if err != nil {
+		return err
+	}
+	existed, err := m.hasState(ctx, step)
+	if err != nil {
+		return err
+	}
+	done, err := m.startStep(ctx, key, inputHash, existed)
+	if err != nil {
+		return err
+	}
+	if done {
+		log.Infof("Skipping step %q: already completed with the same inputs", key)
+		return nil
+	}
+
+	err = m.retries().run(ctx, key, func() error {
+		if err := m.terraform().Init(ctx, m.clusterDir, step, configDir); err != nil {
+			return err
+		}
+		if m.savedPlans {
+			return m.applySavedPlan(ctx, step, false, extraArgs...)
+		}
+		return m.terraform().Apply(ctx, m.clusterDir, step, configDir, extraArgs...)
+	})
+	// A failed apply may still have created resources.
+	if !existed {
+		created, hasErr := m.hasState(ctx, step)
+		if hasErr != nil {
+			log.Errorf("Failed to look up state %s: %v", step, hasErr)
+		}
+		if created {
+			m.recordCreated(step)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if err := m.withLock(func() error {
+		m.applied = true
+		return m.saveAppliedConfigOnce()
+	}); err != nil {
+		return err
+	}
+	return m.completeStep(key, inputHash)
 }
 
-func generateIgnConfigStep(m *metadata) error {
+func generateIgnConfigStep(ctx context.Context, m *State) error {
+	if m.skipInPlanMode("the ignition configs") {
+		return nil
+	}
 	c := configgenerator.New(m.cluster)
 	return c.GenerateIgnConfig(m.clusterDir)
 }
 
-func generateTLSConfigStep(m *metadata) error {
+func generateTLSConfigStep(ctx context.Context, m *State) error {
+	if m.skipInPlanMode("the TLS assets") {
+		return nil
+	}
 	if err := os.MkdirAll(filepath.Join(m.clusterDir, newTLSPath), os.ModeDir|0755); err != nil {
 		return fmt.Errorf("failed to create TLS directory at %s", newTLSPath)
 	}
@@ -0,0 +1,210 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strings"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// lockFileName is the name of the file that prevents concurrent workflow
+// runs in the same cluster directory.
+const lockFileName = "tectonic.lock"
+
+// lockInfo describes the owner of a cluster directory lock.
+type lockInfo struct {
+	PID     int       `json:"pid"`
+	Host    string    `json:"host"`
+	User    string    `json:"user"`
+	Command string    `json:"command"`
+	Started time.Time `json:"started"`
+}
+
+// String returns a human-readable description of the lock owner.
+func (l lockInfo) String() string {
+	return fmt.Sprintf("%q run by %s on %s (pid %d) since %s", l.Command, l.User, l.Host, l.PID, l.Started.Format(time.RFC3339))
+}
+
+// equal reports whether both describe the same lock owner.
+func (l lockInfo) equal(o lockInfo) bool {
+	return l.PID == o.PID && l.Host == o.Host && l.Started.Equal(o.Started)
+}
+
+// stale reports whether the lock owner is known to be gone, i.e. it ran on
+// this host and its process no longer exists. Locks taken on other hosts are
+// never considered stale.
+func (l lockInfo) stale() bool {
+	host, err := os.Hostname()
+	if err != nil || host != l.Host {
+		return false
+	}
+	return !processAlive(l.PID)
+}
+
+// LockedError is returned when a workflow cannot run because another one
+// holds the lock of the cluster directory.
+type LockedError struct {
+	Path  string
+	Owner lockInfo
+}
+
+// LockedError implements the error interface.
+func (e *LockedError) Error() string {
+	return fmt.Sprintf("cluster directory is locked by %s; if that run is gone, remove the lock with 'tectonic unlock --force' (lock file: %s)", e.Owner, e.Path)
+}
+
+// clusterLock is an exclusive lock on a cluster directory.
+type clusterLock struct {
+	path string
+	info lockInfo
+}
+
+// currentLockInfo describes the current process as a lock owner.
+func currentLockInfo() lockInfo {
+	host, _ := os.Hostname()
+	name := os.Getenv("USER")
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+	return lockInfo{
+		PID:     os.Getpid(),
+		Host:    host,
+		User:    name,
+		Command: strings.Join(os.Args, " "),
+		Started: time.Now().UTC(),
+	}
+}
+
+// acquireLock takes the lock of the given cluster directory. A stale lock is
+// replaced; a lock held by a live process makes it fail with a LockedError.
+func acquireLock(clusterDir string) (*clusterLock, error) {
+	l := &clusterLock{
+		path: filepath.Join(clusterDir, lockFileName),
+		info: currentLockInfo(),
+	}
+	content, err := json.MarshalIndent(l.info, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	for {
+		f, err := os.OpenFile(l.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			_, err = f.Write(append(content, '\n'))
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				os.Remove(l.path)
+				return nil, fmt.Errorf("failed to write lock file %s: %v", l.path, err)
+			}
+			return l, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to create lock file %s: %v", l.path, err)
+		}
+
+		owner, err := readLock(l.path)
+		if err != nil {
+			return nil, err
+		}
+		if !owner.stale() {
+			return nil, &LockedError{Path: l.path, Owner: *owner}
+		}
+		log.Warnf("Removing stale lock of %s", owner)
+		if err := removeStaleLock(l.path, *owner); err != nil {
+			return nil, fmt.Errorf("failed to remove stale lock file %s: %v", l.path, err)
+		}
+	}
+}
+
+// removeStaleLock removes the lock file at the given path if it is still held
+// by the given stale owner. The file is first moved aside, atomically, so that
+// of the processes seeing the same stale owner, only one removes its lock; a
+// lock another process took in the meantime is put back.
+func removeStaleLock(path string, stale lockInfo) error {
+	aside := fmt.Sprintf("%s.%d", path, os.Getpid())
+	if err := os.Rename(path, aside); err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	owner, err := readLock(aside)
+	if err != nil {
+		// Leave the unreadable lock for 'tectonic unlock --force'.
+		os.Link(aside, path)
+		os.Remove(aside)
+		return err
+	}
+	if !owner.equal(stale) {
+		if err := os.Link(aside, path); err != nil {
+			return fmt.Errorf("lost the lock of %s, taken while removing a stale one: %v", owner, err)
+		}
+	}
+	return os.Remove(aside)
+}
+
+// release removes the lock file, unless it was taken over in the meantime.
+func (l *clusterLock) release() error {
+	owner, err := readLock(l.path)
+	if os.IsNotExist(err) {
+		log.Warnf("Lock file %s was removed while the workflow was running", l.path)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !owner.equal(l.info) {
+		log.Warnf("Lock file %s was taken over by %s while the workflow was running", l.path, owner)
+		return nil
+	}
+	return os.Remove(l.path)
+}
+
+// readLock reads the lock file at the given path. Errors from reading the
+// file are returned as is, so that callers can check for its absence.
+func readLock(path string) (*lockInfo, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var info lockInfo
+	if err := json.Unmarshal(content, &info); err != nil {
+		return nil, fmt.Errorf("invalid lock file %s, remove it with 'tectonic unlock --force': %v", path, err)
+	}
+	return &info, nil
+}
+
+// Unlock removes the lock of the given cluster directory. Unless forced, only
+// a stale lock is removed.
+func Unlock(clusterDir string, force bool) error {
+	path := filepath.Join(clusterDir, lockFileName)
+	owner, err := readLock(path)
+	if os.IsNotExist(err) {
+		log.Infof("Cluster directory %s is not locked", clusterDir)
+		return nil
+	}
+	if err != nil && !force {
+		return err
+	}
+	if err == nil && !force && !owner.stale() {
+		return &LockedError{Path: path, Owner: *owner}
+	}
+
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove lock file %s: %v", path, err)
+	}
+	if owner != nil {
+		log.Infof("Removed lock of %s", owner)
+	} else {
+		log.Infof("Removed lock file %s", path)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeLock(t *testing.T, clusterDir string, info lockInfo) {
+	content, err := json.Marshal(info)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(clusterDir, lockFileName), content, 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAcquireLock(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	// A PID that cannot belong to a running process.
+	deadPID := 1 << 30
+
+	testCases := []struct {
+		test          string
+		existing      *lockInfo
+		expectedError bool
+	}{
+		{
+			test: "unlocked",
+		},
+		{
+			test:          "held by a live process",
+			existing:      &lockInfo{PID: os.Getpid(), Host: host},
+			expectedError: true,
+		},
+		{
+			test:     "stale",
+			existing: &lockInfo{PID: deadPID, Host: host},
+		},
+		{
+			test:          "held on another host",
+			existing:      &lockInfo{PID: deadPID, Host: host + "-other"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		clusterDir, err := ioutil.TempDir("", "workflow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(clusterDir)
+		if tc.existing != nil {
+			writeLock(t, clusterDir, *tc.existing)
+		}
+
+		l, err := acquireLock(clusterDir)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %t, got: %v", tc.test, tc.expectedError, err)
+		}
+		if err != nil {
+			if _, ok := err.(*LockedError); !ok {
+				t.Errorf("Test case %s: expected a LockedError, got: %v", tc.test, err)
+			}
+			continue
+		}
+
+		owner, err := readLock(l.path)
+		if err != nil {
+			t.Fatalf("Test case %s: failed to read lock: %v", tc.test, err)
+		}
+		if owner.PID != os.Getpid() {
+			t.Errorf("Test case %s: expected the lock to be owned by pid %d, got %d", tc.test, os.Getpid(), owner.PID)
+		}
+		if err := l.release(); err != nil {
+			t.Errorf("Test case %s: failed to release lock: %v", tc.test, err)
+		}
+		if _, err := os.Stat(l.path); !os.IsNotExist(err) {
+			t.Errorf("Test case %s: expected the lock file to be removed", tc.test)
+		}
+	}
+}
+
+func TestRemoveStaleLock(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale := lockInfo{PID: 1 << 30, Host: host, Started: time.Now().UTC()}
+
+	testCases := []struct {
+		test     string
+		owner    lockInfo
+		expected bool
+	}{
+		{
+			test:  "still stale",
+			owner: stale,
+		},
+		{
+			test:     "taken over by another process",
+			owner:    lockInfo{PID: os.Getpid(), Host: host, Started: time.Now().UTC()},
+			expected: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		clusterDir, err := ioutil.TempDir("", "workflow")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.RemoveAll(clusterDir)
+		writeLock(t, clusterDir, tc.owner)
+
+		path := filepath.Join(clusterDir, lockFileName)
+		if err := removeStaleLock(path, stale); err != nil {
+			t.Errorf("Test case %s: unexpected error: %v", tc.test, err)
+			continue
+		}
+		owner, err := readLock(path)
+		if (err == nil) != tc.expected {
+			t.Errorf("Test case %s: expected the lock to be kept: %t, got: %v", tc.test, tc.expected, err)
+			continue
+		}
+		if err == nil && !owner.equal(tc.owner) {
+			t.Errorf("Test case %s: expected the lock of %s, got %s", tc.test, tc.owner, owner)
+		}
+		if files, _ := filepath.Glob(path + ".*"); len(files) != 0 {
+			t.Errorf("Test case %s: expected no lock file left aside, got %v", tc.test, files)
+		}
+	}
+}
+
+func TestWorkflowLocked(t *testing.T) {
+	clusterDir, err := ioutil.TempDir("", "workflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clusterDir)
+
+	started := make(chan struct{})
+	proceed := make(chan struct{})
+	block := func(ctx context.Context, m *State) error {
+		close(started)
+		<-proceed
+		return nil
+	}
+	first := Workflow{
+		state: State{clusterDir: clusterDir},
+		steps: []stepNode{newStep("block", block)},
+	}
+	done := make(chan error)
+	go func() {
+		done <- first.Execute(context.Background())
+	}()
+	<-started
+
+	second := Workflow{
+		state: State{clusterDir: clusterDir},
+		steps: []stepNode{newStep("test1", test1Step)},
+	}
+	if _, ok := second.Execute(context.Background()).(*LockedError); !ok {
+		t.Error("expected the second workflow to fail with a LockedError")
+	}
+
+	close(proceed)
+	if err := <-done; err != nil {
+		t.Errorf("expected the first workflow to succeed, got: %v", err)
+	}
+	if err := second.Execute(context.Background()); err != nil {
+		t.Errorf("expected the second workflow to succeed once unlocked, got: %v", err)
+	}
+}
+
+func TestUnlock(t *testing.T) {
+	host, err := os.Hostname()
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterDir, err := ioutil.TempDir("", "workflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clusterDir)
+
+	writeLock(t, clusterDir, lockInfo{PID: os.Getpid(), Host: host, Started: time.Now()})
+	if err := Unlock(clusterDir, false); err == nil {
+		t.Error("expected a lock held by a live process not to be removed without force")
+	}
+	if err := Unlock(clusterDir, true); err != nil {
+		t.Errorf("expected a forced unlock to succeed, got: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(clusterDir, lockFileName)); !os.IsNotExist(err) {
+		t.Error("expected the lock file to be removed")
+	}
+	if err := Unlock(clusterDir, false); err != nil {
+		t.Errorf("expected unlocking an unlocked directory to succeed, got: %v", err)
+	}
+}
@@ -0,0 +1,14 @@
+package workflow
+
+import "os"
+
+// processAlive reports whether a process with the given PID exists. Finding
+// a process on Windows opens a handle to it, which fails once it exited.
+func processAlive(pid int) bool {
+	p, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+	p.Release()
+	return true
+}
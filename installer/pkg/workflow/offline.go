@@ -0,0 +1,267 @@
+package workflow
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+var (
+	providerBlockRegexp  = regexp.MustCompile(`(?m)^\s*provider\s+"([a-z0-9-]+)"\s*\{`)
+	providerVersionRegex = regexp.MustCompile(`version\s*=\s*"v?(\d+\.\d+\.\d+)"`)
+	resourceTypeRegexp   = regexp.MustCompile(`(?m)^\s*(?:resource|data)\s+"([a-z0-9]+)_[a-z0-9_]+"`)
+	localModuleRegexp    = regexp.MustCompile(`(?m)^\s*source\s*=\s*"(\.\.?/[^"$]+)"`)
+)
+
+// builtinProviders are compiled into Terraform and never need a plugin.
+var builtinProviders = map[string]bool{
+	"terraform": true,
+}
+
+// SetOffline makes the default runner use the Terraform providers found in
+// pluginDir instead of downloading them. If pluginDir is empty, it is taken
+// from the cluster config, which must then set it.
+func (w *Workflow) SetOffline(pluginDir string) {
+	w.state.offline = true
+	w.state.pluginDir = pluginDir
+}
+
+// offlinePluginDir returns the plugin directory of offline mode, enabled
+// either with Workflow.SetOffline or in the cluster config, or an empty
+// string if Terraform may download its providers.
+func (m *State) offlinePluginDir() (string, error) {
+	if !m.offline && !m.cluster.Terraform.Offline {
+		return "", nil
+	}
+	if m.pluginDir != "" {
+		return filepath.Abs(m.pluginDir)
+	}
+	dir := m.cluster.Terraform.PluginDir
+	if dir == "" {
+		// The installer does not ship any provider.
+		return "", errors.New("offline mode requires a directory of Terraform providers; set it with --plugin-dir or terraform.pluginDir in the config")
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(m.clusterDir, dir)
+	}
+	return filepath.Abs(dir)
+}
+
+// providerRequirement is a Terraform provider the step templates use, with
+// the version they pin, if any.
+type providerRequirement struct {
+	name    string
+	version string
+}
+
+// String returns the name of the provider's plugin binary.
+func (p providerRequirement) String() string {
+	if p.version == "" {
+		return "terraform-provider-" + p.name
+	}
+	return fmt.Sprintf("terraform-provider-%s_v%s", p.name, p.version)
+}
+
+// templateProviders returns the providers used by the templates of the given
+// directories, including the local modules they source, sorted by name.
+func templateProviders(dirs ...string) ([]providerRequirement, error) {
+	versions := make(map[string]string)
+	visited := make(map[string]bool)
+	var visit func(dir string) error
+	visit = func(dir string) error {
+		if visited[dir] {
+			return nil
+		}
+		visited[dir] = true
+		files, err := filepath.Glob(filepath.Join(dir, "*.tf"))
+		if err != nil {
+			return err
+		}
+		for _, file := range files {
+			content, err := ioutil.ReadFile(file)
+			if err != nil {
+				return fmt.Errorf("failed to read templates: %v", err)
+			}
+			for _, m := range providerBlockRegexp.FindAllStringSubmatchIndex(string(content), -1) {
+				name := string(content[m[2]:m[3]])
+				version := ""
+				if v := providerVersionRegex.FindStringSubmatch(blockAttributes(string(content[m[1]:]))); v != nil {
+					version = v[1]
+				}
+				if versions[name] == "" {
+					versions[name] = version
+				}
+			}
+			for _, m := range resourceTypeRegexp.FindAllStringSubmatch(string(content), -1) {
+				if _, ok := versions[m[1]]; !ok {
+					versions[m[1]] = ""
+				}
+			}
+			for _, m := range localModuleRegexp.FindAllStringSubmatch(string(content), -1) {
+				if err := visit(filepath.Join(dir, m[1])); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	for _, dir := range dirs {
+		if err := visit(filepath.Clean(dir)); err != nil {
+			return nil, err
+		}
+	}
+
+	var providers []providerRequirement
+	for name, version := range versions {
+		if !builtinProviders[name] {
+			providers = append(providers, providerRequirement{name: name, version: version})
+		}
+	}
+	sort.Slice(providers, func(i, j int) bool { return providers[i].name < providers[j].name })
+	return providers, nil
+}
+
+// blockAttributes returns the body of the block opened right before the given
+// template content, up to its closing brace, leaving out its nested blocks,
+// e.g. the assume_role block of a provider, and its comments. Braces within
+// strings, including those of interpolations, are ignored.
+func blockAttributes(content string) string {
+	var body bytes.Buffer
+	scanBlock(content, &body)
+	return body.String()
+}
+
+// scanBlock returns the length of the body of the block opened right before
+// the given template content, closing brace included, and writes the
+// attributes of the block to body, as blockAttributes describes.
+func scanBlock(content string, body *bytes.Buffer) int {
+	depth := 1
+	for i := 0; i < len(content); i++ {
+		c := content[i]
+		switch {
+		case c == '#' || strings.HasPrefix(content[i:], "//"):
+			end := strings.IndexByte(content[i:], '\n')
+			if end < 0 {
+				return len(content)
+			}
+			i += end - 1
+			continue
+		case strings.HasPrefix(content[i:], "/*"):
+			end := strings.Index(content[i+2:], "*/")
+			if end < 0 {
+				return len(content)
+			}
+			i += end + 3
+			continue
+		case c == '"':
+			end := stringEnd(content[i:])
+			if depth == 1 {
+				body.WriteString(content[i : i+end])
+			}
+			i += end - 1
+			continue
+		case c == '{':
+			depth++
+		case c == '}':
+			depth--
+			if depth == 0 {
+				return i + 1
+			}
+		default:
+			if depth == 1 {
+				body.WriteByte(c)
+			}
+		}
+	}
+	return len(content)
+}
+
+// stringEnd returns the length of the string literal starting the given
+// template content, quotes included. Quotes within interpolations do not end
+// it.
+func stringEnd(content string) int {
+	interpolation := 0
+	for i := 1; i < len(content); i++ {
+		switch {
+		case content[i] == '\\':
+			i++
+		case strings.HasPrefix(content[i:], "${"):
+			interpolation++
+			i++
+		case content[i] == '}' && interpolation > 0:
+			interpolation--
+		case content[i] == '"' && interpolation == 0:
+			return i + 1
+		case content[i] == '\n':
+			return i
+		}
+	}
+	return len(content)
+}
+
+// hasPlugin returns whether the plugin directory, or its subdirectory for
+// the current platform, holds the given provider.
+func hasPlugin(pluginDir string, p providerRequirement) bool {
+	for _, dir := range []string{pluginDir, filepath.Join(pluginDir, runtime.GOOS+"_"+runtime.GOARCH)} {
+		pattern := p.String() + "*"
+		if p.version == "" {
+			pattern = p.String() + "_v*"
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, match := range matches {
+			if stat, err := os.Stat(match); err == nil && !stat.IsDir() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// checkOfflinePlugins ensures, in offline mode, that the plugin directory
+// holds every provider the step templates of the cluster's platform use,
+// so that a missing one is reported before anything is created.
+func (m *State) checkOfflinePlugins() error {
+	pluginDir, err := m.offlinePluginDir()
+	if err != nil || pluginDir == "" {
+		return err
+	}
+	m.pluginDir = pluginDir
+	m.offline = true
+
+	var dirs []string
+	for _, step := range terraformStates {
+		dir, err := m.stepTemplates(step)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		dirs = append(dirs, dir)
+	}
+	providers, err := templateProviders(dirs...)
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, p := range providers {
+		if !hasPlugin(pluginDir, p) {
+			missing = append(missing, p.String())
+		}
+	}
+	if len(missing) != 0 {
+		return fmt.Errorf("Terraform providers missing from plugin directory %s in offline mode: %s", pluginDir, strings.Join(missing, ", "))
+	}
+	log.Debugf("Using the Terraform providers of %s", pluginDir)
+	return nil
+}
@@ -0,0 +1,135 @@
+package workflow
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+// writeTemplates writes the given templates, by path relative to dir.
+func writeTemplates(t *testing.T, dir string, templates map[string]string) {
+	for path, content := range templates {
+		path = filepath.Join(dir, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestTemplateProviders(t *testing.T) {
+	dir, err := ioutil.TempDir("", "offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplates(t, dir, map[string]string{
+		"steps/tls/config.tf": "provider \"tls\" {\n  # version = \"0.1.0\"\n  version = \"1.0.1\"\n}\n\nprovider \"aws\" {\n  region = \"${var.region}\"\n\n  assume_role {\n    role_arn = \"${lookup(var.roles, \"tls\")}\"\n  }\n\n  version = \"1.8.0\"\n}\n",
+		"steps/tls/main.tf":   "resource \"tls_private_key\" \"ca\" {}\n\ndata \"terraform_remote_state\" \"assets\" {}\n\nmodule \"ca\" {\n  source = \"../../modules/ca\"\n}\n",
+		"modules/ca/main.tf":  "resource \"local_file\" \"ca\" {}\n\nmodule \"self\" {\n  source = \"../ca\"\n}\n",
+	})
+
+	providers, err := templateProviders(filepath.Join(dir, "steps", "tls"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range providers {
+		got = append(got, p.String())
+	}
+	expected := []string{"terraform-provider-aws_v1.8.0", "terraform-provider-local", "terraform-provider-tls_v1.0.1"}
+	if strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected providers %v, got %v", expected, got)
+	}
+}
+
+func TestCheckOfflinePlugins(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+	writeTemplates(t, stepsDir, map[string]string{
+		"tls/aws/main.tf":    "provider \"tls\" {\n  version = \"1.0.1\"\n}\n",
+		"etcd/aws/main.tf":   "resource \"aws_instance\" \"etcd\" {}\n",
+		"masters/aws/dns.tf": "resource \"aws_route53_record\" \"api\" {}\n",
+	})
+	pluginDir := filepath.Join(filepath.Dir(clusterDir), "plugins")
+	if err := os.MkdirAll(pluginDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(pluginDir, "terraform-provider-tls_v1.0.1_x4"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	w := InstallFullWorkflow(clusterDir)
+	w.SetOffline(pluginDir)
+	r := newFakeRunner()
+	err := runWithFake(w, r, stepsDir)
+	if err == nil || !strings.Contains(err.Error(), "terraform-provider-aws") || strings.Contains(err.Error(), "terraform-provider-tls") {
+		t.Fatalf("expected the aws provider to be reported missing, got: %v", err)
+	}
+	if len(r.Calls()) != 0 {
+		t.Errorf("expected no Terraform command before the plugins are checked, got %v", r.Calls())
+	}
+
+	platformDir := filepath.Join(pluginDir, runtime.GOOS+"_"+runtime.GOARCH)
+	if err := os.MkdirAll(platformDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(platformDir, "terraform-provider-aws_v1.8.0_x4"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := runWithFake(w, newFakeRunner(), stepsDir); err != nil {
+		t.Errorf("expected the install to succeed with all plugins, got: %v", err)
+	}
+}
+
+func TestOfflineRequiresPluginDir(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	w := InstallFullWorkflow(clusterDir)
+	w.SetOffline("")
+	r := newFakeRunner()
+	err := runWithFake(w, r, stepsDir)
+	if err == nil || !strings.Contains(err.Error(), "--plugin-dir") {
+		t.Fatalf("expected the missing plugin directory to be reported, got: %v", err)
+	}
+	if len(r.Calls()) != 0 {
+		t.Errorf("expected no Terraform command without a plugin directory, got %v", r.Calls())
+	}
+}
+
+func TestTerraformRunnerOfflineInit(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake terraform binary is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "offline")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	binary := filepath.Join(dir, "terraform")
+	script := "#!/bin/sh\necho \"$CHECKPOINT_DISABLE $TF_DATA_DIR $@\" >> args\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	r := &terraformRunner{binaryPath: binary, pluginDir: "/plugins"}
+	if err := r.Init(context.Background(), dir, "topology", "templates"); err != nil {
+		t.Fatal(err)
+	}
+	args, err := ioutil.ReadFile(filepath.Join(dir, "args"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "1 .terraform-topology init -input=false -reconfigure -plugin-dir=/plugins -get-plugins=false -upgrade=false templates\n"
+	if string(args) != expected {
+		t.Errorf("expected terraform to run with %q, got %q", expected, args)
+	}
+}
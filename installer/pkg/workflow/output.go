@@ -0,0 +1,180 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	configgenerator "github.com/coreos/tectonic-installer/installer/pkg/config-generator"
+	"github.com/coreos/tectonic-installer/installer/pkg/tfstate"
+)
+
+// Output formats supported by PrintOutputs.
+const (
+	OutputFormatText   = "text"
+	OutputFormatJSON   = "json"
+	OutputFormatExport = "export"
+)
+
+// kubeconfigPath is where the assets step renders the admin kubeconfig.
+var kubeconfigPath = filepath.Join(generatedPath, "auth", "kubeconfig")
+
+var exportNameRegexp = regexp.MustCompile(`[^A-Z0-9_]`)
+
+// Outputs gathers the outputs of the given cluster directory: the endpoints
+// derived from its config, the path of its kubeconfig, the node addresses
+// found in the Terraform states and the outputs of each step's state, named
+// "<step>.<output>". Sensitive outputs and the ignition configs are left
+// out.
+func Outputs(ctx context.Context, clusterDir string) (map[string]interface{}, error) {
+	cluster, err := readClusterConfig(filepath.Join(clusterDir, configFileName), "", true)
+	if err != nil {
+		return nil, err
+	}
+	m := &State{clusterDir: clusterDir, cluster: *cluster}
+
+	c := configgenerator.New(*cluster)
+	endpoints := c.Endpoints()
+	outputs := map[string]interface{}{
+		"admin_email":     cluster.Admin.Email,
+		"api_server_url":  endpoints.APIServerURL,
+		"base_address":    endpoints.BaseAddress,
+		"console_url":     endpoints.ConsoleURL,
+		"oidc_issuer_url": endpoints.OIDCIssuerURL,
+	}
+	if path, err := filepath.Abs(filepath.Join(clusterDir, kubeconfigPath)); err == nil {
+		if _, err := os.Stat(path); err == nil {
+			outputs["kubeconfig"] = path
+		}
+	}
+
+	for _, name := range terraformStates {
+		content, err := m.readStateFile(ctx, name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state %s: %v", name, err)
+		}
+		step, state, err := stepStatus(name, content)
+		if err != nil {
+			return nil, err
+		}
+		for output, value := range step.Outputs {
+			outputs[name+"."+output] = value
+		}
+		addStateOutputs(outputs, state)
+	}
+	return outputs, nil
+}
+
+// addStateOutputs adds the node addresses and load balancers found in the
+// given state to the outputs.
+func addStateOutputs(outputs map[string]interface{}, state *tfstate.State) {
+	if ips := state.MasterIPs(); len(ips) != 0 {
+		outputs["master_ips"] = ips
+	}
+	external, internal := state.APIDNSNames()
+	if external != "" {
+		outputs["api_external_dns_name"] = external
+	}
+	if internal != "" {
+		outputs["api_internal_dns_name"] = internal
+	}
+	for name, elb := range state.ELBNames() {
+		outputs["elb_"+name] = elb
+	}
+}
+
+// PrintOutputs writes the outputs to w, as "name = value" lines, as JSON or
+// as shell export lines. If name is set, only the named output is written,
+// as a bare value in the text format.
+func PrintOutputs(w io.Writer, outputs map[string]interface{}, name, format string) error {
+	if name != "" {
+		value, ok := outputs[name]
+		if !ok {
+			return fmt.Errorf("no output named %q", name)
+		}
+		outputs = map[string]interface{}{name: value}
+	}
+
+	switch format {
+	case OutputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if name != "" {
+			return enc.Encode(outputs[name])
+		}
+		return enc.Encode(outputs)
+	case OutputFormatText, "":
+		if name != "" {
+			_, err := fmt.Fprintln(w, strings.Join(outputValues(outputs[name]), "\n"))
+			return err
+		}
+	case OutputFormatExport:
+	default:
+		return fmt.Errorf("invalid output format %q", format)
+	}
+
+	var names []string
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		values := outputValues(outputs[name])
+		var err error
+		if format == OutputFormatExport {
+			_, err = fmt.Fprintf(w, "export %s=%s\n", exportName(name), shellQuote(strings.Join(values, " ")))
+		} else {
+			_, err = fmt.Fprintf(w, "%s = %s\n", name, strings.Join(values, ", "))
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// outputValues returns the elements of a list output, or the single value of
+// any other output, as strings.
+func outputValues(v interface{}) []string {
+	var list []interface{}
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []string:
+		return v
+	case []interface{}:
+		list = v
+	default:
+		list = []interface{}{v}
+	}
+	values := make([]string, 0, len(list))
+	for _, e := range list {
+		if s, ok := e.(string); ok {
+			values = append(values, s)
+			continue
+		}
+		content, _ := json.Marshal(e)
+		values = append(values, string(content))
+	}
+	return values
+}
+
+// exportName returns the name of the environment variable of an output,
+// e.g. TECTONIC_TOPOLOGY_S3_BUCKET for topology.s3_bucket.
+func exportName(name string) string {
+	return "TECTONIC_" + exportNameRegexp.ReplaceAllString(strings.ToUpper(name), "_")
+}
+
+// shellQuote quotes a value for POSIX shells.
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
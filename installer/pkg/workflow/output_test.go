@@ -0,0 +1,110 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestOutputs(t *testing.T) {
+	clusterDir, _ := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	states := map[string]string{
+		topologyStep: topologyState,
+		assetsStep:   assetsState,
+	}
+	for name, content := range states {
+		if err := ioutil.WriteFile(filepath.Join(clusterDir, stateFileName(name)), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	outputs, err := Outputs(context.Background(), clusterDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if outputs["topology.s3_bucket"] != "mycluster-tnc.example.com" {
+		t.Errorf("expected the topology outputs, got %v", outputs)
+	}
+	for _, name := range []string{"api_server_url", "console_url", "oidc_issuer_url", "base_address", "admin_email"} {
+		if _, ok := outputs[name]; !ok {
+			t.Errorf("expected output %s, got %v", name, outputs)
+		}
+	}
+	for name := range outputs {
+		if strings.HasPrefix(name, "assets.") {
+			t.Errorf("expected no sensitive nor ignition output, got %s", name)
+		}
+	}
+	if _, ok := outputs["kubeconfig"]; ok {
+		t.Error("expected no kubeconfig before the assets are generated")
+	}
+}
+
+func TestPrintOutputs(t *testing.T) {
+	outputs := map[string]interface{}{
+		"console_url":                 "https://mycluster.example.com",
+		"topology.subnet_ids_workers": []interface{}{"subnet-1", "subnet-2"},
+		"admin_email":                 "it's@example.com",
+	}
+
+	testCases := []struct {
+		test          string
+		name          string
+		format        string
+		expected      string
+		expectedError bool
+	}{
+		{
+			test:     "text",
+			format:   OutputFormatText,
+			expected: "admin_email = it's@example.com\nconsole_url = https://mycluster.example.com\ntopology.subnet_ids_workers = subnet-1, subnet-2\n",
+		},
+		{
+			test:     "single list",
+			name:     "topology.subnet_ids_workers",
+			format:   OutputFormatText,
+			expected: "subnet-1\nsubnet-2\n",
+		},
+		{
+			test:     "export",
+			format:   OutputFormatExport,
+			expected: "export TECTONIC_ADMIN_EMAIL='it'\\''s@example.com'\nexport TECTONIC_CONSOLE_URL='https://mycluster.example.com'\nexport TECTONIC_TOPOLOGY_SUBNET_IDS_WORKERS='subnet-1 subnet-2'\n",
+		},
+		{
+			test:     "single json",
+			name:     "console_url",
+			format:   OutputFormatJSON,
+			expected: "\"https://mycluster.example.com\"\n",
+		},
+		{
+			test:          "unknown name",
+			name:          "kubeconfig",
+			format:        OutputFormatText,
+			expectedError: true,
+		},
+		{
+			test:          "unknown format",
+			format:        "yaml",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		var out bytes.Buffer
+		err := PrintOutputs(&out, outputs, tc.name, tc.format)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error %v, got %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		if !tc.expectedError && !reflect.DeepEqual(out.String(), tc.expected) {
+			t.Errorf("Test case %s: expected %q, got %q", tc.test, tc.expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package workflow
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const plansPath = "plans"
+
+var (
+	planSummaryRegexp = regexp.MustCompile(`Plan: (\d+) to add, (\d+) to change, (\d+) to destroy`)
+	ansiColorRegexp   = regexp.MustCompile("\x1b\\[[0-9;]*m")
+)
+
+// planSummary holds the resource counts of the plan of a single step.
+type planSummary struct {
+	step    string
+	add     int
+	change  int
+	destroy int
+	// state is the Terraform state the plan was made against.
+	state string
+	// workflowStep is the name of the workflow step that made the plan.
+	workflowStep string
+	// planFile is the path of the saved plan, relative to the cluster
+	// directory, or empty if the plan is not saved.
+	planFile string
+}
+
+// planFilePath returns the path of the saved plan of a step, relative to the
+// cluster directory. Steps applied more than once with different arguments
+// get one plan per set of arguments.
+func planFilePath(step string, destroy bool, extraArgs ...string) string {
+	name := step
+	if len(extraArgs) != 0 {
+		sum := sha256.Sum256([]byte(strings.Join(extraArgs, " ")))
+		name = fmt.Sprintf("%s-%s", step, hex.EncodeToString(sum[:4]))
+	}
+	if destroy {
+		name += ".destroy"
+	}
+	return filepath.Join(plansPath, name+".tfplan")
+}
+
+// planStateSumPath returns the path of the file recording the checksum of the
+// state a saved plan was made against, relative to the cluster directory.
+func planStateSumPath(planFile string) string {
+	return planFile + ".statesum"
+}
+
+// stateSum returns the checksum of the content of the given state, or an
+// empty string if there is no such state.
+func (m *State) stateSum(ctx context.Context, state string) (string, error) {
+	content, err := m.readStateFile(ctx, state)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// runPlanStep runs terraform plan for the given step, saves the plan file
+// along with the checksum of the state it was made against, and records the
+// summary of the changes onto the state.
+func runPlanStep(ctx context.Context, m *State, step, templateDir string, destroy bool, extraArgs ...string) error {
+	if err := os.MkdirAll(filepath.Join(m.clusterDir, plansPath), os.ModeDir|0755); err != nil {
+		return fmt.Errorf("failed to create plans directory: %v", err)
+	}
+
+	sum, err := m.stateSum(ctx, step)
+	if err != nil {
+		return err
+	}
+	planFile := planFilePath(step, destroy, extraArgs...)
+	output, err := m.terraform().Plan(ctx, m.clusterDir, step, templateDir, planFile, destroy, extraArgs...)
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(filepath.Join(m.clusterDir, planStateSumPath(planFile)), []byte(sum), 0644); err != nil {
+		return fmt.Errorf("failed to save plan of step %s: %v", step, err)
+	}
+
+	summary, err := parsePlanSummary(output)
+	if err != nil {
+		return fmt.Errorf("failed to parse plan of step %s: %v", step, err)
+	}
+	summary.step = checkpointKey(step, extraArgs...)
+	summary.state = step
+	summary.workflowStep = runningStep(ctx)
+	summary.planFile = planFile
+	return m.withLock(func() error {
+		m.plans = append(m.plans, summary)
+		return nil
+	})
+}
+
+// discardReplannedPlans removes the saved plans of the states already planned
+// by a previous step of the workflow, e.g. the masters state planned with the
+// bootstrap node, then without. They were made against the state as it is
+// before the run, which the previous step changes once applied, so they would
+// never apply the reviewed changes. A step planning the same changes as the
+// previous one shares its plan, which it finds applied already. The plans
+// must be sorted in workflow order.
+func (m *State) discardReplannedPlans() error {
+	planned := make(map[string]planSummary)
+	for i, p := range m.plans {
+		first, ok := planned[p.state]
+		if !ok {
+			planned[p.state] = p
+			continue
+		}
+		if p.planFile == first.planFile {
+			continue
+		}
+		for _, path := range []string{p.planFile, planStateSumPath(p.planFile)} {
+			if err := os.Remove(filepath.Join(m.clusterDir, path)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to discard plan of step %s: %v", p.step, err)
+			}
+		}
+		m.plans[i].planFile = ""
+		log.Warnf("Not saving the plan of step %s: state %s is planned by step %s before it, and must be applied before %s is planned again", p.step, p.state, first.step, p.step)
+	}
+	return nil
+}
+
+// applySavedPlan applies the plan of the given step saved by a previous run in
+// plan mode. A plan made against another content of the state, e.g. because
+// another step planning the same state was applied since, would not make the
+// reviewed changes, and is refused.
+func (m *State) applySavedPlan(ctx context.Context, step string, destroy bool, extraArgs ...string) error {
+	planFile := planFilePath(step, destroy, extraArgs...)
+	if _, err := os.Stat(filepath.Join(m.clusterDir, planFile)); os.IsNotExist(err) {
+		return fmt.Errorf("no saved plan for step %s; run plan again", checkpointKey(step, extraArgs...))
+	}
+	sumPath := filepath.Join(m.clusterDir, planStateSumPath(planFile))
+	planned, err := ioutil.ReadFile(sumPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	if err == nil {
+		sum, err := m.stateSum(ctx, step)
+		if err != nil {
+			return err
+		}
+		if string(planned) != sum {
+			return fmt.Errorf("the saved plan of step %s is stale: state %s changed since it was planned; run plan again", checkpointKey(step, extraArgs...), stateFileName(step))
+		}
+	}
+	if err := m.terraform().ApplyPlan(ctx, m.clusterDir, step, planFile); err != nil {
+		return err
+	}
+	if err := os.Remove(sumPath); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// skipInPlanMode returns whether a step generating the given files must do
+// nothing, as the workflow runs in plan mode and must leave the cluster
+// directory as it is.
+func (m *State) skipInPlanMode(files string) bool {
+	if !m.plan {
+		return false
+	}
+	log.Infof("Not generating %s in plan mode", files)
+	return true
+}
+
+// parsePlanSummary extracts the resource counts from the output of
+// terraform plan.
+func parsePlanSummary(output string) (planSummary, error) {
+	var summary planSummary
+
+	output = ansiColorRegexp.ReplaceAllString(output, "")
+	match := planSummaryRegexp.FindStringSubmatch(output)
+	if match == nil {
+		if strings.Contains(output, "No changes.") {
+			return summary, nil
+		}
+		return summary, fmt.Errorf("no plan summary found in output")
+	}
+
+	for i, count := range []*int{&summary.add, &summary.change, &summary.destroy} {
+		n, err := strconv.Atoi(match[i+1])
+		if err != nil {
+			return summary, err
+		}
+		*count = n
+	}
+	return summary, nil
+}
+
+// sortPlans sorts the given plans in the order of the workflow steps that made
+// them, rather than in the order the steps happened to complete.
+func sortPlans(plans []planSummary, steps []string) {
+	order := make(map[string]int, len(steps))
+	for i, step := range steps {
+		order[step] = i
+	}
+	sort.SliceStable(plans, func(i, j int) bool {
+		return order[plans[i].workflowStep] < order[plans[j].workflowStep]
+	})
+}
+
+// printPlanSummary writes one line of resource counts per planned step, along
+// with whether its plan was saved.
+func printPlanSummary(w io.Writer, plans []planSummary) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tADD\tCHANGE\tDESTROY\tSAVED\t")
+	var add, change, destroy int
+	for _, p := range plans {
+		saved := "yes"
+		if p.planFile == "" {
+			saved = "no"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t\n", p.step, p.add, p.change, p.destroy, saved)
+		add += p.add
+		change += p.change
+		destroy += p.destroy
+	}
+	fmt.Fprintf(tw, "TOTAL\t%d\t%d\t%d\t\t\n", add, change, destroy)
+	return tw.Flush()
+}
@@ -0,0 +1,202 @@
+package workflow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParsePlanSummary(t *testing.T) {
+	testCases := []struct {
+		test          string
+		output        string
+		expected      planSummary
+		expectedError bool
+	}{
+		{
+			test:     "changes",
+			output:   "...\nPlan: 3 to add, 1 to change, 2 to destroy.\n",
+			expected: planSummary{add: 3, change: 1, destroy: 2},
+		},
+		{
+			test:     "colored output",
+			output:   "\x1b[0m\x1b[1mPlan:\x1b[0m 4 to add, 0 to change, 0 to destroy.\x1b[0m\n",
+			expected: planSummary{add: 4},
+		},
+		{
+			test:     "no changes",
+			output:   "No changes. Infrastructure is up-to-date.\n",
+			expected: planSummary{},
+		},
+		{
+			test:          "unexpected output",
+			output:        "Error: something went wrong\n",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		got, err := parsePlanSummary(tc.output)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("Test case %s: expected %+v, got %+v", tc.test, tc.expected, got)
+		}
+	}
+}
+
+func TestPlanFilePath(t *testing.T) {
+	paths := map[string]bool{}
+	for _, p := range []string{
+		planFilePath(mastersStep, false, bootstrapOn),
+		planFilePath(mastersStep, false, bootstrapOff),
+		planFilePath(mastersStep, true, bootstrapOff),
+		planFilePath(etcdStep, false),
+	} {
+		if paths[p] {
+			t.Errorf("plan file path %s is not unique", p)
+		}
+		paths[p] = true
+	}
+}
+
+func TestPrintPlanSummary(t *testing.T) {
+	var buf bytes.Buffer
+	plans := []planSummary{
+		{step: tlsStep, add: 2},
+		{step: etcdStep, add: 1, change: 1, destroy: 3},
+	}
+	if err := printPlanSummary(&buf, plans); err != nil {
+		t.Fatalf("failed to print summary: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected a header, one line per step and a total, got:\n%s", buf.String())
+	}
+	if fields := strings.Fields(lines[3]); strings.Join(fields, " ") != "TOTAL 3 1 3" {
+		t.Errorf("unexpected total line %q", lines[3])
+	}
+}
+
+func TestSortPlans(t *testing.T) {
+	plans := []planSummary{
+		{step: etcdStep, workflowStep: "etcd"},
+		{step: checkpointKey(mastersStep, bootstrapOff), workflowStep: "join-masters"},
+		{step: tlsStep, workflowStep: "tls"},
+	}
+	sortPlans(plans, []string{"tls", "etcd", "join-masters"})
+	var got []string
+	for _, p := range plans {
+		got = append(got, p.workflowStep)
+	}
+	if strings.Join(got, ",") != "tls,etcd,join-masters" {
+		t.Errorf("expected the plans in workflow order, got %v", got)
+	}
+}
+
+func TestPlanSavesOnePlanPerState(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	w := InstallFullWorkflow(clusterDir)
+	w.Plan()
+	r := newFakeRunner()
+	if err := runWithFake(w, r, stepsDir); err != nil {
+		t.Fatalf("expected the plan to succeed, got: %v", err)
+	}
+	if applied := commands(r.Calls(), "apply"); len(applied) != 0 {
+		t.Errorf("expected nothing to be applied, got %v", applied)
+	}
+	if _, err := os.Stat(filepath.Join(clusterDir, generatedPath)); !os.IsNotExist(err) {
+		t.Errorf("expected no config maps to be generated in plan mode, got: %v", err)
+	}
+	if ign, _ := filepath.Glob(filepath.Join(clusterDir, "*.ign")); len(ign) != 0 {
+		t.Errorf("expected no ignition configs to be generated in plan mode, got %v", ign)
+	}
+
+	// tnc_dns and masters are planned twice, with and without the bootstrap
+	// node: only the first plans hold once applied.
+	expected := map[string]bool{
+		planFilePath(tlsStep, false):                   true,
+		planFilePath(assetsStep, false):                true,
+		planFilePath(topologyStep, false):              true,
+		planFilePath(tncDNSStep, false, bootstrapOn):   true,
+		planFilePath(mastersStep, false, bootstrapOn):  true,
+		planFilePath(tncDNSStep, false, bootstrapOff):  false,
+		planFilePath(etcdStep, false):                  true,
+		planFilePath(mastersStep, false, bootstrapOff): false,
+		planFilePath(joinWorkersStep, false):           true,
+	}
+	for path, saved := range expected {
+		_, err := os.Stat(filepath.Join(clusterDir, path))
+		if saved && err != nil {
+			t.Errorf("expected plan %s to be saved, got: %v", path, err)
+		}
+		if !saved && !os.IsNotExist(err) {
+			t.Errorf("expected plan %s not to be saved, got: %v", path, err)
+		}
+	}
+}
+
+func TestSavedPlansRefuseStalePlans(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	selection := StepSelection{Only: []string{"tnc-cname", "tnc-a-record"}}
+	w := InstallFullWorkflow(clusterDir)
+	if err := w.Select(selection); err != nil {
+		t.Fatal(err)
+	}
+	w.Plan()
+	if err := runWithFake(w, newFakeRunner(), stepsDir); err != nil {
+		t.Fatalf("expected the plan to succeed, got: %v", err)
+	}
+
+	// The plan of tnc-a-record was not saved, as it no longer holds once
+	// tnc-cname is applied.
+	w = InstallFullWorkflow(clusterDir)
+	if err := w.Select(selection); err != nil {
+		t.Fatal(err)
+	}
+	w.UseSavedPlans()
+	r := newFakeRunner()
+	err := runWithFake(w, r, stepsDir)
+	if err == nil || !strings.Contains(err.Error(), "no saved plan") {
+		t.Fatalf("expected the missing plan to be refused, got: %v", err)
+	}
+	if applied := commands(r.Calls(), "apply-plan"); len(applied) != 1 {
+		t.Errorf("expected only the plan of tnc-cname to be applied, got %v", applied)
+	}
+
+	// The state changes after the plan, e.g. from another checkout.
+	selection = StepSelection{Only: []string{"tnc-a-record"}}
+	w = InstallFullWorkflow(clusterDir)
+	if err := w.Select(selection); err != nil {
+		t.Fatal(err)
+	}
+	w.Plan()
+	if err := runWithFake(w, newFakeRunner(), stepsDir); err != nil {
+		t.Fatalf("expected the plan to succeed, got: %v", err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(clusterDir, stateFileName(tncDNSStep)), []byte(liveState), 0644); err != nil {
+		t.Fatal(err)
+	}
+	w = InstallFullWorkflow(clusterDir)
+	if err := w.Select(selection); err != nil {
+		t.Fatal(err)
+	}
+	w.UseSavedPlans()
+	r = newFakeRunner()
+	err = runWithFake(w, r, stepsDir)
+	if err == nil || !strings.Contains(err.Error(), "stale") {
+		t.Fatalf("expected the stale plan to be refused, got: %v", err)
+	}
+	if applied := commands(r.Calls(), "apply-plan"); len(applied) != 0 {
+		t.Errorf("expected no plan to be applied, got %v", applied)
+	}
+}
@@ -0,0 +1,100 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// defaultRetryableErrors match the TerraForm errors caused by cloud API
+// throttling or eventual consistency, which usually go away on their own.
+var defaultRetryableErrors = []*regexp.Regexp{
+	// AWS API throttling.
+	regexp.MustCompile(`RequestLimitExceeded`),
+	regexp.MustCompile(`Throttling: Rate exceeded`),
+	// Resources that were just created but are not visible yet.
+	regexp.MustCompile(`Invalid(InstanceID|Group|SubnetID|VpcID|RouteTableID|InternetGatewayID|NetworkInterfaceID|AllocationID)\.NotFound`),
+	regexp.MustCompile(`InvalidParameterValue: Value \(.*\) for parameter iamInstanceProfile\.name is invalid`),
+	regexp.MustCompile(`Invalid IamInstanceProfile`),
+	regexp.MustCompile(`NoSuchBucket: The specified bucket does not exist`),
+	// Conflicting operations still in progress.
+	regexp.MustCompile(`OperationAborted: A conflicting conditional operation is currently in progress`),
+	regexp.MustCompile(`PriorRequestNotComplete`),
+	regexp.MustCompile(`DependencyViolation`),
+}
+
+// RetryPolicy describes how the TerraForm steps of a workflow are retried
+// when they fail with a transient error.
+type RetryPolicy struct {
+	// MaxAttempts is the maximum number of times a step is run. A value
+	// below 2 disables retries.
+	MaxAttempts int
+	// Backoff is the delay before the first retry. It doubles after each
+	// attempt, up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Retryable are matched against the TerraForm error output. A failed
+	// step is only retried if one of them matches.
+	Retryable []*regexp.Regexp
+}
+
+// DefaultRetryPolicy retries the well-known transient AWS errors a few times.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     10 * time.Second,
+	MaxBackoff:  2 * time.Minute,
+	Retryable:   defaultRetryableErrors,
+}
+
+// retryable returns the pattern matching the given error, if any.
+func (p RetryPolicy) retryable(err error) *regexp.Regexp {
+	tfErr, ok := err.(*terraformError)
+	if !ok {
+		return nil
+	}
+	for _, re := range p.Retryable {
+		if re.MatchString(tfErr.stderr) {
+			return re
+		}
+	}
+	return nil
+}
+
+// run calls f until it succeeds, it fails with an error that is not
+// retryable, the attempts are exhausted or the context is done.
+func (p RetryPolicy) run(ctx context.Context, step string, f func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := f()
+		if err == nil || attempt >= p.MaxAttempts || ctx.Err() != nil {
+			return err
+		}
+		re := p.retryable(err)
+		if re == nil {
+			return err
+		}
+
+		log.Warnf("Step %s failed on attempt %d/%d with a transient error matching %q, retrying in %s", step, attempt, p.MaxAttempts, re, backoff)
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return fmt.Errorf("%v (retry interrupted: %v)", err, ctx.Err())
+		}
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// retries returns the retry policy of the workflow, or the default one.
+func (m *State) retries() RetryPolicy {
+	if m.retryPolicy == nil {
+		return DefaultRetryPolicy
+	}
+	return *m.retryPolicy
+}
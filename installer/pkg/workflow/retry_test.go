@@ -0,0 +1,87 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRetryPolicyRun(t *testing.T) {
+	throttled := &terraformError{err: errors.New("exit status 1"), stderr: "Error: RequestLimitExceeded: Request limit exceeded."}
+	broken := &terraformError{err: errors.New("exit status 1"), stderr: "Error: invalid CIDR block"}
+
+	testCases := []struct {
+		test             string
+		errs             []error
+		expectedAttempts int
+		expectedError    bool
+	}{
+		{
+			test:             "success",
+			errs:             []error{nil},
+			expectedAttempts: 1,
+		},
+		{
+			test:             "transient error",
+			errs:             []error{throttled, throttled, nil},
+			expectedAttempts: 3,
+		},
+		{
+			test:             "attempts exhausted",
+			errs:             []error{throttled, throttled, throttled, nil},
+			expectedAttempts: 3,
+			expectedError:    true,
+		},
+		{
+			test:             "permanent error",
+			errs:             []error{broken, nil},
+			expectedAttempts: 1,
+			expectedError:    true,
+		},
+		{
+			test:             "not a terraform error",
+			errs:             []error{errors.New("RequestLimitExceeded"), nil},
+			expectedAttempts: 1,
+			expectedError:    true,
+		},
+	}
+
+	policy := DefaultRetryPolicy
+	policy.Backoff = time.Millisecond
+
+	for _, tc := range testCases {
+		var attempts int
+		err := policy.run(context.Background(), tc.test, func() error {
+			err := tc.errs[attempts]
+			attempts++
+			return err
+		})
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %t, got: %v", tc.test, tc.expectedError, err)
+		}
+		if attempts != tc.expectedAttempts {
+			t.Errorf("Test case %s: expected %d attempts, got %d", tc.test, tc.expectedAttempts, attempts)
+		}
+	}
+}
+
+func TestRetryPolicyRunCancelled(t *testing.T) {
+	throttled := &terraformError{err: errors.New("exit status 1"), stderr: "Throttling: Rate exceeded"}
+	policy := DefaultRetryPolicy
+	policy.Backoff = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	var attempts int
+	err := policy.run(ctx, "test", func() error {
+		attempts++
+		cancel()
+		return throttled
+	})
+	if err == nil {
+		t.Error("expected an error after cancellation")
+	}
+	if attempts != 1 {
+		t.Errorf("expected 1 attempt, got %d", attempts)
+	}
+}
@@ -0,0 +1,76 @@
+package workflow
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// rollbackArgs are the extra arguments the destroy workflow passes when
+// destroying the given Terraform state; the rollback destroys the same way.
+var rollbackArgs = map[string][]string{
+	mastersStep: {bootstrapOff},
+	tncDNSStep:  {bootstrapOff},
+}
+
+// RollbackError is returned by a workflow configured to roll back on
+// failure. It holds the error that caused the rollback, along with the
+// errors of the steps that could not be destroyed.
+type RollbackError struct {
+	Err     error
+	Cleanup []StepError
+}
+
+// RollbackError implements the error interface.
+func (e *RollbackError) Error() string {
+	if len(e.Cleanup) == 0 {
+		return fmt.Sprintf("%v; rolled back the steps applied by this run", e.Err)
+	}
+	msgs := make([]string, 0, len(e.Cleanup))
+	for _, err := range e.Cleanup {
+		msgs = append(msgs, fmt.Sprintf("step %s: %v", err.Step, err.Err))
+	}
+	return fmt.Sprintf("%v; rollback failed, resources may be left behind: %s", e.Err, strings.Join(msgs, "; "))
+}
+
+// RollbackOnFailure configures the workflow to destroy the Terraform steps it
+// created whenever it fails. Steps that existed before the run are kept. A
+// run interrupted by the user is not rolled back, so that the interruption
+// does not start a destroy.
+func (w *Workflow) RollbackOnFailure() {
+	w.state.rollbackOnFailure = true
+}
+
+// recordCreated remembers that this run created the state of the given step,
+// in creation order. A state that is applied by several steps is recorded
+// once.
+func (m *State) recordCreated(step string) {
+	m.withLock(func() error {
+		for _, s := range m.created {
+			if s == step {
+				return nil
+			}
+		}
+		m.created = append(m.created, step)
+		return nil
+	})
+}
+
+// rollback destroys the steps created by this run, in reverse order. All of
+// them are attempted; the errors of those that could not be destroyed are
+// returned.
+func (m *State) rollback(ctx context.Context) []StepError {
+	var errs []StepError
+	for i := len(m.created) - 1; i >= 0; i-- {
+		step := m.created[i]
+		log.Infof("Rolling back step %s", step)
+		if err := runDestroyStep(ctx, m, step, rollbackArgs[step]...); err != nil {
+			log.Errorf("Failed to roll back step %s: %v", step, err)
+			errs = append(errs, StepError{Step: step, Err: err})
+		}
+	}
+	m.created = nil
+	return errs
+}
@@ -0,0 +1,114 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecordCreated(t *testing.T) {
+	m := State{}
+	for _, step := range []string{topologyStep, mastersStep, tncDNSStep, mastersStep} {
+		m.recordCreated(step)
+	}
+	expected := []string{topologyStep, mastersStep, tncDNSStep}
+	if strings.Join(m.created, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected created steps %v, got %v", expected, m.created)
+	}
+}
+
+// cancelKey carries the function cancelling the context of a workflow, so that
+// a step can interrupt it.
+type cancelKey struct{}
+
+func TestWorkflowRollbackOnFailure(t *testing.T) {
+	clusterDir, err := ioutil.TempDir("", "workflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(clusterDir)
+
+	// The topology state still exists but cannot be destroyed, as the step
+	// templates are not available; the assets state is already gone.
+	if err := ioutil.WriteFile(filepath.Join(clusterDir, topologyStep+".tfstate"), []byte("{}"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	create := func(ctx context.Context, m *State) error {
+		m.recordCreated(assetsStep)
+		m.recordCreated(topologyStep)
+		return nil
+	}
+	fail := func(ctx context.Context, m *State) error {
+		return errors.New("step failed")
+	}
+	interrupt := func(ctx context.Context, m *State) error {
+		cancel := ctx.Value(cancelKey{}).(context.CancelFunc)
+		cancel()
+		return ctx.Err()
+	}
+
+	testCases := []struct {
+		test            string
+		rollback        bool
+		interrupted     bool
+		expectedCleanup []string
+	}{
+		{
+			test: "rollback disabled",
+		},
+		{
+			test:            "rollback enabled",
+			rollback:        true,
+			expectedCleanup: []string{topologyStep},
+		},
+		{
+			test:        "interrupted",
+			rollback:    true,
+			interrupted: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		step := newStep("fail", fail, "create")
+		if tc.interrupted {
+			step = newStep("fail", interrupt, "create")
+		}
+		w := Workflow{
+			state: State{clusterDir: clusterDir},
+			steps: []stepNode{newStep("create", create), step},
+		}
+		if tc.rollback {
+			w.RollbackOnFailure()
+		}
+
+		ctx, cancel := context.WithCancel(context.Background())
+		err := w.Execute(context.WithValue(ctx, cancelKey{}, cancel))
+		cancel()
+		if err == nil {
+			t.Errorf("Test case %s: expected an error", tc.test)
+			continue
+		}
+		rbErr, ok := err.(*RollbackError)
+		if ok != (tc.rollback && !tc.interrupted) {
+			t.Errorf("Test case %s: expected rollback: %t, got error: %v", tc.test, tc.rollback, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if !strings.Contains(rbErr.Error(), "step fail failed: step failed") {
+			t.Errorf("Test case %s: expected the original error to be reported, got: %v", tc.test, rbErr)
+		}
+		var cleanup []string
+		for _, e := range rbErr.Cleanup {
+			cleanup = append(cleanup, e.Step)
+		}
+		if strings.Join(cleanup, ",") != strings.Join(tc.expectedCleanup, ",") {
+			t.Errorf("Test case %s: expected cleanup errors for %v, got: %v", tc.test, tc.expectedCleanup, rbErr.Cleanup)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestClusterDir returns a cluster directory initialized with the basic
+// AWS config, along with a directory holding empty step templates.
+func newTestClusterDir(t *testing.T) (string, string) {
+	dir, err := ioutil.TempDir("", "workflow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	clusterDir := filepath.Join(dir, "cluster")
+	stepsDir := filepath.Join(dir, stepsBaseDir)
+	for _, step := range []string{tlsStep, assetsStep, topologyStep, tncDNSStep, mastersStep, etcdStep, joinWorkersStep} {
+		if err := os.MkdirAll(filepath.Join(stepsDir, step, "aws"), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.MkdirAll(clusterDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	ps, lic, err := generatePullSecretAndLicense("runner", time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatalf("failed to generate pull secret and license: %v", err)
+	}
+	ps.Close()
+	lic.Close()
+	for _, f := range []*os.File{ps, lic} {
+		if err := os.Rename(f.Name(), filepath.Join(dir, filepath.Base(f.Name()))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	cfg, err := ioutil.ReadFile("./fixtures/aws.basic.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := strings.NewReplacer(
+		"licensePath:\n", "licensePath: "+filepath.Join(dir, filepath.Base(lic.Name()))+"\n",
+		"pullSecretPath:\n", "pullSecretPath: "+filepath.Join(dir, filepath.Base(ps.Name()))+"\n",
+	).Replace(string(cfg))
+	if err := ioutil.WriteFile(filepath.Join(clusterDir, configFileName), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := buildInternalConfig(clusterDir); err != nil {
+		t.Fatal(err)
+	}
+	return clusterDir, stepsDir
+}
+
+// newFakeRunner returns a fake runner generating the root CA the ignition
+// step embeds once the TLS step is applied.
+func newFakeRunner() *FakeRunner {
+	r := NewFakeRunner()
+	r.OnApply(tlsStep, func(clusterDir string) error {
+		if err := os.MkdirAll(filepath.Join(clusterDir, "generated", "tls"), 0755); err != nil {
+			return err
+		}
+		return ioutil.WriteFile(filepath.Join(clusterDir, "generated", "tls", "root-ca.crt"), []byte("fake CA"), 0644)
+	})
+	return r
+}
+
+// runWithFake runs the given workflow with the fake runner, one step at a
+// time so that the order of the commands is deterministic.
+func runWithFake(w Workflow, r *FakeRunner, stepsDir string) error {
+	w.SetRunner(r)
+	w.SetParallelism(1)
+	w.state.stepsDir = stepsDir
+	return w.Execute(context.Background())
+}
+
+// commands returns the given command's calls, with their extra arguments.
+func commands(calls []RunnerCall, command string) []string {
+	var result []string
+	for _, c := range calls {
+		if c.Command != command {
+			continue
+		}
+		result = append(result, strings.TrimSpace(c.State+" "+strings.Join(c.Args, " ")))
+	}
+	return result
+}
+
+func TestInstallFullWorkflowWithFakeRunner(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	r := newFakeRunner()
+	if err := runWithFake(InstallFullWorkflow(clusterDir), r, stepsDir); err != nil {
+		t.Fatalf("expected the install to succeed, got: %v", err)
+	}
+	expected := []string{
+		tlsStep,
+		assetsStep,
+		topologyStep,
+		tncDNSStep + " " + bootstrapOn,
+		mastersStep + " " + bootstrapOn,
+		tncDNSStep + " " + bootstrapOff,
+		etcdStep,
+		mastersStep + " " + bootstrapOff,
+		joinWorkersStep,
+	}
+	if got := commands(r.Calls(), "apply"); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected applies %v, got %v", expected, got)
+	}
+	if got := len(commands(r.Calls(), "init")); got != len(expected) {
+		t.Errorf("expected one init per apply, got %d", got)
+	}
+
+	// Once bootstrapped, the bootstrap resources are not applied again.
+	r = newFakeRunner()
+	if err := runWithFake(InstallFullWorkflow(clusterDir), r, stepsDir); err != nil {
+		t.Fatalf("expected the second install to succeed, got: %v", err)
+	}
+	for _, apply := range commands(r.Calls(), "apply") {
+		if strings.Contains(apply, bootstrapOn) {
+			t.Errorf("expected no bootstrap apply on a bootstrapped cluster, got %q", apply)
+		}
+	}
+}
+
+func TestInstallFullWorkflowFailure(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	r := newFakeRunner()
+	r.FailOn("apply", etcdStep, errors.New("etcd failed"))
+	w := InstallFullWorkflow(clusterDir)
+	w.SetRetryPolicy(RetryPolicy{})
+	err := runWithFake(w, r, stepsDir)
+	if err == nil || !strings.Contains(err.Error(), "etcd failed") {
+		t.Fatalf("expected the etcd step to fail, got: %v", err)
+	}
+	for _, apply := range commands(r.Calls(), "apply") {
+		if apply == joinWorkersStep || apply == mastersStep+" "+bootstrapOff {
+			t.Errorf("expected no step to run after etcd failed, got %q", apply)
+		}
+	}
+}
+
+func TestDestroyWorkflowWithFakeRunner(t *testing.T) {
+	clusterDir, stepsDir := newTestClusterDir(t)
+	defer os.RemoveAll(filepath.Dir(clusterDir))
+
+	if err := runWithFake(InstallFullWorkflow(clusterDir), newFakeRunner(), stepsDir); err != nil {
+		t.Fatalf("expected the install to succeed, got: %v", err)
+	}
+
+	r := newFakeRunner()
+	if err := runWithFake(DestroyWorkflow(clusterDir), r, stepsDir); err != nil {
+		t.Fatalf("expected the destroy to succeed, got: %v", err)
+	}
+	expected := []string{
+		mastersStep + " " + bootstrapOff,
+		joinWorkersStep,
+		etcdStep,
+		tncDNSStep + " " + bootstrapOff,
+		topologyStep,
+		assetsStep,
+		tlsStep,
+	}
+	if got := commands(r.Calls(), "destroy"); strings.Join(got, ",") != strings.Join(expected, ",") {
+		t.Errorf("expected destroys %v, got %v", expected, got)
+	}
+	for _, step := range []string{tlsStep, assetsStep, topologyStep, tncDNSStep, mastersStep, etcdStep, joinWorkersStep} {
+		if hasStateFile(clusterDir, step) {
+			t.Errorf("expected the state of step %s to be removed", step)
+		}
+	}
+}
@@ -0,0 +1,314 @@
+package workflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+var (
+	nodePoolsKeyRegexp    = regexp.MustCompile(`^nodePools:\s*(#.*)?$`)
+	nodePoolItemRegexp    = regexp.MustCompile(`^\s*-\s`)
+	nodePoolFieldRegexp   = regexp.MustCompile(`^(\s*-\s+|\s+)(name|count):\s*([^#]*?)\s*(#.*)?$`)
+	topLevelYAMLKeyRegexp = regexp.MustCompile(`^[^\s#-]`)
+)
+
+// errScaleAborted is returned when the plan of a scale was not confirmed.
+var errScaleAborted = errors.New("scale aborted; the cluster config was left unchanged")
+
+// SetConfirm sets the function asking the user to confirm the changes of a
+// workflow before they are applied. Without it, changes are applied right
+// away.
+func (w *Workflow) SetConfirm(confirm func(question string) (bool, error)) {
+	w.state.confirm = confirm
+}
+
+// scaler changes the number of nodes of a node pool.
+type scaler struct {
+	pool  string
+	count int
+	// step is the Terraform step owning the pool, applied with args.
+	step string
+	args []string
+	// configPath is the temporary file holding the updated config, which
+	// replaces the config of the cluster directory once the scale is applied.
+	configPath string
+	// tfvars is the previous content of the Terraform variables, restored
+	// if the scale is aborted.
+	tfvars []byte
+}
+
+// ScaleWorkflow creates new instances of the 'scale' workflow, responsible
+// for changing the number of nodes of a node pool in the cluster config and
+// applying the change to the Terraform step owning the pool, once its plan
+// was confirmed.
+func ScaleWorkflow(clusterDir, pool string, count int) Workflow {
+	s := &scaler{pool: pool, count: count}
+	return NewBuilder(clusterDir).
+		Setup(NewStep("scale-config", s.updateConfigStep)).
+		Add(newTerraformStep("scale", s.applyStep)).
+		workflow
+}
+
+// updateConfigStep renders the config of the cluster directory with the count
+// of the node pool set to a temporary file, validates it and regenerates the
+// Terraform variables. The config itself is only replaced once the scale is
+// applied.
+func (s *scaler) updateConfigStep(ctx context.Context, m *State) error {
+	if m.clusterDir == "" {
+		return errors.New("no cluster dir given for scaling")
+	}
+	if s.count < 0 {
+		return fmt.Errorf("invalid node count %d", s.count)
+	}
+	configFilePath := filepath.Join(m.clusterDir, configFileName)
+	content, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return err
+	}
+	updated, err := setNodePoolCount(content, s.pool, s.count)
+	if err != nil {
+		return fmt.Errorf("%s: %v", configFilePath, err)
+	}
+
+	cluster, err := parseClusterConfig(updated, m.lenient)
+	if err != nil {
+		return fmt.Errorf("%s is not a valid config file: %s", configFilePath, err)
+	}
+	internal, err := config.ParseInternalFile(filepath.Join(m.clusterDir, internalFileName))
+	if err != nil {
+		return fmt.Errorf("%s is not a valid internal file: %s", internalFileName, err)
+	}
+	cluster.Internal = *internal
+	if err := cluster.ValidateAndLog(); err != nil {
+		return err
+	}
+
+	s.step, s.args, err = nodePoolStep(*cluster, s.pool)
+	if err != nil {
+		return err
+	}
+	m.cluster = *cluster
+	installed, err := m.hasState(ctx, s.step)
+	if err != nil {
+		return err
+	}
+	if !installed {
+		return fmt.Errorf("step %s, owning node pool %s, was not installed yet; run 'tectonic install' instead", s.step, s.pool)
+	}
+
+	s.tfvars, err = ioutil.ReadFile(filepath.Join(m.clusterDir, terraformVariablesFileName))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	s.configPath = configFilePath + ".scale"
+	if err := ioutil.WriteFile(s.configPath, updated, 0644); err != nil {
+		return err
+	}
+	return generateTerraformVariablesStep(m)
+}
+
+// applyStep plans the step owning the node pool and, once confirmed,
+// applies the saved plan.
+func (s *scaler) applyStep(ctx context.Context, m *State) error {
+	m.plan = true
+	err := runInstallStep(ctx, m, s.step, s.args...)
+	m.plan = false
+	if err != nil {
+		return s.restore(m, err)
+	}
+	if err := printPlanSummary(os.Stdout, m.plans); err != nil {
+		return s.restore(m, err)
+	}
+	if p := m.plans[len(m.plans)-1]; p.add == 0 && p.change == 0 && p.destroy == 0 {
+		log.Infof("Node pool %s already has %d nodes", s.pool, s.count)
+		return s.commit(m)
+	}
+
+	if m.confirm != nil {
+		ok, err := m.confirm(fmt.Sprintf("Apply these changes to step %s?", checkpointKey(s.step, s.args...)))
+		if err != nil {
+			return s.restore(m, err)
+		}
+		if !ok {
+			return s.restore(m, errScaleAborted)
+		}
+	}
+
+	m.savedPlans = true
+	err = runInstallStep(ctx, m, s.step, s.args...)
+	m.savedPlans = false
+	if err != nil {
+		return s.restore(m, err)
+	}
+	return s.commit(m)
+}
+
+// commit replaces the config of the cluster directory with the updated one,
+// once the scale is applied.
+func (s *scaler) commit(m *State) error {
+	if err := os.Rename(s.configPath, filepath.Join(m.clusterDir, configFileName)); err != nil {
+		return fmt.Errorf("failed to update the cluster config: %v", err)
+	}
+	log.Infof("Set the count of node pool %s to %d", s.pool, s.count)
+	return nil
+}
+
+// restore removes the updated config, puts back the Terraform variables of
+// the cluster as they were before the scale, and returns the error that
+// aborted it.
+func (s *scaler) restore(m *State, cause error) error {
+	if err := os.Remove(s.configPath); err != nil && !os.IsNotExist(err) {
+		log.Errorf("Failed to remove the updated cluster config: %v", err)
+	}
+	tfvarsPath := filepath.Join(m.clusterDir, terraformVariablesFileName)
+	var err error
+	if s.tfvars == nil {
+		err = os.Remove(tfvarsPath)
+	} else {
+		err = ioutil.WriteFile(tfvarsPath, s.tfvars, 0644)
+	}
+	if err != nil {
+		log.Errorf("Failed to restore the Terraform variables: %v", err)
+	}
+	return cause
+}
+
+// nodePoolStep returns the Terraform step creating the nodes of the given
+// pool, along with the arguments it is applied with.
+func nodePoolStep(c config.Cluster, pool string) (string, []string, error) {
+	if _, ok := c.NodePools.Map()[pool]; !ok {
+		var names []string
+		for _, p := range c.NodePools {
+			names = append(names, p.Name)
+		}
+		return "", nil, fmt.Errorf("no node pool named %q; the node pools are: %s", pool, strings.Join(names, ", "))
+	}
+	owners := []struct {
+		pools []string
+		step  string
+		args  []string
+	}{
+		{pools: c.Worker.NodePools, step: joinWorkersStep},
+		{pools: c.Master.NodePools, step: mastersStep, args: []string{bootstrapOff}},
+		{pools: c.Etcd.NodePools, step: etcdStep},
+	}
+	for _, o := range owners {
+		for _, p := range o.pools {
+			if p == pool {
+				return o.step, o.args, nil
+			}
+		}
+	}
+	return "", nil, fmt.Errorf("node pool %s is not used by the etcd, master or worker nodes", pool)
+}
+
+// setNodePoolCount returns the given config with the count of the named node
+// pool set. The config is edited in place, so that its comments and layout
+// are kept, then parsed again to ensure that the edit changed the count of
+// the pool and nothing else, whatever YAML constructs, e.g. anchors, the edit
+// did not account for.
+func setNodePoolCount(content []byte, pool string, count int) ([]byte, error) {
+	updated, err := editNodePoolCount(content, pool, count)
+	if err != nil {
+		return nil, err
+	}
+	original, err := config.ParseConfigLenient(content)
+	if err != nil {
+		return nil, err
+	}
+	cluster, err := config.ParseConfigLenient(updated)
+	if err != nil {
+		return nil, fmt.Errorf("setting the count of node pool %s made the config invalid: %v", pool, err)
+	}
+	pools := append(config.NodePools(nil), original.NodePools...)
+	for i := range pools {
+		if pools[i].Name == pool {
+			pools[i].Count = count
+		}
+	}
+	original.NodePools = pools
+	if !reflect.DeepEqual(original, cluster) {
+		return nil, fmt.Errorf("setting the count of node pool %s would change more than its count; edit the config by hand", pool)
+	}
+	return updated, nil
+}
+
+// editNodePoolCount returns the given config with the line of the count of
+// the named node pool replaced, or added below its name.
+func editNodePoolCount(content []byte, pool string, count int) ([]byte, error) {
+	lines := strings.Split(string(content), "\n")
+	start := -1
+	for i, line := range lines {
+		if nodePoolsKeyRegexp.MatchString(line) {
+			start = i
+			break
+		}
+	}
+	if start == -1 {
+		return nil, errors.New("no nodePools found")
+	}
+
+	// nameLine and countLine are the lines of the fields of the pool, and
+	// itemName the name of the pool the current item defines.
+	nameLine, countLine := -1, -1
+	var itemName string
+	itemNameLine, itemCountLine := -1, -1
+	endItem := func() {
+		if itemName == pool && nameLine == -1 {
+			nameLine, countLine = itemNameLine, itemCountLine
+		}
+		itemName, itemNameLine, itemCountLine = "", -1, -1
+	}
+	for i := start + 1; i < len(lines); i++ {
+		line := lines[i]
+		if topLevelYAMLKeyRegexp.MatchString(line) {
+			break
+		}
+		if nodePoolItemRegexp.MatchString(line) {
+			endItem()
+		}
+		m := nodePoolFieldRegexp.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		switch m[2] {
+		case "name":
+			itemName, itemNameLine = strings.Trim(m[3], `"'`), i
+		case "count":
+			itemCountLine = i
+		}
+	}
+	endItem()
+	if nameLine == -1 {
+		return nil, fmt.Errorf("no node pool named %q found", pool)
+	}
+
+	value := strconv.Itoa(count)
+	if countLine != -1 {
+		m := nodePoolFieldRegexp.FindStringSubmatch(lines[countLine])
+		line := m[1] + "count: " + value
+		if m[4] != "" {
+			line += " " + m[4]
+		}
+		lines[countLine] = line
+		return []byte(strings.Join(lines, "\n")), nil
+	}
+
+	// The pool has no count yet: add one below its name.
+	prefix := nodePoolFieldRegexp.FindStringSubmatch(lines[nameLine])[1]
+	indent := strings.Repeat(" ", len(prefix))
+	lines = append(lines[:nameLine+1], append([]string{indent + "count: " + value}, lines[nameLine+1:]...)...)
+	return []byte(strings.Join(lines, "\n")), nil
+}
@@ -0,0 +1,169 @@
+package workflow
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+func TestSetNodePoolCount(t *testing.T) {
+	testCases := []struct {
+		test          string
+		config        string
+		pool          string
+		expected      string
+		expectedError bool
+	}{
+		{
+			test:     "count after name",
+			config:   "nodePools:\n  - name: etcd\n    count: 3\n  - name: worker\n    count: 3 # workers\nplatform: aws\n",
+			pool:     "worker",
+			expected: "nodePools:\n  - name: etcd\n    count: 3\n  - name: worker\n    count: 5 # workers\nplatform: aws\n",
+		},
+		{
+			test:     "count before name, with comments",
+			config:   "nodePools:\n    # The number of etcd nodes.\n  - count: 3\n    name: etcd\n\n    # The number of workers.\n  - count: 2\n    name: \"worker\"\n\nworker:\n  nodePools:\n    - worker\n",
+			pool:     "worker",
+			expected: "nodePools:\n    # The number of etcd nodes.\n  - count: 3\n    name: etcd\n\n    # The number of workers.\n  - count: 5\n    name: \"worker\"\n\nworker:\n  nodePools:\n    - worker\n",
+		},
+		{
+			test:     "no count",
+			config:   "nodePools:\n  - name: worker\n",
+			pool:     "worker",
+			expected: "nodePools:\n  - name: worker\n    count: 5\n",
+		},
+		{
+			test:          "unknown pool",
+			config:        "nodePools:\n  - name: worker\n    count: 3\nworker:\n  nodePools:\n    - name: other\n",
+			pool:          "other",
+			expectedError: true,
+		},
+		{
+			test:          "count shared through an anchor",
+			config:        "nodePools:\n  - &pool\n    name: worker\n    count: 3\n  - <<: *pool\n    name: other\n",
+			pool:          "worker",
+			expectedError: true,
+		},
+		{
+			test:          "no node pools",
+			config:        "worker:\n  nodePools:\n    - worker\n",
+			pool:          "worker",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		updated, err := setNodePoolCount([]byte(tc.config), tc.pool, 5)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		if !tc.expectedError && string(updated) != tc.expected {
+			t.Errorf("Test case %s: expected %q, got %q", tc.test, tc.expected, string(updated))
+		}
+	}
+}
+
+func TestScaleWorkflow(t *testing.T) {
+	testCases := []struct {
+		test          string
+		pool          string
+		count         int
+		confirmed     bool
+		failApply     bool
+		expectedApply []string
+		expectedError bool
+	}{
+		{
+			test:          "workers",
+			pool:          "worker",
+			count:         5,
+			confirmed:     true,
+			expectedApply: []string{joinWorkersStep},
+		},
+		{
+			test:          "not confirmed",
+			pool:          "worker",
+			count:         5,
+			expectedError: true,
+		},
+		{
+			test:          "failed apply",
+			pool:          "worker",
+			count:         5,
+			confirmed:     true,
+			failApply:     true,
+			expectedApply: []string{joinWorkersStep},
+			expectedError: true,
+		},
+		{
+			test:          "even etcd count",
+			pool:          "etcd",
+			count:         4,
+			confirmed:     true,
+			expectedApply: []string{etcdStep},
+		},
+		{
+			test:          "unknown pool",
+			pool:          "gpu",
+			count:         1,
+			confirmed:     true,
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		clusterDir, stepsDir := newTestClusterDir(t)
+		for _, step := range []string{etcdStep, joinWorkersStep} {
+			if err := ioutil.WriteFile(filepath.Join(clusterDir, stateFileName(step)), []byte(`{"version": 3, "modules": []}`), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		before, err := ioutil.ReadFile(filepath.Join(clusterDir, configFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		r := newFakeRunner()
+		if tc.failApply {
+			r.FailOn("apply-plan", "", errors.New("apply failed"))
+		}
+		w := ScaleWorkflow(clusterDir, tc.pool, tc.count)
+		w.SetConfirm(func(string) (bool, error) {
+			// The config is only changed once the scale is applied.
+			if current, _ := ioutil.ReadFile(filepath.Join(clusterDir, configFileName)); string(current) != string(before) {
+				t.Errorf("Test case %s: expected the config to be unchanged until the scale is applied", tc.test)
+			}
+			return tc.confirmed, nil
+		})
+		err = runWithFake(w, r, stepsDir)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+		}
+		if applied := commands(r.Calls(), "apply-plan"); len(applied) != len(tc.expectedApply) || (len(applied) != 0 && !strings.HasPrefix(applied[0], tc.expectedApply[0]+" ")) {
+			t.Errorf("Test case %s: expected the saved plans of %v to be applied, got %v", tc.test, tc.expectedApply, applied)
+		}
+
+		if _, err := os.Stat(filepath.Join(clusterDir, configFileName+".scale")); !os.IsNotExist(err) {
+			t.Errorf("Test case %s: expected no updated config to be left behind, got: %v", tc.test, err)
+		}
+		cluster, err := config.ParseConfigFile(filepath.Join(clusterDir, configFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.expectedError {
+			after, _ := ioutil.ReadFile(filepath.Join(clusterDir, configFileName))
+			if string(after) != string(before) {
+				t.Errorf("Test case %s: expected the config to be left unchanged", tc.test)
+			}
+		} else if n := cluster.NodeCount([]string{tc.pool}); n != tc.count {
+			t.Errorf("Test case %s: expected node pool %s to have %d nodes, got %d", tc.test, tc.pool, tc.count, n)
+		}
+		os.RemoveAll(filepath.Dir(clusterDir))
+	}
+}
@@ -0,0 +1,133 @@
+package workflow
+
+import (
+	"fmt"
+	"strings"
+)
+
+// StepSelection restricts a workflow to a subset of its steps, by name.
+// Setup steps, which load the cluster config, are always kept.
+type StepSelection struct {
+	// From drops the steps before the named one.
+	From string
+	// To drops the steps after the named one.
+	To string
+	// Only keeps the named steps only.
+	Only []string
+	// Skip drops the named steps.
+	Skip []string
+}
+
+// Select restricts the workflow to the steps matched by the given selection.
+// An error is returned if the selection refers to a step that is not part of
+// the workflow.
+func (w *Workflow) Select(s StepSelection) error {
+	names := make(map[string]int)
+	for i, step := range w.steps {
+		if step.setup {
+			continue
+		}
+		if _, ok := names[step.Name()]; !ok {
+			names[step.Name()] = i
+		}
+	}
+
+	check := func(flag, name string) error {
+		if _, ok := names[name]; !ok {
+			return fmt.Errorf("unknown step %q given to --%s; valid steps are: %s", name, flag, strings.Join(w.selectableSteps(), ", "))
+		}
+		return nil
+	}
+
+	from, to := 0, len(w.steps)-1
+	if s.From != "" {
+		if err := check("from", s.From); err != nil {
+			return err
+		}
+		from = names[s.From]
+	}
+	if s.To != "" {
+		if err := check("to", s.To); err != nil {
+			return err
+		}
+		to = names[s.To]
+	}
+	if from > to {
+		return fmt.Errorf("step %q given to --from runs after step %q given to --to", s.From, s.To)
+	}
+
+	only := make(map[string]bool)
+	for _, name := range s.Only {
+		if err := check("only", name); err != nil {
+			return err
+		}
+		only[name] = true
+	}
+	skip := make(map[string]bool)
+	for _, name := range s.Skip {
+		if err := check("skip", name); err != nil {
+			return err
+		}
+		skip[name] = true
+	}
+
+	kept := make(map[string]bool)
+	deps := make(map[string][]string)
+	var steps []stepNode
+	for i, step := range w.steps {
+		deps[step.Name()] = step.deps
+		if !step.setup {
+			if i < from || i > to || skip[step.Name()] {
+				continue
+			}
+			if len(only) != 0 && !only[step.Name()] {
+				continue
+			}
+		}
+		kept[step.Name()] = true
+		steps = append(steps, step)
+	}
+
+	// A step that depended on a dropped step now depends on the dropped
+	// step's own dependencies, so that the remaining steps keep their order.
+	for i := range steps {
+		steps[i].deps = inheritDeps(steps[i].deps, deps, kept)
+	}
+	w.steps = steps
+
+	return nil
+}
+
+// inheritDeps replaces the dropped steps among the given dependencies by
+// their own dependencies, recursively.
+func inheritDeps(stepDeps []string, deps map[string][]string, kept map[string]bool) []string {
+	var result []string
+	seen := make(map[string]bool)
+	var visit func(names []string)
+	visit = func(names []string) {
+		for _, name := range names {
+			if seen[name] {
+				continue
+			}
+			seen[name] = true
+			if kept[name] {
+				result = append(result, name)
+				continue
+			}
+			visit(deps[name])
+		}
+	}
+	visit(stepDeps)
+	return result
+}
+
+// selectableSteps returns the names of the steps that can be selected.
+func (w *Workflow) selectableSteps() []string {
+	var names []string
+	for _, step := range w.steps {
+		if !step.setup {
+			names = append(names, step.Name())
+		}
+	}
+	return names
+}
@@ -0,0 +1,87 @@
+package workflow
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWorkflowSelect(t *testing.T) {
+	testCases := []struct {
+		test          string
+		selection     StepSelection
+		expected      []string
+		expectedError bool
+	}{
+		{
+			test:      "empty selection",
+			selection: StepSelection{},
+			expected:  []string{"refresh-config", "tls", "assets", "topology", "etcd"},
+		},
+		{
+			test:      "from and to",
+			selection: StepSelection{From: "assets", To: "topology"},
+			expected:  []string{"refresh-config", "assets", "topology"},
+		},
+		{
+			test:      "only",
+			selection: StepSelection{Only: []string{"etcd", "tls"}},
+			expected:  []string{"refresh-config", "tls", "etcd"},
+		},
+		{
+			test:      "from and skip",
+			selection: StepSelection{From: "assets", Skip: []string{"topology"}},
+			expected:  []string{"refresh-config", "assets", "etcd"},
+		},
+		{
+			test:          "unknown step",
+			selection:     StepSelection{Only: []string{"workers"}},
+			expectedError: true,
+		},
+		{
+			test:          "setup steps cannot be selected",
+			selection:     StepSelection{From: "refresh-config"},
+			expectedError: true,
+		},
+		{
+			test:          "from after to",
+			selection:     StepSelection{From: "etcd", To: "tls"},
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		wf := Workflow{
+			steps: []stepNode{
+				newSetupStep("refresh-config", test1Step),
+				newStep("tls", test1Step),
+				newStep("assets", test1Step),
+				newStep("topology", test1Step),
+				newStep("etcd", test1Step),
+			},
+		}
+		err := wf.Select(tc.selection)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %v, got: %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		if err == nil && !reflect.DeepEqual(wf.Steps(), tc.expected) {
+			t.Errorf("Test case %s: expected steps %v, got %v", tc.test, tc.expected, wf.Steps())
+		}
+	}
+}
+
+func TestWorkflowSelectInheritsDependencies(t *testing.T) {
+	wf := Workflow{
+		steps: []stepNode{
+			newStep("a", test1Step),
+			newStep("b", test1Step, "a"),
+			newStep("c", test1Step, "b"),
+		},
+	}
+	if err := wf.Select(StepSelection{Skip: []string{"b"}}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if deps := wf.steps[1].deps; len(deps) != 1 || deps[0] != "a" {
+		t.Errorf("expected c to depend on a once b is skipped, got %v", deps)
+	}
+}
@@ -0,0 +1,251 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+// terraformStates are the names of the Terraform states kept by the steps.
+var terraformStates = []string{tlsStep, assetsStep, topologyStep, tncDNSStep, mastersStep, etcdStep, joinWorkersStep}
+
+// stateBackend stores the Terraform state of the steps, by state name.
+//
+// Terraform itself keeps and locks the states in the backend, which the
+// configuration generated for each step declares, see stepConfigDir. The
+// workflow only reads the states, e.g. to find out which steps were
+// installed, and uploads the local ones when a cluster directory is migrated
+// to the backend.
+type stateBackend interface {
+	// get returns the content of the named state, or an error satisfying
+	// os.IsNotExist if there is none.
+	get(ctx context.Context, name string) ([]byte, error)
+	// put stores the content of the named state.
+	put(ctx context.Context, name string, content []byte) error
+	// terraformBackend returns the type and the settings of the Terraform
+	// backend keeping the named state where get and put find it.
+	terraformBackend(name string) (string, map[string]interface{})
+	// String describes where the states are stored.
+	String() string
+}
+
+// newStateBackend returns the backend configured for the cluster, or nil if
+// the states are only kept in the cluster directory.
+func newStateBackend(cluster config.Cluster) (stateBackend, error) {
+	switch cluster.StateBackend.Type {
+	case "", config.StateBackendLocal:
+		return nil, nil
+	case config.StateBackendHTTP:
+		return &httpStateBackend{config: cluster.StateBackend.HTTP, client: http.DefaultClient}, nil
+	case config.StateBackendS3:
+		return newS3StateBackend(cluster.StateBackend.S3, cluster.Name)
+	}
+	return nil, fmt.Errorf("invalid state backend type %q", cluster.StateBackend.Type)
+}
+
+// stateFileName returns the name of the local file of the given state.
+func stateFileName(name string) string {
+	return fmt.Sprintf("%s.tfstate", name)
+}
+
+// hasState returns whether the given state exists, asking the configured
+// backend, if any.
+func (m *State) hasState(ctx context.Context, name string) (bool, error) {
+	b, err := newStateBackend(m.cluster)
+	if err != nil {
+		return false, err
+	}
+	if b == nil {
+		return hasStateFile(m.clusterDir, name), nil
+	}
+	_, err = b.get(ctx, name)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to look up state %s in %s: %v", name, b, err)
+	}
+	return true, nil
+}
+
+// checkStateBackend returns an error if a state of the cluster directory is
+// missing from the configured backend, e.g. because the backend was
+// configured after the steps ran: Terraform would not find the resources of
+// the state anymore, and create them again.
+func (m *State) checkStateBackend(ctx context.Context) error {
+	b, err := newStateBackend(m.cluster)
+	if err != nil || b == nil {
+		return err
+	}
+	if s3, ok := b.(*s3StateBackend); ok && s3.config.LockTable == "" {
+		log.Warnf("The states in %s are not locked; set stateBackend.s3.lockTable to keep concurrent runs from corrupting them", b)
+	}
+	for _, name := range terraformStates {
+		if !hasStateFile(m.clusterDir, name) {
+			continue
+		}
+		_, err := b.get(ctx, name)
+		if os.IsNotExist(err) {
+			return fmt.Errorf("local state %s is not in %s; run 'tectonic state migrate' to upload it, or remove it", stateFileName(name), b)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to look up state %s in %s: %v", name, b, err)
+		}
+	}
+	return nil
+}
+
+// MigrateState copies the local state of each step of the given cluster
+// directory to the state backend configured in its config. Terraform only
+// uses the states of the backend from then on; the local files are left
+// untouched. A state that already exists
+// in the backend with a different content is only overwritten if force is
+// set.
+func MigrateState(ctx context.Context, clusterDir string, force bool) error {
+	lock, err := acquireLock(clusterDir)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err := lock.release(); err != nil {
+			log.Errorf("Failed to release the cluster directory lock: %v", err)
+		}
+	}()
+
+	cluster, err := readClusterConfig(filepath.Join(clusterDir, configFileName), "", true)
+	if err != nil {
+		return err
+	}
+	b, err := newStateBackend(*cluster)
+	if err != nil {
+		return err
+	}
+	if b == nil {
+		return fmt.Errorf("no remote state backend configured in %s", filepath.Join(clusterDir, configFileName))
+	}
+
+	var migrated int
+	for _, name := range terraformStates {
+		content, err := ioutil.ReadFile(filepath.Join(clusterDir, stateFileName(name)))
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		remote, err := b.get(ctx, name)
+		switch {
+		case err == nil && bytes.Equal(remote, content):
+			log.Infof("State %s is already in %s", name, b)
+			continue
+		case err == nil && !force:
+			return fmt.Errorf("state %s already exists in %s with a different content; use --force to overwrite it", name, b)
+		case err != nil && !os.IsNotExist(err):
+			return fmt.Errorf("failed to look up state %s in %s: %v", name, b, err)
+		}
+		if err := b.put(ctx, name, content); err != nil {
+			return fmt.Errorf("failed to migrate state %s to %s: %v", name, b, err)
+		}
+		log.Infof("Migrated state %s to %s", name, b)
+		migrated++
+	}
+	if migrated == 0 {
+		log.Infof("No local state to migrate to %s", b)
+	}
+	return nil
+}
+
+// httpStateBackend stores the states behind a REST endpoint, following the
+// protocol of Terraform's http backend: each state lives at the address
+// followed by its name, is read with GET and written with POST.
+type httpStateBackend struct {
+	config config.HTTPStateBackend
+	client *http.Client
+}
+
+// String implements stateBackend.
+func (b *httpStateBackend) String() string {
+	return b.config.Address
+}
+
+// url returns the address of the given state.
+func (b *httpStateBackend) url(name string) string {
+	return strings.TrimSuffix(b.config.Address, "/") + "/" + name
+}
+
+// terraformBackend implements stateBackend. Terraform locks the state with
+// LOCK and UNLOCK requests to its address.
+func (b *httpStateBackend) terraformBackend(name string) (string, map[string]interface{}) {
+	settings := map[string]interface{}{
+		"address":        b.url(name),
+		"lock_address":   b.url(name),
+		"unlock_address": b.url(name),
+	}
+	if b.config.Username != "" {
+		settings["username"] = b.config.Username
+		settings["password"] = b.config.Password
+	}
+	return string(config.StateBackendHTTP), settings
+}
+
+// do sends a request for the given state and returns the body of the
+// response.
+func (b *httpStateBackend) do(ctx context.Context, method, name string, body []byte) (int, []byte, error) {
+	req, err := http.NewRequest(method, b.url(name), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if b.config.Username != "" {
+		req.SetBasicAuth(b.config.Username, b.config.Password)
+	}
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	return resp.StatusCode, content, nil
+}
+
+// get implements stateBackend.
+func (b *httpStateBackend) get(ctx context.Context, name string) ([]byte, error) {
+	status, content, err := b.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case status == http.StatusNotFound, status == http.StatusNoContent, status == http.StatusOK && len(content) == 0:
+		return nil, os.ErrNotExist
+	case status != http.StatusOK:
+		return nil, fmt.Errorf("unexpected HTTP response: %d %s", status, http.StatusText(status))
+	}
+	return content, nil
+}
+
+// put implements stateBackend.
+func (b *httpStateBackend) put(ctx context.Context, name string, content []byte) error {
+	status, _, err := b.do(ctx, http.MethodPost, name, content)
+	if err != nil {
+		return err
+	}
+	if status != http.StatusOK && status != http.StatusCreated && status != http.StatusNoContent {
+		return fmt.Errorf("unexpected HTTP response: %d %s", status, http.StatusText(status))
+	}
+	return nil
+}
@@ -0,0 +1,281 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+const (
+	// defaultS3Region is the region of the state bucket when neither the
+	// config nor the environment set one.
+	defaultS3Region = "us-east-1"
+	sigV4Algorithm  = "AWS4-HMAC-SHA256"
+	sigV4DateFormat = "20060102T150405Z"
+)
+
+// awsCredentials are the credentials signing the requests to S3.
+type awsCredentials struct {
+	accessKeyID     string
+	secretAccessKey string
+	sessionToken    string
+}
+
+// s3StateBackend stores the states as objects of an S3-compatible bucket,
+// under a prefix that defaults to the cluster name.
+type s3StateBackend struct {
+	config      config.S3StateBackend
+	credentials awsCredentials
+	client      *http.Client
+	now         func() time.Time
+}
+
+// newS3StateBackend returns an S3 state backend, signing its requests with
+// the credentials of the standard AWS environment variables.
+func newS3StateBackend(cfg config.S3StateBackend, clusterName string) (*s3StateBackend, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("no bucket configured for the S3 state backend")
+	}
+	if cfg.Prefix == "" {
+		cfg.Prefix = clusterName
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if cfg.Region == "" {
+		cfg.Region = defaultS3Region
+	}
+	credentials := awsCredentials{
+		accessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		secretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		sessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+	}
+	if credentials.accessKeyID == "" || credentials.secretAccessKey == "" {
+		return nil, errors.New("the S3 state backend requires the AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY environment variables")
+	}
+	return &s3StateBackend{
+		config:      cfg,
+		credentials: credentials,
+		client:      http.DefaultClient,
+		now:         time.Now,
+	}, nil
+}
+
+// String implements stateBackend.
+func (b *s3StateBackend) String() string {
+	return fmt.Sprintf("s3://%s/%s", b.config.Bucket, b.config.Prefix)
+}
+
+// url returns the URL of the object of the given state. Objects are
+// addressed with virtual-hosted-style URLs on AWS, and with path-style URLs
+// on custom endpoints, which S3-compatible stores all support.
+func (b *s3StateBackend) url(name string) (*url.URL, error) {
+	key := path.Join(b.config.Prefix, stateFileName(name))
+	if b.config.Endpoint == "" {
+		return url.Parse(fmt.Sprintf("https://%s.s3.%s.amazonaws.com/%s", b.config.Bucket, b.config.Region, key))
+	}
+	u, err := url.Parse(b.config.Endpoint)
+	if err != nil {
+		return nil, err
+	}
+	u.Path = path.Join("/", u.Path, b.config.Bucket, key)
+	return u, nil
+}
+
+// do sends a signed request for the object of the given state and returns
+// the body of the response.
+func (b *s3StateBackend) do(ctx context.Context, method, name string, body []byte) (int, []byte, error) {
+	u, err := b.url(name)
+	if err != nil {
+		return 0, nil, err
+	}
+	req, err := http.NewRequest(method, u.String(), bytes.NewReader(body))
+	if err != nil {
+		return 0, nil, err
+	}
+	req = req.WithContext(ctx)
+	if method == http.MethodPut {
+		req.Header.Set("Content-Type", "application/json")
+		if b.config.Encrypt {
+			req.Header.Set("X-Amz-Server-Side-Encryption", "AES256")
+		}
+	}
+	signV4(req, body, b.credentials, b.config.Region, "s3", b.now())
+
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	defer resp.Body.Close()
+	content, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return resp.StatusCode, nil, err
+	}
+	if resp.StatusCode >= 300 && resp.StatusCode != http.StatusNotFound {
+		return resp.StatusCode, nil, fmt.Errorf("unexpected S3 response: %s: %s", resp.Status, strings.TrimSpace(string(content)))
+	}
+	return resp.StatusCode, content, nil
+}
+
+// get implements stateBackend.
+func (b *s3StateBackend) get(ctx context.Context, name string) ([]byte, error) {
+	status, content, err := b.do(ctx, http.MethodGet, name, nil)
+	if err != nil {
+		return nil, err
+	}
+	if status == http.StatusNotFound {
+		return nil, os.ErrNotExist
+	}
+	return content, nil
+}
+
+// put implements stateBackend.
+func (b *s3StateBackend) put(ctx context.Context, name string, content []byte) error {
+	_, _, err := b.do(ctx, http.MethodPut, name, content)
+	return err
+}
+
+// terraformBackend implements stateBackend. Terraform only locks the states
+// if a DynamoDB lock table is configured.
+func (b *s3StateBackend) terraformBackend(name string) (string, map[string]interface{}) {
+	settings := map[string]interface{}{
+		"bucket": b.config.Bucket,
+		"key":    path.Join(b.config.Prefix, stateFileName(name)),
+		"region": b.config.Region,
+	}
+	if b.config.Encrypt {
+		settings["encrypt"] = true
+	}
+	if b.config.Endpoint != "" {
+		settings["endpoint"] = b.config.Endpoint
+		settings["force_path_style"] = true
+		settings["skip_credentials_validation"] = true
+		settings["skip_region_validation"] = true
+	}
+	if b.config.LockTable != "" {
+		settings["dynamodb_table"] = b.config.LockTable
+	}
+	return string(config.StateBackendS3), settings
+}
+
+// signV4 signs the request with AWS Signature Version 4, as documented in
+// https://docs.aws.amazon.com/general/latest/gr/sigv4_signing.html. All the
+// headers of the request, along with the host, are signed.
+func signV4(req *http.Request, body []byte, credentials awsCredentials, region, service string, now time.Time) {
+	now = now.UTC()
+	date := now.Format(sigV4DateFormat)
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", date)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if credentials.sessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.sessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	var names []string
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders bytes.Buffer
+	for _, name := range names {
+		fmt.Fprintf(&canonicalHeaders, "%s:%s\n", name, headers[name])
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		canonicalURI(req.URL),
+		canonicalQuery(req.URL),
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := strings.Join([]string{date[:8], region, service, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{sigV4Algorithm, date, scope, sha256Hex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date[:8])
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		sigV4Algorithm, credentials.accessKeyID, scope, signedHeaders, signature))
+}
+
+// canonicalURI returns the URI-encoded path of the URL. S3 object keys are
+// encoded once, with slashes kept.
+func canonicalURI(u *url.URL) string {
+	p := u.EscapedPath()
+	if p == "" {
+		return "/"
+	}
+	segments := strings.Split(p, "/")
+	for i, s := range segments {
+		if unescaped, err := url.PathUnescape(s); err == nil {
+			s = unescaped
+		}
+		segments[i] = uriEncode(s)
+	}
+	return strings.Join(segments, "/")
+}
+
+// canonicalQuery returns the query of the URL, with its parameters sorted
+// and URI-encoded.
+func canonicalQuery(u *url.URL) string {
+	query := u.Query()
+	var params []string
+	for name, values := range query {
+		for _, value := range values {
+			params = append(params, uriEncode(name)+"="+uriEncode(value))
+		}
+	}
+	sort.Strings(params)
+	return strings.Join(params, "&")
+}
+
+// uriEncode encodes every byte but the unreserved characters of RFC 3986,
+// as required by the signature.
+func uriEncode(s string) string {
+	var encoded bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' || c == '-' || c == '.' || c == '_' || c == '~' {
+			encoded.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+	return encoded.String()
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
@@ -0,0 +1,213 @@
+package workflow
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/tfstate"
+)
+
+// maxStatusValueLength is the length beyond which output values are
+// shortened in the text status.
+const maxStatusValueLength = 80
+
+// StepStatus is what the Terraform state of a step tells about it.
+type StepStatus struct {
+	Name    string `json:"name"`
+	Applied bool   `json:"applied"`
+	// Resources is the number of managed resources of the state.
+	Resources int `json:"resources"`
+	// Serial is incremented by Terraform each time the state changes.
+	Serial  int                    `json:"serial"`
+	Outputs map[string]interface{} `json:"outputs,omitempty"`
+}
+
+// ClusterStatus is what the Terraform states of the steps tell about a
+// cluster.
+type ClusterStatus struct {
+	Name     string `json:"name"`
+	Platform string `json:"platform"`
+	// Bootstrapped is set once the bootstrap steps were applied.
+	Bootstrapped bool `json:"bootstrapped"`
+	// BootstrapMode is set while the cluster still runs on its bootstrap
+	// node, i.e. before the masters joined it.
+	BootstrapMode bool         `json:"bootstrapMode"`
+	Steps         []StepStatus `json:"steps"`
+}
+
+// readStateFile returns the content of the named state of the cluster, from
+// the configured state backend if any, or an error satisfying os.IsNotExist
+// if there is none.
+func (m *State) readStateFile(ctx context.Context, name string) ([]byte, error) {
+	b, err := newStateBackend(m.cluster)
+	if err != nil {
+		return nil, err
+	}
+	if b != nil {
+		return b.get(ctx, name)
+	}
+	return ioutil.ReadFile(filepath.Join(m.clusterDir, stateFileName(name)))
+}
+
+// stepStatus parses the named state into the status of its step.
+func stepStatus(name string, content []byte) (StepStatus, *tfstate.State, error) {
+	status := StepStatus{Name: name, Applied: true}
+	state, err := tfstate.Parse(content)
+	if err != nil {
+		return status, nil, fmt.Errorf("invalid state %s: %v", stateFileName(name), err)
+	}
+	status.Serial = state.Serial
+	status.Resources = resourceCount(state)
+	// The ignition configs embed the cluster's private keys.
+	for output, o := range state.Outputs {
+		if o.Sensitive || strings.HasPrefix(output, "ignition") {
+			continue
+		}
+		if status.Outputs == nil {
+			status.Outputs = make(map[string]interface{})
+		}
+		status.Outputs[output] = o.Value
+	}
+	return status, state, nil
+}
+
+// resourceCount returns the number of instances of the managed resources of
+// the given state.
+func resourceCount(state *tfstate.State) int {
+	var n int
+	for _, r := range state.Resources {
+		if r.Mode == tfstate.ManagedMode {
+			n += len(r.Instances)
+		}
+	}
+	return n
+}
+
+// Status returns what the Terraform states of the steps of the given cluster
+// directory tell about the cluster. The states are read from the configured
+// state backend, if any.
+func Status(ctx context.Context, clusterDir string) (*ClusterStatus, error) {
+	cluster, err := readClusterConfig(filepath.Join(clusterDir, configFileName), "", true)
+	if err != nil {
+		return nil, err
+	}
+	m := &State{clusterDir: clusterDir, cluster: *cluster}
+	status := &ClusterStatus{Name: cluster.Name, Platform: string(cluster.Platform)}
+
+	states := make(map[string]*tfstate.State)
+	for _, name := range terraformStates {
+		content, err := m.readStateFile(ctx, name)
+		if os.IsNotExist(err) {
+			status.Steps = append(status.Steps, StepStatus{Name: name})
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read state %s: %v", name, err)
+		}
+		step, state, err := stepStatus(name, content)
+		if err != nil {
+			return nil, err
+		}
+		states[name] = state
+		status.Steps = append(status.Steps, step)
+	}
+
+	status.Bootstrapped = states[topologyStep] != nil && states[mastersStep] != nil && states[tncDNSStep] != nil
+	if status.Bootstrapped {
+		status.BootstrapMode, err = bootstrapMode(clusterDir, states[tncDNSStep])
+		if err != nil {
+			return nil, err
+		}
+	}
+	return status, nil
+}
+
+// bootstrapMode returns whether a bootstrapped cluster still runs on its
+// bootstrap node. On AWS, the TNC name points to the bootstrap bucket until
+// the masters joined; otherwise, the latest apply of the masters step tells.
+func bootstrapMode(clusterDir string, tncDNS *tfstate.State) (bool, error) {
+	for _, r := range tncDNS.ResourcesByType("aws_route53_record") {
+		switch r.Name {
+		case "tectonic_tnc_cname":
+			return true, nil
+		case "tectonic_tnc_a":
+			return false, nil
+		}
+	}
+	cp, err := loadCheckpoints(clusterDir)
+	if err != nil {
+		return false, err
+	}
+	on := cp.Steps[checkpointKey(mastersStep, bootstrapOn)]
+	off, ok := cp.Steps[checkpointKey(mastersStep, bootstrapOff)]
+	return !ok || on.Completed.After(off.Completed), nil
+}
+
+// PrintStatus writes the status of the cluster to w, as JSON or as text.
+func PrintStatus(w io.Writer, status *ClusterStatus, asJSON bool) error {
+	if asJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(status)
+	}
+
+	mode := "not bootstrapped"
+	switch {
+	case status.BootstrapMode:
+		mode = "bootstrap mode"
+	case status.Bootstrapped:
+		mode = "bootstrapped"
+	}
+	fmt.Fprintf(w, "Cluster %s (%s): %s\n\n", status.Name, status.Platform, mode)
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "STEP\tAPPLIED\tRESOURCES\tSERIAL")
+	for _, step := range status.Steps {
+		if !step.Applied {
+			fmt.Fprintf(tw, "%s\tno\t-\t-\n", step.Name)
+			continue
+		}
+		fmt.Fprintf(tw, "%s\tyes\t%d\t%d\n", step.Name, step.Resources, step.Serial)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	for _, step := range status.Steps {
+		if len(step.Outputs) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n%s outputs:\n", step.Name)
+		var names []string
+		for name := range step.Outputs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Fprintf(w, "  %s = %s\n", name, formatStatusValue(step.Outputs[name]))
+		}
+	}
+	return nil
+}
+
+// formatStatusValue returns a short, single-line form of an output value.
+func formatStatusValue(v interface{}) string {
+	s, ok := v.(string)
+	if !ok {
+		content, _ := json.Marshal(v)
+		s = string(content)
+	}
+	s = strings.Replace(s, "\n", " ", -1)
+	if len(s) > maxStatusValueLength {
+		s = s[:maxStatusValueLength-3] + "..."
+	}
+	return s
+}
@@ -0,0 +1,161 @@
+package workflow
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// backendConfigFileName is the file of the generated configuration of a step
+// declaring its backend.
+const backendConfigFileName = "backend.tf"
+
+var (
+	remoteStateRegexp     = regexp.MustCompile(`(?m)^data\s+"terraform_remote_state"\s+"[^"]+"\s*\{`)
+	localBackendRegexp    = regexp.MustCompile(`backend\s*=\s*"local"`)
+	remoteStatePathRegexp = regexp.MustCompile(`path\s*=\s*"[^"]*?([A-Za-z0-9_-]+)\.tfstate"`)
+)
+
+// stepConfigDir returns the directory of the Terraform configuration of the
+// given state, relative to the cluster directory, or the step templates
+// themselves if the states are only kept in the cluster directory.
+//
+// Terraform only takes the backend of a state from its configuration, so
+// with a remote state backend, a copy of the templates declaring it is
+// generated in the data directory of the state, see writeStepConfig.
+func (m *State) stepConfigDir(state, templateDir string) (string, error) {
+	b, err := newStateBackend(m.cluster)
+	if err != nil || b == nil {
+		return templateDir, err
+	}
+	dir := filepath.Join(dataDirName(state), "config")
+	if err := writeStepConfig(filepath.Join(m.clusterDir, dir), templateDir, state, b); err != nil {
+		return "", fmt.Errorf("failed to generate the Terraform configuration of state %s: %v", state, err)
+	}
+	return dir, nil
+}
+
+// writeStepConfig writes to dir the templates of templateDir, rewritten to
+// keep the given state in the backend, along with the backend declaration.
+// Local module sources and ${path.module} are made to point to templateDir,
+// and the local states read with terraform_remote_state are read from the
+// backend instead.
+func writeStepConfig(dir, templateDir, state string, b stateBackend) error {
+	templateDir, err := filepath.Abs(templateDir)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(dir); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, os.ModeDir|0700); err != nil {
+		return err
+	}
+	files, err := filepath.Glob(filepath.Join(templateDir, "*.tf"))
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		if filepath.Base(file) == backendConfigFileName {
+			return fmt.Errorf("template %s conflicts with the generated backend declaration", file)
+		}
+		content, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rewritten := rewriteTemplate(string(content), templateDir, b)
+		if err := ioutil.WriteFile(filepath.Join(dir, filepath.Base(file)), []byte(rewritten), 0600); err != nil {
+			return err
+		}
+	}
+
+	// The settings may hold the credentials of the backend.
+	backendType, settings := b.terraformBackend(state)
+	declaration := fmt.Sprintf("terraform {\n  backend %q {\n%s  }\n}\n", backendType, hclSettings(settings, "    "))
+	return ioutil.WriteFile(filepath.Join(dir, backendConfigFileName), []byte(declaration), 0600)
+}
+
+// localRemoteState is a terraform_remote_state block of a template, reading
+// a state of the cluster directory.
+type localRemoteState struct {
+	// start, body and end are the offsets of the block, of its body and of
+	// its end in the template.
+	start, body, end int
+	// state is the name of the state read.
+	state string
+}
+
+// localRemoteStates returns the terraform_remote_state blocks of the given
+// template content that read a state of the cluster directory, in order.
+func localRemoteStates(content string) []localRemoteState {
+	var blocks []localRemoteState
+	last := 0
+	for _, loc := range remoteStateRegexp.FindAllStringIndex(content, -1) {
+		if loc[0] < last {
+			continue
+		}
+		end := loc[1] + scanBlock(content[loc[1]:], &bytes.Buffer{})
+		block := content[loc[0]:end]
+		match := remoteStatePathRegexp.FindStringSubmatch(block)
+		if !localBackendRegexp.MatchString(block) || match == nil {
+			continue
+		}
+		blocks = append(blocks, localRemoteState{start: loc[0], body: loc[1], end: end, state: match[1]})
+		last = end
+	}
+	return blocks
+}
+
+// rewriteTemplate returns the given template content, with its references to
+// its own directory and to the local states rewritten, as writeStepConfig
+// describes.
+func rewriteTemplate(content, templateDir string, b stateBackend) string {
+	var rewritten bytes.Buffer
+	last := 0
+	for _, block := range localRemoteStates(content) {
+		backendType, settings := b.terraformBackend(block.state)
+		rewritten.WriteString(content[last:block.start])
+		fmt.Fprintf(&rewritten, "%s\n  backend = %q\n\n  config {\n%s  }\n}", content[block.start:block.body], backendType, hclSettings(settings, "    "))
+		last = block.end
+	}
+	rewritten.WriteString(content[last:])
+	content = rewritten.String()
+
+	for _, m := range localModuleRegexp.FindAllStringSubmatch(content, -1) {
+		source := hclEscape(filepath.ToSlash(filepath.Join(templateDir, m[1])))
+		content = strings.Replace(content, m[0], strings.Replace(m[0], m[1], source, 1), 1)
+	}
+	return strings.Replace(content, "${path.module}", hclEscape(filepath.ToSlash(templateDir)), -1)
+}
+
+// hclSettings returns the given settings as HCL attributes, one per line,
+// sorted by name.
+func hclSettings(settings map[string]interface{}, indent string) string {
+	var names []string
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var attributes bytes.Buffer
+	for _, name := range names {
+		value := settings[name]
+		if s, ok := value.(string); ok {
+			value = `"` + hclEscape(s) + `"`
+		}
+		fmt.Fprintf(&attributes, "%s%s = %v\n", indent, name, value)
+	}
+	return attributes.String()
+}
+
+// hclEscape escapes the given value for an HCL string, in which it must not
+// be interpolated.
+func hclEscape(value string) string {
+	quoted := strconv.Quote(value)
+	return strings.Replace(quoted[1:len(quoted)-1], "${", "$${", -1)
+}
@@ -0,0 +1,93 @@
+package workflow
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+func TestRewriteTemplate(t *testing.T) {
+	b := &httpStateBackend{config: config.HTTPStateBackend{Address: "https://state.example.com/c/"}}
+	testCases := []struct {
+		test     string
+		content  string
+		expected string
+	}{
+		{
+			test:     "local module",
+			content:  "module \"etcd\" {\n  source = \"../../../modules/aws/etcd\"\n}\n",
+			expected: "module \"etcd\" {\n  source = \"/src/modules/aws/etcd\"\n}\n",
+		},
+		{
+			test:     "remote module",
+			content:  "module \"vpc\" {\n  source = \"github.com/coreos/vpc\"\n}\n",
+			expected: "module \"vpc\" {\n  source = \"github.com/coreos/vpc\"\n}\n",
+		},
+		{
+			test:     "module path",
+			content:  "locals {\n  script = \"${file(\"${path.module}/resources/init.sh\")}\"\n}\n",
+			expected: "locals {\n  script = \"${file(\"/src/steps/etcd/aws/resources/init.sh\")}\"\n}\n",
+		},
+		{
+			test:     "local remote state",
+			content:  "data \"terraform_remote_state\" \"topology\" {\n  backend = \"local\"\n\n  config {\n    path = \"${path.cwd}/topology.tfstate\"\n  }\n}\n\nlocals {\n  sg_id = \"${data.terraform_remote_state.topology.etcd_sg_id}\"\n}\n",
+			expected: "data \"terraform_remote_state\" \"topology\" {\n  backend = \"http\"\n\n  config {\n    address = \"https://state.example.com/c/topology\"\n    lock_address = \"https://state.example.com/c/topology\"\n    unlock_address = \"https://state.example.com/c/topology\"\n  }\n}\n\nlocals {\n  sg_id = \"${data.terraform_remote_state.topology.etcd_sg_id}\"\n}\n",
+		},
+		{
+			test:     "other remote state",
+			content:  "data \"terraform_remote_state\" \"vpc\" {\n  backend = \"s3\"\n\n  config {\n    key = \"vpc.tfstate\"\n  }\n}\n",
+			expected: "data \"terraform_remote_state\" \"vpc\" {\n  backend = \"s3\"\n\n  config {\n    key = \"vpc.tfstate\"\n  }\n}\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		if got := rewriteTemplate(tc.content, "/src/steps/etcd/aws", b); got != tc.expected {
+			t.Errorf("Test case %s: expected:\n%s\ngot:\n%s", tc.test, tc.expected, got)
+		}
+	}
+}
+
+func TestWriteStepConfig(t *testing.T) {
+	dir, err := ioutil.TempDir("", "stepconfig")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	writeTemplates(t, dir, map[string]string{
+		"steps/tls/main.tf": "resource \"tls_private_key\" \"ca\" {}\n",
+	})
+	b := &s3StateBackend{config: config.S3StateBackend{Bucket: "states", Prefix: "mycluster", Region: "eu-west-1", LockTable: "locks"}}
+
+	configDir := filepath.Join(dir, "config")
+	if err := writeStepConfig(configDir, filepath.Join(dir, "steps", "tls"), tlsStep, b); err != nil {
+		t.Fatalf("expected the configuration to be generated, got: %v", err)
+	}
+	main, err := ioutil.ReadFile(filepath.Join(configDir, "main.tf"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(main) != "resource \"tls_private_key\" \"ca\" {}\n" {
+		t.Errorf("expected main.tf to be copied, got:\n%s", main)
+	}
+	backend, err := ioutil.ReadFile(filepath.Join(configDir, backendConfigFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := "terraform {\n  backend \"s3\" {\n    bucket = \"states\"\n    dynamodb_table = \"locks\"\n    key = \"mycluster/tls.tfstate\"\n    region = \"eu-west-1\"\n  }\n}\n"
+	if string(backend) != expected {
+		t.Errorf("expected the backend declaration:\n%s\ngot:\n%s", expected, backend)
+	}
+}
+
+func TestHCLEscape(t *testing.T) {
+	if got, expected := hclEscape(`p"a\ss${word}`), `p\"a\\ss$${word}`; got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+	if strings.Contains(hclSettings(map[string]interface{}{"encrypt": true}, ""), `"true"`) {
+		t.Error("expected booleans to be left unquoted")
+	}
+}
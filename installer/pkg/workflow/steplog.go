@@ -0,0 +1,323 @@
+package workflow
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+const (
+	logsPath          = "logs"
+	logIndexFileName  = "index.json"
+	logTimeFormat     = "20060102-150405"
+	maxLogIndexLength = 100
+	redacted          = "[REDACTED]"
+)
+
+var (
+	privateKeyRegexp      = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----.*?-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+	privateKeyBeginRegexp = regexp.MustCompile(`-----BEGIN [A-Z0-9 ]*PRIVATE KEY-----`)
+	privateKeyEndRegexp   = regexp.MustCompile(`-----END [A-Z0-9 ]*PRIVATE KEY-----`)
+)
+
+// redactor removes secrets from output, line by line. Private keys printed
+// over several lines are removed entirely.
+type redactor struct {
+	secrets []string
+	inKey   bool
+}
+
+// newRedactor returns a redactor of the given secrets. Their JSON-escaped
+// forms, as Terraform prints them in its diffs, are redacted as well.
+func newRedactor(secrets ...string) *redactor {
+	r := &redactor{}
+	seen := make(map[string]bool)
+	for _, secret := range secrets {
+		secret = strings.TrimSpace(secret)
+		if secret == "" {
+			continue
+		}
+		escaped, _ := json.Marshal(secret)
+		for _, s := range []string{secret, strings.Trim(string(escaped), `"`)} {
+			if !seen[s] {
+				seen[s] = true
+				r.secrets = append(r.secrets, s)
+			}
+		}
+	}
+	return r
+}
+
+// line returns the given line of output, without its secrets.
+func (r *redactor) line(line string) string {
+	if r.inKey {
+		if loc := privateKeyEndRegexp.FindStringIndex(line); loc != nil {
+			r.inKey = false
+			return redacted + r.line(line[loc[1]:])
+		}
+		return ""
+	}
+
+	line = privateKeyRegexp.ReplaceAllString(line, redacted)
+	if loc := privateKeyBeginRegexp.FindStringIndex(line); loc != nil {
+		r.inKey = true
+		line = line[:loc[0]]
+	}
+	for _, secret := range r.secrets {
+		line = strings.Replace(line, secret, redacted, -1)
+	}
+	return line
+}
+
+// logIndexEntry describes a step log.
+type logIndexEntry struct {
+	Step     string    `json:"step"`
+	File     string    `json:"file"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Error    string    `json:"error,omitempty"`
+}
+
+// stepLog captures the Terraform output of a workflow step into its own file
+// of the logs directory, without secrets. The file is only created once
+// there is output to write.
+type stepLog struct {
+	mu       sync.Mutex
+	m        *State
+	step     string
+	started  time.Time
+	path     string
+	file     *os.File
+	buf      bytes.Buffer
+	redactor *redactor
+	err      error
+}
+
+type stepLogKey struct{}
+
+// withStepLog returns a context carrying the log of the given step.
+func withStepLog(ctx context.Context, l *stepLog) context.Context {
+	return context.WithValue(ctx, stepLogKey{}, l)
+}
+
+// stepLogWriter returns the log of the step running in the given context,
+// if any.
+func stepLogWriter(ctx context.Context) io.Writer {
+	if l, ok := ctx.Value(stepLogKey{}).(*stepLog); ok {
+		return l
+	}
+	return nil
+}
+
+// pullSecretCredentials returns the credentials of the registries of the given
+// pull secret, so that they are redacted wherever they appear, and not only
+// as part of the whole file. The password encoded in each auth token is
+// returned as well.
+func pullSecretCredentials(content []byte) []string {
+	var pullSecret struct {
+		Auths map[string]map[string]interface{} `json:"auths"`
+	}
+	if err := json.Unmarshal(content, &pullSecret); err != nil {
+		return nil
+	}
+	var credentials []string
+	for _, auth := range pullSecret.Auths {
+		for _, key := range []string{"auth", "password", "identitytoken", "registrytoken"} {
+			value, ok := auth[key].(string)
+			if !ok || value == "" {
+				continue
+			}
+			credentials = append(credentials, value)
+			if key != "auth" {
+				continue
+			}
+			if decoded, err := base64.StdEncoding.DecodeString(value); err == nil {
+				if i := bytes.IndexByte(decoded, ':'); i >= 0 {
+					credentials = append(credentials, string(decoded[i+1:]))
+				}
+			}
+		}
+	}
+	sort.Strings(credentials)
+	return credentials
+}
+
+// newStepLog returns the log of the given step of the workflow.
+func (m *State) newStepLog(step string) *stepLog {
+	secrets := []string{m.cluster.Admin.Password}
+	if m.cluster.PullSecretPath != "" {
+		if content, err := ioutil.ReadFile(m.cluster.PullSecretPath); err == nil {
+			secrets = append(secrets, string(content))
+			secrets = append(secrets, pullSecretCredentials(content)...)
+		}
+	}
+	started := time.Now().UTC()
+	return &stepLog{
+		m:        m,
+		step:     step,
+		started:  started,
+		path:     filepath.Join(logsPath, fmt.Sprintf("%s-%s.log", step, started.Format(logTimeFormat))),
+		redactor: newRedactor(secrets...),
+	}
+}
+
+// Write implements io.Writer. Complete lines are redacted and written to the
+// log file; failures to do so are reported once the step is over, but never
+// fail the step.
+func (l *stepLog) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf.Write(p)
+	for {
+		i := bytes.IndexByte(l.buf.Bytes(), '\n')
+		if i < 0 {
+			break
+		}
+		line := string(l.buf.Next(i + 1))
+		l.writeLine(strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// writeLine writes a single line to the log file, creating it if needed.
+func (l *stepLog) writeLine(line string) {
+	if l.err != nil {
+		return
+	}
+	if l.file == nil {
+		if err := os.MkdirAll(filepath.Join(l.m.clusterDir, logsPath), os.ModeDir|0755); err != nil {
+			l.err = err
+			return
+		}
+		l.file, l.err = os.OpenFile(filepath.Join(l.m.clusterDir, l.path), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if l.err != nil {
+			return
+		}
+	}
+	_, l.err = fmt.Fprintln(l.file, l.redactor.line(line))
+}
+
+// close flushes and closes the log file, then records it in the index along
+// with the result of the step.
+func (l *stepLog) close(stepErr error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.buf.Len() != 0 {
+		l.writeLine(l.buf.String())
+		l.buf.Reset()
+	}
+	if l.file == nil {
+		if l.err != nil {
+			log.Warnf("Failed to write the log of step %s: %v", l.step, l.err)
+		}
+		return
+	}
+	if err := l.file.Close(); err != nil && l.err == nil {
+		l.err = err
+	}
+	if l.err != nil {
+		log.Warnf("Failed to write the log of step %s: %v", l.step, l.err)
+	}
+
+	entry := logIndexEntry{
+		Step:     l.step,
+		File:     l.path,
+		Started:  l.started,
+		Finished: time.Now().UTC(),
+	}
+	if stepErr != nil {
+		entry.Error = l.redactor.line(stepErr.Error())
+	}
+	err := l.m.withLock(func() error {
+		return appendLogIndex(l.m.clusterDir, entry)
+	})
+	if err != nil {
+		log.Warnf("Failed to update the log index: %v", err)
+	}
+}
+
+// readLogIndex returns the entries of the log index, oldest first.
+func readLogIndex(clusterDir string) ([]logIndexEntry, error) {
+	content, err := ioutil.ReadFile(filepath.Join(clusterDir, logsPath, logIndexFileName))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []logIndexEntry
+	if err := json.Unmarshal(content, &entries); err != nil {
+		return nil, fmt.Errorf("invalid log index: %v", err)
+	}
+	return entries, nil
+}
+
+// appendLogIndex adds an entry to the log index. Only the latest entries are
+// kept; the log files of the older ones are removed.
+func appendLogIndex(clusterDir string, entry logIndexEntry) error {
+	entries, err := readLogIndex(clusterDir)
+	if err != nil {
+		return err
+	}
+	entries = append(entries, entry)
+	if len(entries) > maxLogIndexLength {
+		for _, old := range entries[:len(entries)-maxLogIndexLength] {
+			if err := os.Remove(filepath.Join(clusterDir, old.File)); err != nil && !os.IsNotExist(err) {
+				log.Warnf("Failed to remove old log %s: %v", old.File, err)
+			}
+		}
+		entries = entries[len(entries)-maxLogIndexLength:]
+	}
+
+	content, err := json.MarshalIndent(entries, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(clusterDir, logsPath, logIndexFileName)
+	if err := ioutil.WriteFile(path+".tmp", content, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// ShowStepLog writes the latest log of the named step of the given cluster
+// directory to w.
+func ShowStepLog(w io.Writer, clusterDir, step string) error {
+	entries, err := readLogIndex(clusterDir)
+	if err != nil {
+		return err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		entry := entries[i]
+		if entry.Step != step {
+			continue
+		}
+		f, err := os.Open(filepath.Join(clusterDir, entry.File))
+		if err != nil {
+			return fmt.Errorf("failed to open log of step %s: %v", step, err)
+		}
+		defer f.Close()
+
+		status := "succeeded"
+		if entry.Error != "" {
+			status = "failed: " + entry.Error
+		}
+		fmt.Fprintf(w, "# %s, started %s, %s\n", entry.File, entry.Started.Format(time.RFC3339), status)
+		_, err = io.Copy(w, f)
+		return err
+	}
+	return fmt.Errorf("no logs recorded for step %q", step)
+}
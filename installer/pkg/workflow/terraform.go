@@ -1,26 +1,137 @@
 package workflow
 
 import (
+	"bytes"
+	"context"
 	"fmt"
+	"io"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"syscall"
 )
 
-func terraformExec(clusterDir string, args ...string) error {
+// terraformError is returned when a TerraForm command fails. It carries the
+// error output of the command, so that the failure can be classified.
+type terraformError struct {
+	err    error
+	stderr string
+}
+
+// terraformError implements the error interface.
+func (e *terraformError) Error() string {
+	return fmt.Sprintf("Failed to run Terraform: %s", e.err)
+}
+
+// Runner runs the Terraform commands of the workflow steps in a cluster
+// directory. Each step keeps its state in its own state file, named after
+// the given state, unless its templates declare a backend, which Terraform
+// then keeps the state in instead.
+type Runner interface {
+	// Init initializes the Terraform data directory of the state for the
+	// given templates.
+	Init(ctx context.Context, clusterDir, state, templateDir string) error
+	// Apply applies the templates to the state.
+	Apply(ctx context.Context, clusterDir, state, templateDir string, extraArgs ...string) error
+	// Destroy destroys the resources of the state.
+	Destroy(ctx context.Context, clusterDir, state, templateDir string, extraArgs ...string) error
+	// Plan saves the plan of the matching apply or destroy to planFile and
+	// returns its human-readable output.
+	Plan(ctx context.Context, clusterDir, state, templateDir, planFile string, destroy bool, extraArgs ...string) (string, error)
+	// ApplyPlan applies a plan saved by Plan, then removes it.
+	ApplyPlan(ctx context.Context, clusterDir, state, planFile string) error
+	// Output returns the value of the named output of the state, or all its
+	// outputs if name is empty, as JSON.
+	Output(ctx context.Context, clusterDir, state, name string) (string, error)
+}
+
+// SetRunner sets the runner of the Terraform commands of the workflow.
+// By default, the Terraform binary is run.
+func (w *Workflow) SetRunner(r Runner) {
+	w.state.runner = r
+}
+
+// SetTerraformBinary sets the Terraform binary run by the default runner,
+// instead of looking it up in the executable's folder, the cwd and the PATH.
+func (w *Workflow) SetTerraformBinary(path string) {
+	w.state.terraformBinary = path
+}
+
+// terraform returns the runner of the Terraform commands of the workflow.
+func (m *State) terraform() Runner {
+	m.withLock(func() error {
+		if m.runner == nil {
+			r := &terraformRunner{binaryPath: m.terraformBinary}
+			if m.offline {
+				r.pluginDir = m.pluginDir
+			}
+			m.runner = r
+		}
+		return nil
+	})
+	return m.runner
+}
+
+// terraformRunner runs the Terraform binary. Its version is checked by the
+// workflow before any step runs, see checkTerraform.
+type terraformRunner struct {
+	// binaryPath is the Terraform binary to run; by default, it is looked up.
+	binaryPath string
+	// pluginDir, if set, holds the providers used instead of downloading
+	// them, without any network access.
+	pluginDir string
+}
+
+// executor returns an executor of the Terraform binary. The commands of a
+// state use their own data directory, so that the steps running concurrently
+// never share the modules and plugins that init writes.
+func (r *terraformRunner) executor(ctx context.Context, clusterDir, state string) (*executor, error) {
+	ex, err := newExecutor(r.binaryPath)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create Terraform executor: %s", err)
+	}
+	if r.pluginDir != "" {
+		// Don't let Terraform check for new versions of itself.
+		ex.env = append(ex.env, "CHECKPOINT_DISABLE=1")
+	}
+	ex.env = append(ex.env, "TF_DATA_DIR="+dataDirName(state))
+	return ex, nil
+}
+
+// dataDirName returns the name of the Terraform data directory of the given
+// state, relative to the cluster directory.
+func dataDirName(state string) string {
+	return fmt.Sprintf(".terraform-%s", state)
+}
+
+func (r *terraformRunner) exec(ctx context.Context, clusterDir, state string, args ...string) error {
 	// Create an executor
-	ex, err := newExecutor()
+	ex, err := r.executor(ctx, clusterDir, state)
 	if err != nil {
-		return fmt.Errorf("Could not create Terraform executor: %s", err)
+		return err
 	}
+	teeStepLog(ctx, ex)
+	var stderr bytes.Buffer
+	ex.stderr = io.MultiWriter(ex.stderr, &stderr)
 
-	err = ex.execute(clusterDir, args...)
+	err = ex.execute(ctx, clusterDir, args...)
 	if err != nil {
-		return fmt.Errorf("Failed to run Terraform: %s", err)
+		return &terraformError{err: err, stderr: stderr.String()}
 	}
 	return nil
 }
 
-func tfApply(clusterDir string, state string, templateDir string, extraArgs ...string) error {
+// teeStepLog copies the output of the executor to the log of the step
+// running in the given context, if any.
+func teeStepLog(ctx context.Context, ex *executor) {
+	if w := stepLogWriter(ctx); w != nil {
+		ex.stdout = io.MultiWriter(ex.stdout, w)
+		ex.stderr = io.MultiWriter(ex.stderr, w)
+	}
+}
+
+// Apply implements Runner.
+func (r *terraformRunner) Apply(ctx context.Context, clusterDir, state, templateDir string, extraArgs ...string) error {
 	defaultArgs := []string{
 		"apply",
 		"-auto-approve",
@@ -28,10 +139,11 @@ func tfApply(clusterDir string, state string, templateDir string, extraArgs ...s
 	}
 	extraArgs = append(extraArgs, templateDir)
 	args := append(defaultArgs, extraArgs...)
-	return terraformExec(clusterDir, args...)
+	return r.exec(ctx, clusterDir, state, args...)
 }
 
-func tfDestroy(clusterDir, state, templateDir string, extraArgs ...string) error {
+// Destroy implements Runner.
+func (r *terraformRunner) Destroy(ctx context.Context, clusterDir, state, templateDir string, extraArgs ...string) error {
 	defaultArgs := []string{
 		"destroy",
 		"-force",
@@ -39,11 +151,91 @@ func tfDestroy(clusterDir, state, templateDir string, extraArgs ...string) error
 	}
 	extraArgs = append(extraArgs, templateDir)
 	args := append(defaultArgs, extraArgs...)
-	return terraformExec(clusterDir, args...)
+	return r.exec(ctx, clusterDir, state, args...)
+}
+
+// Plan runs terraform plan with the same arguments as the matching apply or
+// destroy, saving the plan to planFile. The plan output is returned so that
+// its summary can be parsed.
+func (r *terraformRunner) Plan(ctx context.Context, clusterDir, state, templateDir, planFile string, destroy bool, extraArgs ...string) (string, error) {
+	ex, err := r.executor(ctx, clusterDir, state)
+	if err != nil {
+		return "", err
+	}
+	teeStepLog(ctx, ex)
+	var output bytes.Buffer
+	ex.stdout = io.MultiWriter(ex.stdout, &output)
+
+	args := []string{
+		"plan",
+		"-detailed-exitcode",
+		fmt.Sprintf("-state=%s.tfstate", state),
+		fmt.Sprintf("-out=%s", planFile),
+	}
+	if destroy {
+		args = append(args, "-destroy")
+	}
+	args = append(args, extraArgs...)
+	args = append(args, templateDir)
+
+	// With -detailed-exitcode, terraform exits with 2 when the plan succeeded
+	// and contains changes.
+	err = ex.execute(ctx, clusterDir, args...)
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.ExitStatus() == 2 {
+			err = nil
+		}
+	}
+	if err != nil {
+		return "", fmt.Errorf("Failed to run Terraform: %s", err)
+	}
+	return output.String(), nil
 }
 
-func tfInit(clusterDir, templateDir string) error {
-	return terraformExec(clusterDir, "init", templateDir)
+// ApplyPlan implements Runner.
+func (r *terraformRunner) ApplyPlan(ctx context.Context, clusterDir, state, planFile string) error {
+	if _, err := os.Stat(filepath.Join(clusterDir, planFile)); err != nil {
+		return fmt.Errorf("no saved plan for step %s: %v", state, err)
+	}
+	if err := r.exec(ctx, clusterDir, state, "apply", fmt.Sprintf("-state=%s.tfstate", state), planFile); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(clusterDir, planFile))
+}
+
+// Init implements Runner.
+func (r *terraformRunner) Init(ctx context.Context, clusterDir, state, templateDir string) error {
+	// The backend declared by the templates, if any, is the one the state
+	// is kept in: its settings may change between runs, e.g. a rotated
+	// password, without the state moving.
+	args := []string{"init", "-input=false", "-reconfigure"}
+	if r.pluginDir != "" {
+		args = append(args, "-plugin-dir="+r.pluginDir, "-get-plugins=false", "-upgrade=false")
+	}
+	return r.exec(ctx, clusterDir, state, append(args, templateDir)...)
+}
+
+// Output implements Runner.
+func (r *terraformRunner) Output(ctx context.Context, clusterDir, state, name string) (string, error) {
+	ex, err := r.executor(ctx, clusterDir, state)
+	if err != nil {
+		return "", err
+	}
+	var output bytes.Buffer
+	ex.stdout = &output
+
+	args := []string{
+		"output",
+		"-json",
+		fmt.Sprintf("-state=%s.tfstate", state),
+	}
+	if name != "" {
+		args = append(args, name)
+	}
+	if err := ex.execute(ctx, clusterDir, args...); err != nil {
+		return "", fmt.Errorf("Failed to run Terraform: %s", err)
+	}
+	return output.String(), nil
 }
 
 func hasStateFile(stateDir string, stateName string) bool {
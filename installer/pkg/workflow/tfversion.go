@@ -0,0 +1,171 @@
+package workflow
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	log "github.com/Sirupsen/logrus"
+)
+
+// terraformVersionFileName is the name of the file, next to the step
+// templates, declaring the range of Terraform versions they support, e.g.
+// ">= 0.11.0, < 0.12.0".
+const terraformVersionFileName = "terraform-version"
+
+var (
+	terraformVersionRegexp  = regexp.MustCompile(`Terraform v(\d+)\.(\d+)\.(\d+)`)
+	versionConstraintRegexp = regexp.MustCompile(`^(>=|<=|!=|>|<|=)?\s*v?(\d+)\.(\d+)\.(\d+)$`)
+)
+
+// tfVersion is a Terraform version: major, minor and patch.
+type tfVersion [3]int
+
+// String returns the version as Terraform prints it.
+func (v tfVersion) String() string {
+	return fmt.Sprintf("v%d.%d.%d", v[0], v[1], v[2])
+}
+
+// compare returns -1, 0 or 1 if v is older, the same or newer than o.
+func (v tfVersion) compare(o tfVersion) int {
+	for i := range v {
+		if v[i] < o[i] {
+			return -1
+		}
+		if v[i] > o[i] {
+			return 1
+		}
+	}
+	return 0
+}
+
+// versionConstraint is a single comparison a version must satisfy.
+type versionConstraint struct {
+	op      string
+	version tfVersion
+}
+
+// satisfiedBy returns whether the given version satisfies the constraint.
+func (c versionConstraint) satisfiedBy(v tfVersion) bool {
+	cmp := v.compare(c.version)
+	switch c.op {
+	case ">=":
+		return cmp >= 0
+	case "<=":
+		return cmp <= 0
+	case ">":
+		return cmp > 0
+	case "<":
+		return cmp < 0
+	case "!=":
+		return cmp != 0
+	}
+	return cmp == 0
+}
+
+// parseTerraformVersion extracts the version from the output of
+// terraform version.
+func parseTerraformVersion(output string) (tfVersion, error) {
+	var v tfVersion
+	m := terraformVersionRegexp.FindStringSubmatch(output)
+	if m == nil {
+		return v, fmt.Errorf("unexpected output of terraform version: %q", strings.TrimSpace(output))
+	}
+	for i := range v {
+		v[i], _ = strconv.Atoi(m[i+1])
+	}
+	return v, nil
+}
+
+// parseVersionConstraints parses a comma-separated list of constraints, e.g.
+// ">= 0.11.0, < 0.12.0". A version without operator must match exactly.
+func parseVersionConstraints(s string) ([]versionConstraint, error) {
+	var constraints []versionConstraint
+	for _, part := range strings.Split(s, ",") {
+		m := versionConstraintRegexp.FindStringSubmatch(strings.TrimSpace(part))
+		if m == nil {
+			return nil, fmt.Errorf("invalid version constraint %q", strings.TrimSpace(part))
+		}
+		c := versionConstraint{op: m[1]}
+		for i := range c.version {
+			c.version[i], _ = strconv.Atoi(m[i+2])
+		}
+		constraints = append(constraints, c)
+	}
+	return constraints, nil
+}
+
+// readVersionConstraints reads the supported Terraform versions declared in
+// the given file. Empty lines and lines starting with # are ignored.
+func readVersionConstraints(path string) (string, []versionConstraint, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", nil, err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		constraints, err := parseVersionConstraints(line)
+		if err != nil {
+			return "", nil, fmt.Errorf("%s: %v", path, err)
+		}
+		return line, constraints, nil
+	}
+	return "", nil, fmt.Errorf("%s declares no version constraint", path)
+}
+
+// checkTerraform ensures, before the workflow locks or changes anything,
+// that the Terraform binary is supported by the step templates. Runners
+// other than the Terraform binary are not checked.
+func (m *State) checkTerraform(ctx context.Context) error {
+	if _, ok := m.runner.(*terraformRunner); m.runner != nil && !ok {
+		return nil
+	}
+	return checkTerraformVersion(ctx, m.terraformBinary, m.stepsDir, m.clusterDir)
+}
+
+// checkTerraformVersion runs terraform version, with the given binary or the
+// one looked up, and ensures that it is supported by the templates of the
+// given steps directory, which must declare the versions they support.
+func checkTerraformVersion(ctx context.Context, binaryPath, stepsDir, dir string) error {
+	stepsDir, err := stepsPath(stepsDir)
+	if err != nil {
+		return fmt.Errorf("error looking up supported Terraform versions: %v", err)
+	}
+	path := filepath.Join(stepsDir, terraformVersionFileName)
+	supported, constraints, err := readVersionConstraints(path)
+	if err != nil {
+		return fmt.Errorf("failed to read the supported Terraform versions: %v", err)
+	}
+
+	ex, err := newExecutor(binaryPath)
+	if err != nil {
+		return fmt.Errorf("Could not create Terraform executor: %s", err)
+	}
+	var output bytes.Buffer
+	ex.stdout = &output
+	if err := ex.execute(ctx, dir, "version"); err != nil {
+		return fmt.Errorf("Failed to run terraform version with %s: %v", ex.binaryPath, err)
+	}
+	version, err := parseTerraformVersion(output.String())
+	if err != nil {
+		return err
+	}
+
+	for _, c := range constraints {
+		if !c.satisfiedBy(version) {
+			return fmt.Errorf("Terraform %s at %s is not supported by the step templates, which require %s; install a supported version or point --terraform-binary to one", version, ex.binaryPath, supported)
+		}
+	}
+	log.Debugf("Using Terraform %s at %s", version, ex.binaryPath)
+	return nil
+}
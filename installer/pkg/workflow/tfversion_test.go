@@ -0,0 +1,217 @@
+package workflow
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestParseTerraformVersion(t *testing.T) {
+	testCases := []struct {
+		test          string
+		output        string
+		expected      tfVersion
+		expectedError bool
+	}{
+		{
+			test:     "release",
+			output:   "Terraform v0.11.7\n",
+			expected: tfVersion{0, 11, 7},
+		},
+		{
+			test:     "out of date",
+			output:   "Terraform v0.11.1\n\nYour version of Terraform is out of date! The latest version\nis 0.11.7.\n",
+			expected: tfVersion{0, 11, 1},
+		},
+		{
+			test:     "with providers",
+			output:   "Terraform v0.12.0-dev\n+ provider.aws v1.8.0\n",
+			expected: tfVersion{0, 12, 0},
+		},
+		{
+			test:          "not terraform",
+			output:        "usage: foo\n",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		got, err := parseTerraformVersion(tc.output)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %t, got: %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("Test case %s: expected %s, got %s", tc.test, tc.expected, got)
+		}
+	}
+}
+
+func TestVersionConstraints(t *testing.T) {
+	testCases := []struct {
+		test          string
+		constraints   string
+		version       tfVersion
+		expected      bool
+		expectedError bool
+	}{
+		{
+			test:        "within range",
+			constraints: ">= 0.11.0, < 0.12.0",
+			version:     tfVersion{0, 11, 7},
+			expected:    true,
+		},
+		{
+			test:        "too new",
+			constraints: ">= 0.11.0, < 0.12.0",
+			version:     tfVersion{0, 12, 0},
+		},
+		{
+			test:        "too old",
+			constraints: ">= 0.11.0, < 0.12.0",
+			version:     tfVersion{0, 10, 8},
+		},
+		{
+			test:        "exact",
+			constraints: "0.11.7",
+			version:     tfVersion{0, 11, 7},
+			expected:    true,
+		},
+		{
+			test:        "excluded",
+			constraints: ">= 0.11.0, != 0.11.2",
+			version:     tfVersion{0, 11, 2},
+		},
+		{
+			test:          "invalid",
+			constraints:   "~> 0.11",
+			expectedError: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		constraints, err := parseVersionConstraints(tc.constraints)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %t, got: %v", tc.test, tc.expectedError, err)
+			continue
+		}
+		got := true
+		for _, c := range constraints {
+			got = got && c.satisfiedBy(tc.version)
+		}
+		if err == nil && got != tc.expected {
+			t.Errorf("Test case %s: expected %s to satisfy %q: %t, got %t", tc.test, tc.version, tc.constraints, tc.expected, got)
+		}
+	}
+}
+
+func TestCheckTerraformVersion(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake terraform binary is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "tfversion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	testCases := []struct {
+		test          string
+		constraints   string
+		version       string
+		expectedError bool
+	}{
+		{
+			test:        "supported",
+			constraints: "# supported\n>= 0.11.0, < 0.12.0\n",
+			version:     "v0.11.7",
+		},
+		{
+			test:          "unsupported",
+			constraints:   "# supported\n>= 0.11.0, < 0.12.0\n",
+			version:       "v0.12.0",
+			expectedError: true,
+		},
+		{
+			test:          "no constraints file",
+			version:       "v0.11.7",
+			expectedError: true,
+		},
+		{
+			test:          "no constraint",
+			constraints:   "# supported\n",
+			version:       "v0.11.7",
+			expectedError: true,
+		},
+	}
+
+	for i, tc := range testCases {
+		stepsDir := filepath.Join(dir, strconv.Itoa(i), stepsBaseDir)
+		if err := os.MkdirAll(stepsDir, 0755); err != nil {
+			t.Fatal(err)
+		}
+		if tc.constraints != "" {
+			if err := ioutil.WriteFile(filepath.Join(stepsDir, terraformVersionFileName), []byte(tc.constraints), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		binary := writeFakeTerraform(t, dir, tc.version)
+		err := checkTerraformVersion(context.Background(), binary, stepsDir, dir)
+		if (err != nil) != tc.expectedError {
+			t.Errorf("Test case %s: expected error: %t, got: %v", tc.test, tc.expectedError, err)
+		}
+	}
+}
+
+func TestExecuteChecksTerraformFirst(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake terraform binary is a shell script")
+	}
+
+	dir, err := ioutil.TempDir("", "tfversion")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	var ran []string
+	record := func(ctx context.Context, m *State) error {
+		ran = append(ran, runningStep(ctx))
+		return nil
+	}
+	w, err := NewBuilder(dir).
+		Setup(NewStep("setup", record)).
+		Add(newTerraformStep("step", record)).
+		Build()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.state.stepsDir = filepath.Join(dir, stepsBaseDir)
+	w.SetTerraformBinary(writeFakeTerraform(t, dir, "v0.11.7"))
+
+	// The steps directory declares no supported Terraform version.
+	err = w.Execute(context.Background())
+	if err == nil || !strings.Contains(err.Error(), terraformVersionFileName) {
+		t.Fatalf("expected the missing %s to fail the workflow, got: %v", terraformVersionFileName, err)
+	}
+	if len(ran) != 0 {
+		t.Errorf("expected no step to run, got %v", ran)
+	}
+}
+
+// writeFakeTerraform writes to dir a fake Terraform binary printing the given
+// version.
+func writeFakeTerraform(t *testing.T, dir, version string) string {
+	binary := filepath.Join(dir, "terraform-"+version)
+	script := "#!/bin/sh\necho 'Terraform " + version + "'\n"
+	if err := ioutil.WriteFile(binary, []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return binary
+}
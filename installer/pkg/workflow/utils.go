@@ -2,13 +2,17 @@ package workflow
 
 import (
 	"bufio"
+	"context"
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"path"
 	"path/filepath"
 
+	log "github.com/Sirupsen/logrus"
+
 	"github.com/coreos/tectonic-installer/installer/pkg/config"
 	configgenerator "github.com/coreos/tectonic-installer/installer/pkg/config-generator"
 )
@@ -50,30 +54,49 @@ func copyFile(fromFilePath, toFilePath string) error {
 // returns the directory containing templates for a given step. If platform is
 // specified, it looks for a subdirectory with platform first, falling back if
 // there are no platform-specific templates for that step
-func findStepTemplates(stepName string, platform config.Platform) (string, error) {
-	base, err := baseLocation()
-	if err != nil {
-		return "", fmt.Errorf("error looking up step %s templates: %v", stepName, err)
-	}
+func findStepTemplates(stepsDir, stepName string, platform config.Platform) (string, error) {
 	for _, path := range []string{
-		filepath.Join(base, stepsBaseDir, stepName, platformPath(platform)),
-		filepath.Join(base, stepsBaseDir, stepName)} {
+		filepath.Join(stepsDir, stepName, platformPath(platform)),
+		filepath.Join(stepsDir, stepName)} {
 
 		stat, err := os.Stat(path)
 		if err != nil {
 			if os.IsNotExist(err) {
 				continue
 			}
-			return "", fmt.Errorf("invalid path for '%s' templates: %s", base, err)
+			return "", fmt.Errorf("invalid path for '%s' templates: %s", stepsDir, err)
 		}
 		if !stat.IsDir() {
-			return "", fmt.Errorf("invalid path for '%s' templates", base)
+			return "", fmt.Errorf("invalid path for '%s' templates", stepsDir)
 		}
 		return path, nil
 	}
 	return "", os.ErrNotExist
 }
 
+// stepTemplates returns the directory containing the templates of the given
+// step, for the platform of the cluster.
+func (m *State) stepTemplates(step string) (string, error) {
+	stepsDir, err := stepsPath(m.stepsDir)
+	if err != nil {
+		return "", fmt.Errorf("error looking up step %s templates: %v", step, err)
+	}
+	return findStepTemplates(stepsDir, step, m.cluster.Platform)
+}
+
+// stepsPath returns the given directory of step templates or, if empty, the
+// one next to the executable.
+func stepsPath(stepsDir string) (string, error) {
+	if stepsDir != "" {
+		return stepsDir, nil
+	}
+	base, err := baseLocation()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(base, stepsBaseDir), nil
+}
+
 func platformPath(platform config.Platform) string {
 	switch platform {
 	case config.PlatformLibvirt:
@@ -84,7 +107,10 @@ func platformPath(platform config.Platform) string {
 	panic("invalid platform")
 }
 
-func generateClusterConfigMaps(m *metadata) error {
+func generateClusterConfigMaps(ctx context.Context, m *State) error {
+	if m.skipInPlanMode("the cluster config maps") {
+		return nil
+	}
 	clusterGeneratedPath := filepath.Join(m.clusterDir, generatedPath)
 	if err := os.MkdirAll(clusterGeneratedPath, os.ModeDir|0755); err != nil {
 		return fmt.Errorf("Failed to create cluster generated directory at %s", clusterGeneratedPath)
@@ -141,8 +167,32 @@ func generateClusterConfigMaps(m *metadata) error {
 	return writeFile(tectonicSystemConfigFilePath, tectonicSystem)
 }
 
-func readClusterConfig(configFilePath string, internalFilePath string) (*config.Cluster, error) {
-	cfg, err := config.ParseConfigFile(configFilePath)
+// Lenient configures the workflow to accept config keys that match no field,
+// only warning about them, rather than refusing the config.
+func (w *Workflow) Lenient() {
+	w.state.lenient = true
+}
+
+// parseClusterConfig parses the given config, refusing the keys that match no
+// field unless lenient, in which case they are only logged.
+func parseClusterConfig(data []byte, lenient bool) (*config.Cluster, error) {
+	cluster, err := config.ParseConfig(data)
+	if _, ok := err.(*config.ErrUnknownFields); ok && lenient {
+		log.Warnf("Ignoring %v", err)
+		return config.ParseConfigLenient(data)
+	}
+	return cluster, err
+}
+
+// readClusterConfig reads the given config and, if a path is given, internal
+// files. The commands only describing a cluster read its config leniently, as
+// they would gain nothing from refusing it.
+func readClusterConfig(configFilePath string, internalFilePath string, lenient bool) (*config.Cluster, error) {
+	data, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	cfg, err := parseClusterConfig(data, lenient)
 	if err != nil {
 		return nil, fmt.Errorf("%s is not a valid config file: %s", configFilePath, err)
 	}
@@ -158,14 +208,14 @@ func readClusterConfig(configFilePath string, internalFilePath string) (*config.
 	return cfg, nil
 }
 
-func readClusterConfigStep(m *metadata) error {
+func readClusterConfigStep(m *State) error {
 	if m.clusterDir == "" {
 		return errors.New("no cluster dir given for reading config")
 	}
 	configFilePath := filepath.Join(m.clusterDir, configFileName)
 	internalFilePath := filepath.Join(m.clusterDir, internalFileName)
 
-	cluster, err := readClusterConfig(configFilePath, internalFilePath)
+	cluster, err := readClusterConfig(configFilePath, internalFilePath, m.lenient)
 	if err != nil {
 		return err
 	}
@@ -173,6 +223,9 @@ func readClusterConfigStep(m *metadata) error {
 	if err := cluster.ValidateAndLog(); err != nil {
 		return err
 	}
+	if err := m.checkImmutableFields(*cluster); err != nil {
+		return err
+	}
 
 	m.cluster = *cluster
 
@@ -207,20 +260,26 @@ func baseLocation() (string, error) {
 	return path.Dir(ex), nil
 }
 
-func clusterIsBootstrapped(stateDir string) bool {
-	return hasStateFile(stateDir, topologyStep) &&
-		hasStateFile(stateDir, mastersStep) &&
-		hasStateFile(stateDir, tncDNSStep)
+// clusterIsBootstrapped returns whether the states of the bootstrap steps
+// exist, asking the configured state backend, if any.
+func clusterIsBootstrapped(ctx context.Context, m *State) (bool, error) {
+	for _, step := range []string{topologyStep, mastersStep, tncDNSStep} {
+		has, err := m.hasState(ctx, step)
+		if err != nil || !has {
+			return false, err
+		}
+	}
+	return true, nil
 }
 
-func createTNCCNAME(m *metadata) error {
-	return runInstallStep(m, tncDNSStep, []string{bootstrapOn}...)
+func createTNCCNAME(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, tncDNSStep, []string{bootstrapOn}...)
 }
 
-func createTNCARecord(m *metadata) error {
-	return runInstallStep(m, tncDNSStep, []string{bootstrapOff}...)
+func createTNCARecord(ctx context.Context, m *State) error {
+	return runInstallStep(ctx, m, tncDNSStep, []string{bootstrapOff}...)
 }
 
-func destroyTNCDNS(m *metadata) error {
-	return runDestroyStep(m, tncDNSStep, []string{bootstrapOff}...)
+func destroyTNCDNS(ctx context.Context, m *State) error {
+	return runDestroyStep(ctx, m, tncDNSStep, []string{bootstrapOff}...)
 }
@@ -0,0 +1,88 @@
+package workflow
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+// parseErrorType is the type of the report entry of a config file that
+// cannot be parsed.
+const parseErrorType = "ParseError"
+
+// ValidateConfig checks the given config file without touching any cluster
+// directory, and returns the errors and warnings found in it. A file that
+// cannot be parsed is reported as a single error, and each key matching no
+// field as an error, or as a warning if lenient.
+func ValidateConfig(configFilePath string, lenient bool) ([]config.ValidationEntry, error) {
+	content, err := ioutil.ReadFile(configFilePath)
+	if err != nil {
+		return nil, err
+	}
+	var errs config.ErrorList
+	cluster, err := config.ParseConfig(content)
+	if unknown, ok := err.(*config.ErrUnknownFields); ok {
+		errs = unknown.ErrorList()
+		if !lenient {
+			return errs.ValidationEntries(), nil
+		}
+		for _, e := range errs {
+			e.Severity = config.SeverityWarning
+		}
+		cluster, err = config.ParseConfigLenient(content)
+	}
+	if err != nil {
+		return []config.ValidationEntry{{Message: err.Error(), Type: parseErrorType, Severity: config.SeverityError}}, nil
+	}
+	return append(errs, cluster.Validate()...).ValidationEntries(), nil
+}
+
+// HasValidationErrors tells whether any of the entries of a config validation
+// is an error, rather than a warning.
+func HasValidationErrors(entries []config.ValidationEntry) bool {
+	for _, e := range entries {
+		if e.Severity == config.SeverityError {
+			return true
+		}
+	}
+	return false
+}
+
+// PrintValidation writes the entries of a config validation to w, as
+// "severity: field: message (code, type)" lines or as JSON.
+func PrintValidation(w io.Writer, entries []config.ValidationEntry, format string) error {
+	switch format {
+	case OutputFormatJSON:
+		if entries == nil {
+			entries = []config.ValidationEntry{}
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	case OutputFormatText, "":
+	default:
+		return fmt.Errorf("invalid output format %q", format)
+	}
+
+	if len(entries) == 0 {
+		_, err := fmt.Fprintln(w, "The config is valid")
+		return err
+	}
+	for _, e := range entries {
+		msg := e.Message
+		if e.Field != "" {
+			msg = e.Field + ": " + msg
+		}
+		kind := e.Type
+		if e.Code != "" {
+			kind = string(e.Code) + ", " + kind
+		}
+		if _, err := fmt.Fprintf(w, "%s: %s (%s)\n", e.Severity, msg, kind); err != nil {
+			return err
+		}
+	}
+	return nil
+}
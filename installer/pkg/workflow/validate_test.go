@@ -0,0 +1,156 @@
+package workflow
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+func TestValidateConfig(t *testing.T) {
+	fixture, err := ioutil.ReadFile("./fixtures/aws.basic.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := ioutil.TempDir("", "validate")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ps, lic, err := generatePullSecretAndLicense("validate", time.Now().AddDate(1, 0, 0))
+	if err != nil {
+		t.Fatalf("failed to generate pull secret and license: %v", err)
+	}
+	defer os.Remove(ps.Name())
+	defer os.Remove(lic.Name())
+	base := strings.NewReplacer(
+		"licensePath:", "licensePath: "+lic.Name(),
+		"pullSecretPath:", "pullSecretPath: "+ps.Name(),
+	).Replace(string(fixture))
+
+	testCases := []struct {
+		test             string
+		edit             func(string) string
+		lenient          bool
+		expectedField    string
+		expectedType     string
+		expectedSeverity config.Severity
+	}{
+		{
+			test: "valid config",
+			edit: func(c string) string { return c },
+		},
+		{
+			test:             "unmatched node pool",
+			edit:             func(c string) string { return strings.Replace(c, "    - worker\n", "    - workers\n", 1) },
+			expectedField:    "worker.nodePools[0]",
+			expectedType:     "ErrUnmatchedNodePool",
+			expectedSeverity: config.SeverityError,
+		},
+		{
+			test:             "even etcd count",
+			edit:             func(c string) string { return strings.Replace(c, "count: 3", "count: 2", 1) },
+			expectedField:    "etcd.nodePools",
+			expectedType:     "ErrEvenEtcdCount",
+			expectedSeverity: config.SeverityWarning,
+		},
+		{
+			test:             "unknown field",
+			edit:             func(c string) string { return strings.Replace(c, "nodePools:", "nodePool:", 1) },
+			expectedField:    "etcd.nodePool",
+			expectedType:     "ErrUnknownField",
+			expectedSeverity: config.SeverityError,
+		},
+		{
+			test:             "unknown field, lenient",
+			edit:             func(c string) string { return strings.Replace(c, "platform: aws", "platform: aws\nplatfrom: aws", 1) },
+			lenient:          true,
+			expectedField:    "platfrom",
+			expectedType:     "ErrUnknownField",
+			expectedSeverity: config.SeverityWarning,
+		},
+		{
+			test:             "invalid YAML",
+			edit:             func(c string) string { return c + "\n\tname: [" },
+			expectedType:     parseErrorType,
+			expectedSeverity: config.SeverityError,
+		},
+	}
+
+	for _, tc := range testCases {
+		path := filepath.Join(dir, "config.yaml")
+		if err := ioutil.WriteFile(path, []byte(tc.edit(base)), 0644); err != nil {
+			t.Fatal(err)
+		}
+		entries, err := ValidateConfig(path, tc.lenient)
+		if err != nil {
+			t.Errorf("Test case %s: unexpected error: %v", tc.test, err)
+			continue
+		}
+		if tc.expectedType == "" {
+			if len(entries) != 0 {
+				t.Errorf("Test case %s: expected no entries, got %v", tc.test, entries)
+			}
+			continue
+		}
+		if len(entries) != 1 || entries[0].Type != tc.expectedType || entries[0].Field != tc.expectedField || entries[0].Severity != tc.expectedSeverity {
+			t.Errorf("Test case %s: expected one %s %s entry for field %q, got %v", tc.test, tc.expectedSeverity, tc.expectedType, tc.expectedField, entries)
+		}
+		if HasValidationErrors(entries) != (tc.expectedSeverity == config.SeverityError) {
+			t.Errorf("Test case %s: expected errors: %v", tc.test, tc.expectedSeverity == config.SeverityError)
+		}
+	}
+
+	if _, err := ValidateConfig(filepath.Join(dir, "missing.yaml"), false); err == nil {
+		t.Error("expected an error for a missing config file")
+	}
+}
+
+func TestPrintValidation(t *testing.T) {
+	entries := []config.ValidationEntry{
+		{Field: "worker.nodePools[0]", Message: `no node pool named "workers" was found`, Type: "ErrUnmatchedNodePool", Code: config.ErrorCodeNotFound, Severity: config.SeverityError},
+		{Message: "invalid YAML", Type: parseErrorType, Severity: config.SeverityError},
+		{Field: "etcd.nodePools", Message: "the etcd node pools have 2 nodes", Type: "ErrEvenEtcdCount", Code: config.ErrorCodeEvenEtcdCount, Severity: config.SeverityWarning},
+	}
+
+	var buf bytes.Buffer
+	if err := PrintValidation(&buf, entries, OutputFormatText); err != nil {
+		t.Fatal(err)
+	}
+	expected := `error: worker.nodePools[0]: no node pool named "workers" was found (NotFound, ErrUnmatchedNodePool)
+error: invalid YAML (ParseError)
+warning: etcd.nodePools: the etcd node pools have 2 nodes (EvenEtcdCount, ErrEvenEtcdCount)
+`
+	if buf.String() != expected {
+		t.Errorf("expected text report %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	if err := PrintValidation(&buf, entries, OutputFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded []map[string]string
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("invalid JSON report %q: %v", buf.String(), err)
+	}
+	if len(decoded) != 3 || decoded[0]["field"] != "worker.nodePools[0]" || decoded[0]["type"] != "ErrUnmatchedNodePool" || decoded[0]["code"] != "NotFound" || decoded[2]["severity"] != "warning" {
+		t.Errorf("unexpected JSON report %v", decoded)
+	}
+	if _, ok := decoded[1]["field"]; ok {
+		t.Errorf("expected no field in the second entry, got %v", decoded[1])
+	}
+
+	buf.Reset()
+	if err := PrintValidation(&buf, nil, OutputFormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(buf.String()) != "[]" {
+		t.Errorf("expected an empty JSON list, got %q", buf.String())
+	}
+}
@@ -1,38 +1,375 @@
 package workflow
 
-import "github.com/coreos/tectonic-installer/installer/pkg/config"
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
 
-// metadata is the state store of the current workflow execution.
+	log "github.com/Sirupsen/logrus"
+
+	"github.com/coreos/tectonic-installer/installer/pkg/config"
+)
+
+// State is the state store of the current workflow execution.
 // It is meant to carry state for one step to another.
 // When creating a new workflow, initial state from external parameters
-// is also injected by when initializing the metadata object.
-// Steps taked their inputs from the metadata object and persist
+// is also injected by when initializing the State object.
+// Steps taked their inputs from the State object and persist
 // results onto it for later consumption.
-type metadata struct {
+type State struct {
 	cluster        config.Cluster
 	configFilePath string
 	clusterDir     string
+	resume         bool
+	checkpoints    *checkpoints
+	plan           bool
+	savedPlans     bool
+	plans          []planSummary
+	retryPolicy    *RetryPolicy
+	runner         Runner
+	// terraformBinary is the Terraform binary run by the default runner;
+	// by default, it is looked up.
+	terraformBinary string
+	// stepsDir is where the step templates are looked up; by default, next
+	// to the executable.
+	stepsDir string
+	// offline makes the default runner use the Terraform providers of
+	// pluginDir instead of downloading them.
+	offline   bool
+	pluginDir string
+	// rollbackOnFailure makes a failed run destroy the Terraform states
+	// it created, which are listed in created.
+	rollbackOnFailure bool
+	created           []string
+	// applied is set once a step applied changes to the cluster, whose
+	// config is then recorded as applied at the end of a successful run; see
+	// saveAppliedConfigOnce.
+	applied bool
+	// reapplied records the workflow steps that applied changes during this
+	// run, or depend on a step that did. They are never skipped on resume.
+	reapplied map[string]bool
+	// affectedSteps are the steps the 'apply' workflow re-runs.
+	affectedSteps map[string]bool
+	// allowRecreate lets the config change fields that recreate the
+	// cluster; see AllowRecreate.
+	allowRecreate bool
+	// lenient accepts config keys that match no field, only warning about
+	// them.
+	lenient bool
+	// confirm asks the user to confirm changes before they are applied.
+	confirm func(question string) (bool, error)
+	// forceDestroy makes targeted destroy workflows ignore the states
+	// still relying on the steps they destroy.
+	forceDestroy bool
+	// skipped records the workflow steps that found nothing to do, e.g. a
+	// destroy step without state, for the report of ContinueOnError.
+	skipped map[string]bool
+	// lock guards the fields above that steps update while running
+	// concurrently. It is set by Workflow.Execute.
+	lock *sync.Mutex
+}
+
+// ClusterDir returns the directory of the cluster the workflow runs against.
+func (m *State) ClusterDir() string {
+	return m.clusterDir
+}
+
+// Cluster returns the cluster config, once loaded by the setup steps.
+func (m *State) Cluster() config.Cluster {
+	return m.cluster
+}
+
+// withLock runs f while holding the state lock, if any.
+func (m *State) withLock(f func() error) error {
+	if m.lock != nil {
+		m.lock.Lock()
+		defer m.lock.Unlock()
+	}
+	return f()
+}
+
+// StepFunc is the entrypoint of a workflow step implementation.
+// To add a new step, put your logic in a function that matches this signature
+// and turn it into a Step with NewStep.
+type StepFunc func(context.Context, *State) error
+
+// Step is a named unit of work of a workflow.
+// Its name is stable and is used to select steps from the command line.
+type Step interface {
+	Name() string
+	Run(ctx context.Context, s *State) error
 }
 
-// Step is the entrypoint of a workflow step implementation.
-// To add a new step, put your logic in a function that matches this signature.
-// Next, add a reference to this new function in a Workflow's steps list.
-type Step func(*metadata) error
+// NewStep returns a step with the given name, running the given function.
+func NewStep(name string, run StepFunc) Step {
+	return funcStep{name: name, run: run}
+}
+
+// newTerraformStep returns a step with the given name, running the given
+// function, which runs Terraform commands.
+func newTerraformStep(name string, run StepFunc) Step {
+	return funcStep{name: name, run: run, terraform: true}
+}
+
+// runsTerraform returns whether the given step runs Terraform commands.
+func runsTerraform(step Step) bool {
+	s, ok := step.(funcStep)
+	return ok && s.terraform
+}
+
+// funcStep is a step implemented by a StepFunc.
+type funcStep struct {
+	name string
+	run  StepFunc
+	// terraform is set if the step runs Terraform commands, for which the
+	// workflow checks the Terraform binary before anything runs.
+	terraform bool
+}
+
+// Name returns the stable name of the step.
+func (s funcStep) Name() string {
+	return s.name
+}
 
-// Workflow is a high-level representation
-// of a set of actions performed in a predictable order.
+// Run runs the step.
+func (s funcStep) Run(ctx context.Context, m *State) error {
+	return s.run(ctx, m)
+}
+
+// stepNode is a step placed in the dependency graph of a workflow.
+type stepNode struct {
+	Step
+	// deps are the names of the steps that must complete before this one
+	// starts. Dependencies that are not part of a workflow are ignored.
+	deps []string
+	// setup steps load the state the other steps rely on, e.g. the cluster
+	// config. They run first, one after the other, regardless of the step
+	// selection.
+	setup bool
+}
+
+// newStep returns a step with the given name, which runs once all the named
+// dependencies completed.
+func newStep(name string, run StepFunc, deps ...string) stepNode {
+	return stepNode{Step: NewStep(name, run), deps: deps}
+}
+
+// newSetupStep returns a step with the given name that is never filtered out
+// by a step selection.
+func newSetupStep(name string, run StepFunc) stepNode {
+	return stepNode{Step: NewStep(name, run), setup: true}
+}
+
+// Workflow is a high-level representation of a set of actions, forming a
+// dependency graph. Steps whose dependencies are met run concurrently, up to
+// the workflow's parallelism.
 type Workflow struct {
-	metadata metadata
-	steps    []Step
+	state       State
+	steps       []stepNode
+	parallelism int
+	observer    Observer
+	// timeouts are the maximum durations of steps, by name. The empty name
+	// applies to all steps without a timeout of their own.
+	timeouts map[string]time.Duration
+	// continueOnError makes a failed step only stop the steps depending on
+	// it; see ContinueOnError.
+	continueOnError bool
+}
+
+// Resume configures the workflow to skip the Terraform steps that a previous
+// run already completed with identical inputs.
+func (w *Workflow) Resume() {
+	w.state.resume = true
+}
+
+// Plan configures the workflow to run terraform plan instead of applying or
+// destroying each step. The plans are saved in the cluster directory and a
+// summary of the changes is printed once all steps ran.
+func (w *Workflow) Plan() {
+	w.state.plan = true
 }
 
-// Execute runs all steps in order.
-func (w Workflow) Execute() error {
+// UseSavedPlans configures the workflow to apply the plans saved by a previous
+// run in plan mode, so that exactly the reviewed changes are made.
+func (w *Workflow) UseSavedPlans() {
+	w.state.savedPlans = true
+}
+
+// SetParallelism sets the maximum number of steps that run at the same time.
+func (w *Workflow) SetParallelism(n int) {
+	w.parallelism = n
+}
+
+// SetRetryPolicy sets how the TerraForm steps of the workflow are retried
+// when they fail with a transient error.
+func (w *Workflow) SetRetryPolicy(p RetryPolicy) {
+	w.state.retryPolicy = &p
+}
+
+// SetStepTimeout limits the duration of the named step. Once the timeout
+// elapsed, the step is interrupted and fails. An empty name sets the default
+// timeout of all steps.
+func (w *Workflow) SetStepTimeout(name string, timeout time.Duration) {
+	if w.timeouts == nil {
+		w.timeouts = make(map[string]time.Duration)
+	}
+	w.timeouts[name] = timeout
+}
+
+// Steps returns the names of the steps the workflow will run, in order.
+func (w Workflow) Steps() []string {
+	names := make([]string, 0, len(w.steps))
 	for _, step := range w.steps {
-		if err := step(&w.metadata); err != nil {
+		names = append(names, step.Name())
+	}
+	return names
+}
+
+// Execute checks the Terraform binary, locks the cluster directory, runs the
+// setup steps in order, then all other steps as soon as their dependencies completed. Once a step fails
+// or the context is done, no new step is started and the running steps are
+// interrupted; all errors are returned.
+func (w Workflow) Execute(ctx context.Context) error {
+	var setup, steps []stepNode
+	for _, step := range w.steps {
+		if step.setup {
+			setup = append(setup, step)
+		} else {
+			steps = append(steps, step)
+		}
+	}
+	for _, step := range steps {
+		if !runsTerraform(step.Step) {
+			continue
+		}
+		if err := w.state.checkTerraform(ctx); err != nil {
+			return err
+		}
+		break
+	}
+
+	if w.state.clusterDir != "" {
+		lock, err := acquireLock(w.state.clusterDir)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			if err := lock.release(); err != nil {
+				log.Errorf("Failed to release the cluster directory lock: %v", err)
+			}
+		}()
+	}
+
+	for _, step := range setup {
+		if err := w.runStep(ctx, step); err != nil {
+			return &ExecutionError{Errors: []StepError{{Step: step.Name(), Err: err}}}
+		}
+	}
+
+	if len(steps) != 0 && w.state.clusterDir != "" {
+		if err := w.state.checkOfflinePlugins(); err != nil {
+			return err
+		}
+		if err := w.state.checkStateBackend(ctx); err != nil {
 			return err
 		}
 	}
 
+	if w.continueOnError && !w.state.plan {
+		return w.runContinuingOnError(ctx, steps, os.Stdout)
+	}
+
+	if err := w.run(ctx, steps, nil); err != nil {
+		if w.state.rollbackOnFailure && !w.state.plan {
+			if ctx.Err() != nil {
+				log.Warnf("Not rolling back the steps applied by this run as it was interrupted: %s", strings.Join(w.state.created, ", "))
+				return err
+			}
+			return &RollbackError{Err: err, Cleanup: w.state.rollback(ctx)}
+		}
+		return err
+	}
+
+	if w.state.plan {
+		sortPlans(w.state.plans, w.Steps())
+		if err := w.state.discardReplannedPlans(); err != nil {
+			return err
+		}
+		return printPlanSummary(os.Stdout, w.state.plans)
+	}
+	if w.state.applied {
+		return w.state.saveAppliedConfig()
+	}
 	return nil
 }
+
+type stepNameKey struct{}
+
+// runningStep returns the name of the workflow step running in the given
+// context, if any.
+func runningStep(ctx context.Context) string {
+	name, _ := ctx.Value(stepNameKey{}).(string)
+	return name
+}
+
+// runStep runs a single step within its timeout. A step that is cut short by
+// an interruption or a timeout is recorded as such in the cluster directory.
+func (w *Workflow) runStep(ctx context.Context, step stepNode) error {
+	timeout, ok := w.timeouts[step.Name()]
+	if !ok {
+		timeout = w.timeouts[""]
+	}
+	stepCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		stepCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	w.state.inheritReapplied(step.Name(), step.deps)
+	if w.state.clusterDir != "" {
+		at, err := w.state.clearInterruption(step.Name())
+		if err != nil {
+			return err
+		}
+		if !at.IsZero() {
+			log.Warnf("Step %s was cut short by a previous run at %s; running it again", step.Name(), at.Format(time.RFC3339))
+		}
+	}
+
+	stepCtx = context.WithValue(stepCtx, stepNameKey{}, step.Name())
+	var stepLog *stepLog
+	if w.state.clusterDir != "" && !step.setup {
+		stepLog = w.state.newStepLog(step.Name())
+		stepCtx = withStepLog(stepCtx, stepLog)
+	}
+
+	if w.observer != nil {
+		w.observer.StepStarted(step.Name())
+	}
+	started := time.Now()
+	err := step.Run(stepCtx, &w.state)
+	if w.observer != nil {
+		w.observer.StepFinished(step.Name(), err, time.Since(started))
+	}
+	if stepLog != nil {
+		stepLog.close(err)
+	}
+	if err == nil || stepCtx.Err() == nil {
+		return err
+	}
+
+	if stepCtx.Err() == context.DeadlineExceeded && ctx.Err() == nil {
+		err = fmt.Errorf("timed out after %s: %v", timeout, err)
+	}
+	log.Warnf("Step %s was cut short: %v", step.Name(), err)
+	if w.state.clusterDir != "" {
+		if recErr := w.state.interruptStep(step.Name()); recErr != nil {
+			log.Errorf("Failed to record interruption of step %s: %v", step.Name(), recErr)
+		}
+	}
+	return err
+}
@@ -1,40 +1,44 @@
 package workflow
 
 import (
+	"context"
 	"errors"
+	"io/ioutil"
+	"os"
 	"testing"
+	"time"
 )
 
-func test1Step(m *metadata) error {
+func test1Step(ctx context.Context, m *State) error {
 	return nil
 }
 
-func test2Step(m *metadata) error {
+func test2Step(ctx context.Context, m *State) error {
 	return nil
 }
 
-func test3Step(m *metadata) error {
+func test3Step(ctx context.Context, m *State) error {
 	return errors.New("step failed")
 }
 
 func TestWorkflowTypeExecute(t *testing.T) {
-	m := metadata{}
+	m := State{}
 
 	testCases := []struct {
 		test          string
-		steps         []Step
-		m             metadata
+		steps         []stepNode
+		m             State
 		expectedError bool
 	}{
 		{
 			test:          "All steps succeed",
-			steps:         []Step{test1Step, test2Step},
+			steps:         []stepNode{newStep("test1", test1Step), newStep("test2", test2Step)},
 			m:             m,
 			expectedError: false,
 		},
 		{
 			test:          "At least one step fails",
-			steps:         []Step{test1Step, test2Step, test3Step},
+			steps:         []stepNode{newStep("test1", test1Step), newStep("test2", test2Step), newStep("test3", test3Step)},
 			m:             m,
 			expectedError: true,
 		},
@@ -42,12 +46,42 @@ func TestWorkflowTypeExecute(t *testing.T) {
 
 	for _, tc := range testCases {
 		wf := Workflow{
-			metadata: tc.m,
-			steps:    tc.steps,
+			state: tc.m,
+			steps: tc.steps,
 		}
-		err := wf.Execute()
+		err := wf.Execute(context.Background())
 		if (err != nil) != tc.expectedError {
 			t.Errorf("Test case %s: WorkflowType.Execute() expected error: %v, got: %v", tc.test, tc.expectedError, (err != nil))
 		}
 	}
 }
+
+func TestWorkflowStepTimeout(t *testing.T) {
+	clusterDir, err := ioutil.TempDir("", "workflow")
+	if err != nil {
+		t.Fatalf("failed to create cluster dir: %v", err)
+	}
+	defer os.RemoveAll(clusterDir)
+
+	blocking := func(ctx context.Context, m *State) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}
+	wf := Workflow{
+		state: State{clusterDir: clusterDir},
+		steps: []stepNode{newStep("slow", blocking), newStep("fast", test1Step)},
+	}
+	wf.SetStepTimeout("slow", 10*time.Millisecond)
+
+	if err := wf.Execute(context.Background()); err == nil {
+		t.Fatalf("expected the slow step to time out")
+	}
+
+	cp, err := loadCheckpoints(clusterDir)
+	if err != nil {
+		t.Fatalf("failed to load checkpoints: %v", err)
+	}
+	if _, ok := cp.Interrupted["slow"]; !ok {
+		t.Errorf("expected the slow step to be recorded as interrupted, got %v", cp.Interrupted)
+	}
+}